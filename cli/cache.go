@@ -0,0 +1,158 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"mcphub/services"
+
+	"github.com/spf13/cobra"
+)
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Manage the cache of pulled images",
+	Long: `Pulled images are kept in a content-addressed cache under the user cache directory, so pulling
+an image again, or another image sharing its layers, only downloads what changed. After each pull
+the least recently used images are evicted once the cache grows past MCPHUB_CACHE_MAX_SIZE
+(default 10g).`,
+}
+
+var cacheLsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List cached images",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cache, err := artifactCache()
+		if err != nil {
+			return err
+		}
+		images, err := cache.Images()
+		if err != nil {
+			return err
+		}
+		usage, err := cache.Usage()
+		if err != nil {
+			return err
+		}
+
+		if len(images) > 0 {
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "IMAGE\tDIGEST\tSIZE\tLAST USED")
+			for _, image := range images {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", image.Ref, services.ShortDigest(image.Digest), services.FormatBytes(image.Size), image.LastUsed.Local().Format(time.DateTime))
+			}
+			if err := w.Flush(); err != nil {
+				return err
+			}
+		} else {
+			fmt.Println("🗃️  No cached images")
+		}
+		fmt.Printf("💾 %s in %d files at %s\n", services.FormatBytes(usage.Size), usage.Blobs, cache.Dir())
+		return nil
+	},
+}
+
+var cachePruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove unused blobs and stale partial downloads, evicting images over the size limit",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cache, err := artifactCache()
+		if err != nil {
+			return err
+		}
+		maxSize, err := cacheMaxSize()
+		if err != nil {
+			return err
+		}
+
+		before, err := cache.Usage()
+		if err != nil {
+			return err
+		}
+		evicted, err := cache.Prune(maxSize)
+		if err != nil {
+			return err
+		}
+		after, err := cache.Usage()
+		if err != nil {
+			return err
+		}
+
+		for _, image := range evicted {
+			fmt.Printf("🗑️  Evicted %s\n", image.Ref)
+		}
+		fmt.Printf("✅ Freed %s, %s left in the cache\n", services.FormatBytes(before.Size-after.Size), services.FormatBytes(after.Size))
+		return nil
+	},
+}
+
+var cacheClearCmd = &cobra.Command{
+	Use:   "clear",
+	Short: "Remove every cached image",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cache, err := artifactCache()
+		if err != nil {
+			return err
+		}
+		usage, err := cache.Usage()
+		if err != nil {
+			return err
+		}
+		if err := cache.Clear(); err != nil {
+			return err
+		}
+		fmt.Printf("✅ Cleared the cache, freeing %s\n", services.FormatBytes(usage.Size))
+		return nil
+	},
+}
+
+// artifactCache opens the cache of pulled images, under MCPHUB_CACHE_DIR when set
+func artifactCache() (*services.ArtifactCache, error) {
+	dir := os.Getenv("MCPHUB_CACHE_DIR")
+	if dir == "" {
+		var err error
+		if dir, err = services.DefaultArtifactCacheDir(); err != nil {
+			return nil, err
+		}
+	}
+	return services.NewArtifactCache(dir)
+}
+
+// cacheMaxSize returns the cache size limit from --max-size or MCPHUB_CACHE_MAX_SIZE
+func cacheMaxSize() (int64, error) {
+	value := cacheMaxSizeFlag
+	if value == "" {
+		value = os.Getenv("MCPHUB_CACHE_MAX_SIZE")
+	}
+	if value == "" {
+		return services.DefaultCacheMaxSize, nil
+	}
+	size, err := services.ParseSize(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid cache size limit: %v", err)
+	}
+	return size, nil
+}
+
+// autoPruneCache evicts the least recently used images once the cache is over its size limit
+func autoPruneCache(cache *services.ArtifactCache) error {
+	maxSize, err := cacheMaxSize()
+	if err != nil {
+		return err
+	}
+	usage, err := cache.Usage()
+	if err != nil || usage.Size <= maxSize {
+		return err
+	}
+
+	evicted, err := cache.Prune(maxSize)
+	for _, image := range evicted {
+		fmt.Printf("🗑️  Evicted %s from the cache\n", image.Ref)
+	}
+	return err
+}
@@ -0,0 +1,111 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"mcphub/models"
+	"mcphub/services"
+
+	"github.com/spf13/cobra"
+)
+
+var callCmd = &cobra.Command{
+	Use:   "call [author/image-name] <tool>",
+	Short: "Invoke a single tool on an MCP server",
+	Long: `Launch an MCP server image over stdio (or connect to a running server with --url),
+perform the MCP handshake, call one tool and print its result.
+Exits with a non-zero status when the tool reports an error.`,
+	Args: cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if callOutputFlag != "text" && callOutputFlag != "json" {
+			return fmt.Errorf("invalid output format %q. Use: text or json", callOutputFlag)
+		}
+
+		toolName := args[len(args)-1]
+		client, err := connectMCP(cmd.Context(), args[:len(args)-1])
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		ctx, cancel := context.WithTimeout(cmd.Context(), callTimeoutFlag)
+		defer cancel()
+
+		if _, err := client.Initialize(ctx, services.MCPProtocolVersion); err != nil {
+			return fmt.Errorf("failed to connect to server: %v", err)
+		}
+
+		tools, err := client.ListTools(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list tools: %v", err)
+		}
+		var tool *models.Tool
+		for i := range tools {
+			if tools[i].Name == toolName {
+				tool = &tools[i]
+				break
+			}
+		}
+		if tool == nil {
+			return fmt.Errorf("server has no tool named %q", toolName)
+		}
+
+		arguments, err := services.ParseToolArguments(tool.InputSchema, jsonArgsFlag, argFlags)
+		if err != nil {
+			return err
+		}
+
+		result, err := client.CallTool(ctx, toolName, arguments)
+		if err != nil {
+			return err
+		}
+
+		if callOutputFlag == "json" {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			if err := encoder.Encode(result); err != nil {
+				return err
+			}
+		} else {
+			out := os.Stdout
+			if result.IsError {
+				out = os.Stderr
+			}
+			printToolContent(out, result.Content)
+		}
+
+		if result.IsError {
+			return fmt.Errorf("tool %q reported an error", toolName)
+		}
+		return nil
+	},
+}
+
+// printToolContent writes text content as-is and summarises binary and embedded content
+func printToolContent(out *os.File, content []models.ContentBlock) {
+	for _, block := range content {
+		switch block.Type {
+		case "text":
+			fmt.Fprintln(out, block.Text)
+		case "image", "audio":
+			fmt.Fprintf(out, "[%s: %s, %d base64 bytes]\n", block.Type, block.MimeType, len(block.Data))
+		case "resource_link":
+			fmt.Fprintf(out, "[resource link: %s]\n", block.URI)
+		case "resource":
+			var resource struct {
+				URI  string `json:"uri"`
+				Text string `json:"text"`
+			}
+			json.Unmarshal(block.Resource, &resource)
+			fmt.Fprintf(out, "[resource: %s]\n", resource.URI)
+			if resource.Text != "" {
+				fmt.Fprintln(out, resource.Text)
+			}
+		default:
+			fmt.Fprintf(out, "[%s content]\n", block.Type)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"mcphub/services"
+
+	"github.com/spf13/cobra"
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Generate MCP host configuration for pulled servers",
+}
+
+var configExportCmd = &cobra.Command{
+	Use:   "export <author/image-name>",
+	Short: "Emit the mcpServers entry that runs a pulled image",
+	Long: `Emit the configuration entry an MCP host (Claude Desktop, VS Code, Cursor, ...) needs to run
+a pulled image via "docker run -i --rm" (or podman with --engine podman). Servers that speak HTTP get
+an entry with their URL instead, and are started with mcphub run. Use --write to merge it into the
+host's default config file or --file to merge it into a specific file.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if !slices.Contains(services.SupportedClients, clientFlag) {
+			return fmt.Errorf("invalid client %q. Use: %s", clientFlag, strings.Join(services.SupportedClients, ", "))
+		}
+
+		engine, err := containerEngine()
+		if err != nil {
+			return err
+		}
+
+		imageName := services.ImageFromRef(args[0])
+		config, err := services.ReadImageConfig(cmd.Context(), engine, imageName)
+		if err != nil {
+			return fmt.Errorf("%v (pull it first with: mcphub pull %s)", err, args[0])
+		}
+
+		entry, err := services.ClientServerEntry(clientFlag, imageName, config)
+		if err != nil {
+			return err
+		}
+		if _, ok := entry["command"]; ok {
+			entry["command"] = engine.Name()
+		}
+
+		configPath := configFileFlag
+		if configPath == "" && writeFlag {
+			if configPath, err = services.ClientConfigPath(clientFlag); err != nil {
+				return err
+			}
+		}
+
+		if configPath == "" {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(services.ClientConfigDocument(clientFlag, config.Name, entry))
+		}
+
+		existing, err := os.ReadFile(configPath)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to read %s: %v", configPath, err)
+		}
+
+		merged, err := services.MergeClientConfig(existing, clientFlag, config.Name, entry)
+		if err != nil {
+			return fmt.Errorf("failed to merge into %s: %v", configPath, err)
+		}
+
+		if err := os.MkdirAll(filepath.Dir(configPath), 0755); err != nil {
+			return fmt.Errorf("failed to create config directory: %v", err)
+		}
+		if err := os.WriteFile(configPath, merged, 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %v", configPath, err)
+		}
+
+		fmt.Printf("✅ Added '%s' to %s\n", config.Name, configPath)
+		if config.Run.UsesHTTP() {
+			fmt.Printf("💡 Start the server with: mcphub run -d -p 127.0.0.1:%d:%d %s\n", config.Run.Port, config.Run.Port, imageName)
+		} else {
+			for _, decl := range config.Env {
+				if decl.Required && decl.Default == "" {
+					fmt.Printf("🔑 Fill in %s in the entry's env section\n", decl.Name)
+				}
+			}
+		}
+		fmt.Printf("💡 Restart %s to pick up the new server\n", clientFlag)
+		return nil
+	},
+}
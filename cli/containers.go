@@ -0,0 +1,145 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"mcphub/models"
+	"mcphub/services"
+
+	"github.com/spf13/cobra"
+)
+
+var psCmd = &cobra.Command{
+	Use:   "ps",
+	Short: "List containers started by mcphub run",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		engine, err := containerEngine()
+		if err != nil {
+			return err
+		}
+		containers, err := services.ListManagedContainers(cmd.Context(), engine)
+		if err != nil {
+			return err
+		}
+
+		var shown []models.ManagedContainer
+		for _, container := range containers {
+			if psAllFlag || container.State == "running" {
+				shown = append(shown, container)
+			}
+		}
+		if len(shown) == 0 {
+			fmt.Println("📦 No MCPHub containers")
+			return nil
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tSERVER\tVERSION\tSTATUS\tPORTS")
+		for _, container := range shown {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", container.Name, container.Server, orDash(container.Version), container.Status, orDash(container.Ports))
+		}
+		return w.Flush()
+	},
+}
+
+var logsCmd = &cobra.Command{
+	Use:   "logs <server>",
+	Short: "Show the logs of an MCPHub container",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		engine, err := containerEngine()
+		if err != nil {
+			return err
+		}
+		containers, err := services.FindManagedContainers(cmd.Context(), engine, args[0])
+		if err != nil {
+			return err
+		}
+		if len(containers) > 1 {
+			names := make([]string, len(containers))
+			for i, container := range containers {
+				names[i] = container.Name
+			}
+			return fmt.Errorf("%s runs in several containers (%s); pass a container name", args[0], strings.Join(names, ", "))
+		}
+		return engine.ContainerLogs(cmd.Context(), containers[0].ID, followFlag, tailFlag, os.Stdout, os.Stderr)
+	},
+}
+
+var stopCmd = &cobra.Command{
+	Use:   "stop <server>",
+	Short: "Stop the MCPHub containers of a server",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return eachManagedContainer(cmd.Context(), args[0], "🛑 Stopped", services.ContainerEngine.StopContainer, false)
+	},
+}
+
+var restartCmd = &cobra.Command{
+	Use:   "restart <server>",
+	Short: "Restart the MCPHub containers of a server",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return eachManagedContainer(cmd.Context(), args[0], "🔄 Restarted", services.ContainerEngine.RestartContainer, false)
+	},
+}
+
+var rmCmd = &cobra.Command{
+	Use:   "rm <server>",
+	Short: "Remove the MCPHub containers of a server",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return eachManagedContainer(cmd.Context(), args[0], "🗑️  Removed", func(engine services.ContainerEngine, ctx context.Context, id string) error {
+			return engine.RemoveContainer(ctx, id, forceRmFlag)
+		}, true)
+	},
+}
+
+// eachManagedContainer applies action to every managed container of a server (or the named container).
+// Containers on the allowlist network take their egress proxy along: the action is applied to the
+// proxy first, or the proxy and network are removed afterwards when removeEgress is set.
+func eachManagedContainer(ctx context.Context, ref, done string, action func(engine services.ContainerEngine, ctx context.Context, id string) error, removeEgress bool) error {
+	engine, err := containerEngine()
+	if err != nil {
+		return err
+	}
+	containers, err := services.FindManagedContainers(ctx, engine, ref)
+	if err != nil {
+		return err
+	}
+	for _, container := range containers {
+		if !removeEgress {
+			proxies, err := services.EgressProxies(ctx, engine, container.Name)
+			if err != nil {
+				return err
+			}
+			for _, proxy := range proxies {
+				if err := action(engine, ctx, proxy.ID); err != nil {
+					return fmt.Errorf("%s: %v", proxy.Name, err)
+				}
+			}
+		}
+		if err := action(engine, ctx, container.ID); err != nil {
+			return fmt.Errorf("%s: %v", container.Name, err)
+		}
+		if removeEgress {
+			if err := services.RemoveEgress(ctx, engine, container.Name); err != nil {
+				return fmt.Errorf("%s: %v", container.Name, err)
+			}
+		}
+		fmt.Printf("%s %s\n", done, container.Name)
+	}
+	return nil
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
@@ -0,0 +1,31 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"mcphub/services"
+)
+
+// detectedEngine is the container engine found by the first command that needed one
+var detectedEngine services.ContainerEngine
+
+// containerEngine returns the engine selected by --engine or MCPHUB_ENGINE (detected by default),
+// failing when it is not running
+func containerEngine() (services.ContainerEngine, error) {
+	if detectedEngine != nil {
+		return detectedEngine, nil
+	}
+
+	name := engineFlag
+	if name == "" {
+		name = os.Getenv("MCPHUB_ENGINE")
+	}
+	engine, err := services.NewContainerEngine(context.Background(), name)
+	if err != nil {
+		return nil, fmt.Errorf("❌ %v", err)
+	}
+	detectedEngine = engine
+	return engine, nil
+}
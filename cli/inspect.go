@@ -0,0 +1,145 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"mcphub/models"
+	"mcphub/services"
+
+	"github.com/spf13/cobra"
+)
+
+var inspectCmd = &cobra.Command{
+	Use:   "inspect [author/image-name]",
+	Short: "Show the tools, resources and prompts an MCP server offers",
+	Long: `Launch an MCP server image over stdio (or connect to a running server with --url),
+perform the MCP handshake and list its tools, resources and prompts`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if outputFlag != "table" && outputFlag != "json" {
+			return fmt.Errorf("invalid output format %q. Use: table or json", outputFlag)
+		}
+
+		client, err := connectMCP(cmd.Context(), args)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		ctx, cancel := context.WithTimeout(cmd.Context(), timeoutFlag)
+		defer cancel()
+
+		inspection, err := services.InspectServer(ctx, client)
+		if err != nil {
+			return fmt.Errorf("failed to inspect server: %v", err)
+		}
+
+		if outputFlag == "json" {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(inspection)
+		}
+
+		printInspection(inspection)
+		return nil
+	},
+}
+
+// connectMCP starts the referenced image over stdio, or connects to --url when it is set
+func connectMCP(ctx context.Context, args []string) (*services.MCPClient, error) {
+	if urlFlag != "" {
+		return services.NewHTTPMCPClient(urlFlag), nil
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("specify an image (author/image-name) or a server --url")
+	}
+
+	engine, err := containerEngine()
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := services.StartMCPServer(ctx, engine, services.ImageFromRef(args[0]))
+	if err != nil {
+		return nil, fmt.Errorf("failed to start MCP server: %v", err)
+	}
+	return client, nil
+}
+
+func printInspection(inspection *models.ServerInspection) {
+	fmt.Printf("🖥️  Server: %s v%s\n", inspection.ServerInfo.Name, inspection.ServerInfo.Version)
+	fmt.Printf("📜 Protocol: %s\n", inspection.ProtocolVersion)
+
+	var capabilities []string
+	if inspection.Capabilities.Tools != nil {
+		capabilities = append(capabilities, "tools")
+	}
+	if inspection.Capabilities.Resources != nil {
+		capabilities = append(capabilities, "resources")
+	}
+	if inspection.Capabilities.Prompts != nil {
+		capabilities = append(capabilities, "prompts")
+	}
+	if inspection.Capabilities.Logging != nil {
+		capabilities = append(capabilities, "logging")
+	}
+	if inspection.Capabilities.Completions != nil {
+		capabilities = append(capabilities, "completions")
+	}
+	fmt.Printf("⚙️  Capabilities: %s\n", strings.Join(capabilities, ", "))
+
+	if inspection.Instructions != "" {
+		fmt.Printf("📝 Instructions: %s\n", inspection.Instructions)
+	}
+
+	fmt.Printf("\n🔧 Tools (%d)\n", len(inspection.Tools))
+	if len(inspection.Tools) > 0 {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tDESCRIPTION")
+		for _, tool := range inspection.Tools {
+			fmt.Fprintf(w, "%s\t%s\n", tool.Name, firstLine(tool.Description))
+		}
+		w.Flush()
+	}
+
+	fmt.Printf("\n📚 Resources (%d)\n", len(inspection.Resources))
+	if len(inspection.Resources) > 0 {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "URI\tNAME\tMIME TYPE")
+		for _, resource := range inspection.Resources {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", resource.URI, resource.Name, resource.MimeType)
+		}
+		w.Flush()
+	}
+
+	fmt.Printf("\n💬 Prompts (%d)\n", len(inspection.Prompts))
+	if len(inspection.Prompts) > 0 {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tARGUMENTS\tDESCRIPTION")
+		for _, prompt := range inspection.Prompts {
+			var arguments []string
+			for _, arg := range prompt.Arguments {
+				if arg.Required {
+					arguments = append(arguments, arg.Name+"*")
+				} else {
+					arguments = append(arguments, arg.Name)
+				}
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", prompt.Name, strings.Join(arguments, ", "), firstLine(prompt.Description))
+		}
+		w.Flush()
+	}
+}
+
+// firstLine trims multi-line descriptions so table rows stay on one line
+func firstLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return strings.TrimSpace(s[:i]) + " …"
+	}
+	return s
+}
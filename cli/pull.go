@@ -1,9 +1,10 @@
 package cli
 
 import (
+	"context"
 	"fmt"
-	"os/exec"
-	"path/filepath"
+	"io"
+	"os"
 	"strings"
 
 	"mcphub/services"
@@ -11,70 +12,139 @@ import (
 	"github.com/spf13/cobra"
 )
 
-// Check if Docker is available
-func dockerAvailable() bool {
-	cmd := exec.Command("docker", "info")
-	if err := cmd.Run(); err != nil {
-		return false
-	}
-	return true
-}
-
 var pullCmd = &cobra.Command{
-	Use:   "pull <author/image-name>",
-	Short: "Download and import a Docker image from S3",
-	Long:  "Download a Docker image from S3 and load it into Docker",
-	Args:  cobra.ExactArgs(1),
+	Use:   "pull <author/image-name[:tag]>",
+	Short: "Download and import a Docker image from S3 or an OCI registry",
+	Long: `Download a Docker image from S3 and load it into the container engine.
+
+Images are kept in a content-addressed cache under the user cache directory (see mcphub cache),
+so only the layers the cache does not already hold are downloaded.
+
+With --registry (or MCPHUB_REGISTRY), the image is pulled from an OCI distribution registry
+instead, optionally at a tag such as the server version (default: latest).
+
+With --stream, the image is piped straight into the container engine as it downloads, checking
+each layer against its digest on the way and bypassing the cache.`,
+	Args: cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		if !dockerAvailable() {
-			return fmt.Errorf("❌ Docker is not running or not installed. Please start Docker and try again")
+		ctx := cmd.Context()
+		engine, err := containerEngine()
+		if err != nil {
+			return err
 		}
 
 		// Parse author/image-name format
 		parts := strings.Split(args[0], "/")
 		if len(parts) != 2 {
-			return fmt.Errorf("invalid format. Use: author/image-name")
+			return fmt.Errorf("invalid format. Use: author/image-name[:tag]")
 		}
 		author := parts[0]
-		imageName := parts[1]
+		imageName, tag, tagged := strings.Cut(parts[1], ":")
 
-		// Initialize S3 service
-		s3Service, err := services.NewS3Service()
-		if err != nil {
-			return fmt.Errorf("failed to initialize S3 service: %v", err)
-		}
+		// Multi-platform images are pulled for the engine's platform; engines that cannot tell get the host's
+		platform, _ := engine.Platform(ctx)
 
-		// Download from S3
-		if err := s3Service.PullMCP(author, imageName); err != nil {
-			return fmt.Errorf("failed to download from S3: %v", err)
+		// stream writes the image archive to w as it downloads; pull stores the image in the cache
+		var stream func(w io.Writer) error
+		var pull func(cache *services.ArtifactCache) (*services.CachedImage, error)
+		if registry := registryHost(); registry != "" {
+			if !tagged {
+				tag = "latest"
+			}
+			stream = func(w io.Writer) error {
+				return pullFromRegistry(ctx, registry, platform, author, imageName, tag, w)
+			}
+			pull = func(cache *services.ArtifactCache) (*services.CachedImage, error) {
+				return cacheFromRegistry(ctx, cache, registry, platform, author, imageName, tag)
+			}
+		} else {
+			if tagged {
+				return fmt.Errorf("tags can only be pulled from an OCI registry (--registry)")
+			}
+
+			// Initialize S3 service
+			s3Service, err := services.NewS3Service(ctx)
+			if err != nil {
+				return fmt.Errorf("failed to initialize S3 service: %v", err)
+			}
+			s3Service.Progress = os.Stdout
+			s3Service.Platform = platform
+			stream = func(w io.Writer) error {
+				if err := s3Service.StreamMCP(ctx, author, imageName, w); err != nil {
+					return fmt.Errorf("failed to download from S3: %v", err)
+				}
+				return nil
+			}
+			pull = func(cache *services.ArtifactCache) (*services.CachedImage, error) {
+				image, err := s3Service.PullMCP(ctx, cache, author, imageName)
+				if err != nil {
+					return nil, fmt.Errorf("failed to download from S3: %v", err)
+				}
+				return image, nil
+			}
 		}
 
-		// Load the Docker image
-		tarFile := filepath.Join("downloaded", imageName+".tar")
-		fmt.Printf("🐳 Loading Docker image from %s...\n", tarFile)
+		var tags []string
+		if streamFlag {
+			fmt.Println("🐳 Streaming image into the container engine...")
+			tags, err = streamImage(ctx, engine, stream)
+			if err != nil {
+				return err
+			}
+		} else {
+			cache, err := artifactCache()
+			if err != nil {
+				return err
+			}
+			image, err := pull(cache)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("🗃️  Cache: %d downloaded, %d already cached (%s)\n", image.Downloaded, image.Cached, services.ShortDigest(image.Digest))
 
-		loadCmd := exec.Command("docker", "load", "-i", tarFile)
-		loadOut, err := loadCmd.CombinedOutput()
-		if err != nil {
-			return fmt.Errorf("failed to load image: %s", string(loadOut))
+			fmt.Println("🐳 Loading image from the cache...")
+			tags, err = streamImage(ctx, engine, func(w io.Writer) error {
+				return cache.WriteArchive(image, w)
+			})
+			if err != nil {
+				return err
+			}
+			if err := autoPruneCache(cache); err != nil {
+				fmt.Fprintf(os.Stderr, "⚠️  Failed to prune the cache: %v\n", err)
+			}
 		}
 
-		output := strings.TrimSpace(string(loadOut))
 		fmt.Println("✅ Image loaded successfully!")
-		if output != "" {
-			fmt.Printf("📝 Docker output: %s\n", output)
+		for _, tag := range tags {
+			fmt.Printf("🏷️  Image: %s\n", tag)
 		}
-
-		// Attempt to extract the loaded image name from the output
-		if strings.Contains(output, "Loaded image:") {
-			parts := strings.Split(output, "Loaded image:")
-			if len(parts) > 1 {
-				loadedImage := strings.TrimSpace(parts[1])
-				fmt.Printf("🏷️  Image: %s\n", loadedImage)
-				fmt.Printf("💡 You can now run: mcphub run %s\n", strings.Split(loadedImage, ":")[0])
-			}
+		if len(tags) > 0 {
+			fmt.Printf("💡 You can now run: mcphub run %s\n", strings.Split(tags[0], ":")[0])
 		}
 
 		return nil
 	},
 }
+
+// streamImage pipes an image archive into the engine as download writes it, so the archive is never
+// written to disk. A download error breaks the pipe before the archive is complete, which fails the load.
+func streamImage(ctx context.Context, engine services.ContainerEngine, download func(io.Writer) error) ([]string, error) {
+	reader, writer := io.Pipe()
+	downloaded := make(chan error, 1)
+	go func() {
+		err := download(writer)
+		writer.CloseWithError(err)
+		downloaded <- err
+	}()
+
+	tags, err := engine.LoadImage(ctx, reader)
+	// Unblock the download if the engine stopped reading early
+	reader.Close()
+	if downloadErr := <-downloaded; downloadErr != nil {
+		return nil, downloadErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load image: %v", err)
+	}
+	return tags, nil
+}
@@ -1,30 +1,80 @@
 package cli
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
+	"mcphub/models"
 	"mcphub/services"
 
 	"github.com/spf13/cobra"
 )
 
+// maxZipSize is the largest server zip push accepts
+const maxZipSize = 100 * 1024 * 1024
+
 var pushCmd = &cobra.Command{
-	Use:   "push [zip-file]",
+	Use:   "push <zip-file | --all dir>",
 	Short: "Process an MCP server zip file and build Docker image",
 	Long: `Process an MCP server zip file by:
-1. Extracting the zip file
+1. Extracting the zip file into a work directory of its own under --workdir (or
+   MCPHUB_WORKDIR, default: the system temp directory), removed once the push is done
 2. Finding and parsing mcp.json configuration
 3. Generating a Dockerfile
-4. Building a Docker image
-5. Saving the image as a tar file and uploading to S3`,
+4. Building a Docker image (with the container engine, or without one using --builder oci)
+5. Starting the image to record its MCP tools, resources and prompts
+   (and optionally running the MCP conformance suite; skipped with --builder oci)
+6. Saving the image and uploading its layers to S3 with its metadata, skipping layers the
+   bucket already holds, or pushing it to an OCI registry with --registry (or MCPHUB_REGISTRY)
+
+Build output is shown as it runs (hidden with --quiet), followed by the time each step took.
+With --output json, the result (paths, configuration, capabilities, timings, destination and
+manifest digest) is printed to stdout as JSON and progress goes to stderr.
+
+Interrupting the push (Ctrl-C) or running past --timeout stops the build, removes the
+half-built image and the work directory, and aborts the multipart upload in progress.
+
+With --all, every directory under dir holding an mcp.json is pushed as a server of its own,
+--jobs at a time. Servers whose sources have not changed since they were last pushed are
+skipped (unless --force), and a summary of pushed, skipped and failed servers is printed.`,
 	Args: cobra.ExactArgs(1),
 	RunE: runPush,
 }
 
 func runPush(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+	if pushTimeoutFlag > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, pushTimeoutFlag)
+		defer cancel()
+	}
+
+	if pushOutputFlag != "text" && pushOutputFlag != "json" {
+		return fmt.Errorf("invalid output format %q. Use: text or json", pushOutputFlag)
+	}
+	// With --output json, stdout carries only the result
+	out := io.Writer(os.Stdout)
+	if pushOutputFlag == "json" {
+		out = os.Stderr
+	}
+
+	processor, err := newPushProcessor(out)
+	if err != nil {
+		return err
+	}
+	if pushAllFlag {
+		return pushAll(ctx, processor, args[0])
+	}
+
 	zipFilePath := args[0]
 
 	// Check if file exists
@@ -39,56 +89,312 @@ func runPush(cmd *cobra.Command, args []string) error {
 	}
 
 	// Check file size (100MB limit)
-	if len(zipData) > 100*1024*1024 {
+	if len(zipData) > maxZipSize {
 		return fmt.Errorf("file size exceeds 100MB limit")
 	}
 
 	// Get just the filename from the path
 	zipFileName := filepath.Base(zipFilePath)
 
-	fmt.Printf("📦 Processing %s...\n", zipFileName)
+	fmt.Fprintf(out, "📦 Processing %s...\n", zipFileName)
 
-	// Process the zip file using the existing service
-	processor := services.NewZipProcessor()
-	result, err := processor.ProcessZip(zipData, zipFileName)
+	// Process the zip file using the existing service, showing the build as it runs
+	logs := newPrefixWriter(out, "│ ")
+	if !quietFlag {
+		processor.BuildLogs = logs
+	}
+	result, err := processor.ProcessZip(ctx, zipData, zipFileName)
+	logs.Flush()
+	var conformanceErr *services.ConformanceError
+	if errors.As(err, &conformanceErr) {
+		if reportErr := reportConformance(conformanceErr.Report, out); reportErr != nil {
+			return reportErr
+		}
+	}
 	if err != nil {
-		return fmt.Errorf("failed to process zip file: %v", err)
+		return pushError(ctx, fmt.Errorf("failed to process zip file: %v", err))
+	}
+	defer func() {
+		if err := processor.Cleanup(result); err != nil {
+			fmt.Fprintf(os.Stderr, "⚠️  Failed to remove work directory %s: %v\n", result.WorkDir, err)
+		}
+	}()
+	if result.Conformance != nil {
+		if err := reportConformance(result.Conformance, out); err != nil {
+			return err
+		}
 	}
 
-	// Initialize S3 service
-	s3Service, err := services.NewS3Service()
+	for _, warning := range result.Warnings {
+		fmt.Fprintf(out, "⚠️  %s\n", warning)
+	}
+	for _, cached := range result.BuildCache {
+		printBuildCache(out, "", cached)
+	}
+
+	uploadStart := time.Now()
+	destination, digest, err := publishImage(ctx, result, "", out)
 	if err != nil {
-		return fmt.Errorf("failed to initialize S3 service: %v", err)
+		return pushError(ctx, err)
 	}
+	result.Timings = append(result.Timings, models.StepTiming{Step: "upload", DurationMs: time.Since(uploadStart).Milliseconds()})
 
-	// Upload to S3
-	if err := s3Service.PushMCP(result.Config.Author, result.Config.Name, result.TarFilePath); err != nil {
-		return fmt.Errorf("failed to upload to S3: %v", err)
+	if pushOutputFlag == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(pushOutput{DockerfileResponse: result, Destination: destination, Digest: digest})
 	}
 
 	// Display results
-	fmt.Println("✅ Success!")
-	fmt.Printf("📁 Extracted to: %s\n", result.ExtractedPath)
-	fmt.Printf("🐳 Dockerfile: %s\n", result.DockerfilePath)
-	fmt.Printf("🏷️  Image name: %s\n", result.ImageName)
-	fmt.Printf("📦 Docker image uploaded to S3: %s/%s.tar\n", result.Config.Author, result.Config.Name)
-	fmt.Printf("📋 MCP Server: %s v%s\n", result.Config.Name, result.Config.Version)
+	fmt.Fprintln(out, "✅ Success!")
+	if keepWorkDirFlag {
+		fmt.Fprintf(out, "📁 Work directory kept at %s\n", result.WorkDir)
+		fmt.Fprintf(out, "🐳 Dockerfile: %s\n", result.DockerfilePath)
+	}
+	fmt.Fprintf(out, "🏷️  Image name: %s\n", result.ImageName)
+	fmt.Fprintf(out, "📦 Docker image uploaded to %s\n", destination)
+	fmt.Fprintf(out, "⏱️  Timings: %s\n", formatTimings(result.Timings))
+	fmt.Fprintf(out, "📋 MCP Server: %s v%s\n", result.Config.Name, result.Config.Version)
+	if len(result.Config.Platforms) > 0 {
+		fmt.Fprintf(out, "🖥️  Platforms: %s\n", strings.Join(result.Config.Platforms, ", "))
+	}
 
 	if result.Config.Description != "" {
-		fmt.Printf("📝 Description: %s\n", result.Config.Description)
+		fmt.Fprintf(out, "📝 Description: %s\n", result.Config.Description)
 	}
 
 	if result.Config.Author != "" {
-		fmt.Printf("👤 Author: %s\n", result.Config.Author)
+		fmt.Fprintf(out, "👤 Author: %s\n", result.Config.Author)
 	}
 
 	if result.Config.Version != "" {
-		fmt.Printf("🏷️  Version: %s\n", result.Config.Version)
+		fmt.Fprintf(out, "🏷️  Version: %s\n", result.Config.Version)
 	}
 
 	if len(result.Config.Keywords) > 0 {
-		fmt.Printf("🏷️  Keywords: %s\n", strings.Join(result.Config.Keywords, ", "))
+		fmt.Fprintf(out, "🏷️  Keywords: %s\n", strings.Join(result.Config.Keywords, ", "))
+	}
+
+	if result.Inspection != nil {
+		var toolNames []string
+		for _, tool := range result.Inspection.Tools {
+			toolNames = append(toolNames, tool.Name)
+		}
+		fmt.Fprintf(out, "🔧 Tools (%d): %s\n", len(toolNames), strings.Join(toolNames, ", "))
+		fmt.Fprintf(out, "📚 Resources: %d, 💬 Prompts: %d\n", len(result.Inspection.Resources), len(result.Inspection.Prompts))
 	}
 
 	return nil
 }
+
+// pushOutput is what push --output json prints: the processed server with the time each step
+// took, and where its image was published
+type pushOutput struct {
+	*models.DockerfileResponse
+	Destination string `json:"destination"`
+	Digest      string `json:"digest"`
+}
+
+// newPushProcessor configures a zip processor from the push flags, writing notices to out
+func newPushProcessor(out io.Writer) (*services.ZipProcessor, error) {
+	var processor *services.ZipProcessor
+	switch builderFlag {
+	case "engine":
+		engine, err := containerEngine()
+		if err != nil {
+			return nil, err
+		}
+		processor = services.NewZipProcessor(engine)
+		if !noBuildCacheFlag {
+			// Without a cache directory, builds simply install dependencies every time
+			if cacheDir, err := services.DefaultBuildCacheDir(); err == nil {
+				processor.BuildCache, _ = services.NewBuildCache(cacheDir)
+			}
+		}
+	case "oci":
+		cacheDir, err := services.DefaultBaseImageCacheDir()
+		if err != nil && baseImageFlag == "" {
+			return nil, err
+		}
+		processor = services.NewZipProcessor(nil)
+		processor.OCIBuilder = &services.OCIBuilder{BaseImage: baseImageFlag, BaseCacheDir: cacheDir}
+		fmt.Fprintln(out, "🧱 Building without a container engine; the image will not be probed")
+	default:
+		return nil, fmt.Errorf("invalid builder %q. Use: engine or oci", builderFlag)
+	}
+	processor.SkipProbe = skipProbeFlag
+	processor.RunConformance = conformanceFlag || conformanceReportFlag != ""
+	processor.WorkDir = workDirFlag
+	if processor.WorkDir == "" {
+		processor.WorkDir = os.Getenv("MCPHUB_WORKDIR")
+	}
+	processor.KeepWorkDir = keepWorkDirFlag
+	if err := services.ValidatePlatforms(platformsFlag); err != nil {
+		return nil, fmt.Errorf("invalid --platform: %v", err)
+	}
+	processor.Platforms = platformsFlag
+	return processor, nil
+}
+
+// printBuildCache reports whether a build reused the dependency image of an earlier push,
+// prefixing the line with the server for batch pushes
+func printBuildCache(out io.Writer, prefix string, cached models.BuildCacheResult) {
+	platform := ""
+	if cached.Platform != "" {
+		platform = " (" + cached.Platform + ")"
+	}
+	if cached.Hit {
+		fmt.Fprintf(out, "♻️  %sBuild cache hit%s: dependencies reused from %s\n", prefix, platform, cached.Image)
+	} else {
+		fmt.Fprintf(out, "📦 %sBuild cache miss%s: dependencies installed into %s\n", prefix, platform, cached.Image)
+	}
+}
+
+// imageArchives lists the archives of a processed server: one per platform of a multi-platform build
+func imageArchives(result *models.DockerfileResponse) []models.PlatformImage {
+	if len(result.Images) == 0 {
+		return []models.PlatformImage{{ImageName: result.ImageName, TarFilePath: result.TarFilePath}}
+	}
+	return result.Images
+}
+
+// publishImage uploads a processed server's image with its metadata to the registry or S3, writing
+// progress to out, and returns where it went and the digest of its manifest
+func publishImage(ctx context.Context, result *models.DockerfileResponse, inputDigest string, out io.Writer) (string, string, error) {
+	// Upload metadata so the server can be searched without pulling it
+	metadata := &models.ServerMetadata{
+		Config:      result.Config,
+		Inspection:  result.Inspection,
+		PushedAt:    time.Now().UTC(),
+		InputDigest: inputDigest,
+	}
+
+	if registry := registryHost(); registry != "" {
+		return pushToRegistry(ctx, registry, result, metadata, out)
+	}
+	return pushToS3(ctx, result, metadata, out)
+}
+
+// pushToS3 uploads the image tar and its metadata to S3 and returns where they went and the
+// manifest digest
+func pushToS3(ctx context.Context, result *models.DockerfileResponse, metadata *models.ServerMetadata, out io.Writer) (string, string, error) {
+	// Initialize S3 service
+	s3Service, err := services.NewS3Service(ctx)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to initialize S3 service: %v", err)
+	}
+	partSize, err := services.ParseSize(partSizeFlag)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid --part-size: %v", err)
+	}
+	if partSize < services.MinPartSize {
+		return "", "", fmt.Errorf("invalid --part-size: S3 parts must be at least %s", services.FormatBytes(services.MinPartSize))
+	}
+	if uploadConcFlag < 1 {
+		return "", "", fmt.Errorf("invalid --upload-concurrency: must be at least 1")
+	}
+	s3Service.PartSize = partSize
+	s3Service.Concurrency = uploadConcFlag
+	s3Service.Progress = out
+
+	// Upload to S3
+	push, err := s3Service.PushMCP(ctx, result.Config.Author, result.Config.Name, result.Config.Platforms, imageArchives(result)...)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to upload to S3: %v", err)
+	}
+	fmt.Fprintf(out, "🧩 Layers: %d uploaded, %d already in S3\n", push.Uploaded, push.Existing)
+
+	if err := s3Service.PushMetadata(ctx, result.Config.Author, result.Config.Name, metadata); err != nil {
+		return "", "", fmt.Errorf("failed to upload metadata to S3: %v", err)
+	}
+
+	return fmt.Sprintf("S3: %s/%s.manifest (%s)", result.Config.Author, result.Config.Name, push.Digest), push.Digest, nil
+}
+
+// pushError explains a push that stopped because it was interrupted or ran out of time
+func pushError(ctx context.Context, err error) error {
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		return fmt.Errorf("push timed out after %s: %v", pushTimeoutFlag, err)
+	case context.Canceled:
+		return fmt.Errorf("push interrupted: %v", err)
+	}
+	return err
+}
+
+// reportConformance prints the push-time conformance results, unless the result is printed as JSON,
+// and writes --conformance-report
+func reportConformance(report *models.ConformanceReport, out io.Writer) error {
+	if pushOutputFlag != "json" {
+		printConformanceReport(report)
+	}
+	if conformanceReportFlag == "" {
+		return nil
+	}
+
+	format := "json"
+	if strings.HasSuffix(conformanceReportFlag, ".xml") {
+		format = "junit"
+	}
+	if err := writeConformanceReport(report, conformanceReportFlag, format); err != nil {
+		return fmt.Errorf("failed to write conformance report: %v", err)
+	}
+	fmt.Fprintf(out, "📄 Conformance report written to %s\n", conformanceReportFlag)
+	return nil
+}
+
+// formatTimings lists how long each step took, e.g. "extract 40ms, build 1m12s, upload 9.3s"
+func formatTimings(timings []models.StepTiming) string {
+	var steps []string
+	for _, timing := range timings {
+		duration := time.Duration(timing.DurationMs) * time.Millisecond
+		if duration >= time.Second {
+			duration = duration.Round(100 * time.Millisecond)
+		}
+		steps = append(steps, fmt.Sprintf("%s %s", timing.Step, duration))
+	}
+	return strings.Join(steps, ", ")
+}
+
+// outputMu keeps lines written by builds running at once from mixing
+var outputMu sync.Mutex
+
+// prefixWriter writes whole lines to w, each starting with prefix. A partial line is held back
+// until it is complete or Flush is called.
+type prefixWriter struct {
+	w       io.Writer
+	prefix  string
+	partial []byte
+}
+
+func newPrefixWriter(w io.Writer, prefix string) *prefixWriter {
+	return &prefixWriter{w: w, prefix: prefix}
+}
+
+func (p *prefixWriter) Write(data []byte) (int, error) {
+	p.partial = append(p.partial, data...)
+	for {
+		end := bytes.IndexByte(p.partial, '\n')
+		if end < 0 {
+			break
+		}
+		p.writeLine(p.partial[:end+1])
+		p.partial = p.partial[end+1:]
+	}
+	return len(data), nil
+}
+
+// Flush writes the partial line held back, if any
+func (p *prefixWriter) Flush() {
+	if len(p.partial) > 0 {
+		p.writeLine(append(p.partial, '\n'))
+		p.partial = nil
+	}
+}
+
+func (p *prefixWriter) writeLine(line []byte) {
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	io.WriteString(p.w, p.prefix)
+	p.w.Write(line)
+}
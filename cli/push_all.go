@@ -0,0 +1,194 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"mcphub/models"
+	"mcphub/services"
+)
+
+// Outcomes of a server in a batch push
+const (
+	batchPushed  = "pushed"
+	batchSkipped = "skipped"
+	batchFailed  = "failed"
+)
+
+// batchResult is the outcome of pushing one server of a batch
+type batchResult struct {
+	name     string
+	status   string
+	detail   string
+	err      error
+	duration time.Duration
+}
+
+// pushAll pushes every server under root with a pool of --jobs workers and prints a summary,
+// failing when any server failed to push
+func pushAll(ctx context.Context, processor *services.ZipProcessor, root string) error {
+	if conformanceReportFlag != "" {
+		return fmt.Errorf("--conformance-report writes a single report and cannot be used with --all")
+	}
+	if pushOutputFlag != "text" {
+		return fmt.Errorf("--output %s prints a single result and cannot be used with --all", pushOutputFlag)
+	}
+	if pushJobsFlag < 1 {
+		return fmt.Errorf("invalid --jobs: must be at least 1")
+	}
+	if info, err := os.Stat(root); err != nil || !info.IsDir() {
+		return fmt.Errorf("directory does not exist: %s", root)
+	}
+
+	servers, err := services.DiscoverServers(root)
+	if err != nil {
+		return fmt.Errorf("failed to discover servers: %v", err)
+	}
+	if len(servers) == 0 {
+		return fmt.Errorf("no mcp.json found under %s", root)
+	}
+	fmt.Printf("📦 Found %d servers under %s, pushing %d at a time\n", len(servers), root, min(pushJobsFlag, len(servers)))
+
+	results := make([]batchResult, len(servers))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < min(pushJobsFlag, len(servers)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				start := time.Now()
+				results[index] = pushSource(ctx, processor, servers[index])
+				results[index].duration = time.Since(start)
+			}
+		}()
+	}
+	for index := range servers {
+		indexes <- index
+	}
+	close(indexes)
+	wg.Wait()
+
+	failed := printBatchSummary(results)
+	if failed > 0 {
+		return pushError(ctx, fmt.Errorf("%d of %d servers failed to push", failed, len(results)))
+	}
+	return nil
+}
+
+// pushSource builds and publishes one discovered server, skipping it when its sources and build
+// settings match the ones it was last pushed with
+func pushSource(ctx context.Context, processor *services.ZipProcessor, server services.ServerSource) batchResult {
+	result := batchResult{name: server.Config.Author + "/" + server.Config.Name}
+	fail := func(err error) batchResult {
+		fmt.Printf("❌ %s failed\n", result.name)
+		result.status, result.err = batchFailed, err
+		return result
+	}
+	if err := ctx.Err(); err != nil {
+		return fail(err)
+	}
+
+	zipData, sourceDigest, err := services.PackServer(server.Dir)
+	if err != nil {
+		return fail(fmt.Errorf("failed to pack %s: %v", server.Dir, err))
+	}
+	// Servers are rebuilt when their sources or the settings they are built with change
+	inputDigest := processor.InputDigest(sourceDigest, &server.Config)
+	if len(zipData) > maxZipSize {
+		return fail(fmt.Errorf("%s exceeds the 100MB limit once zipped", server.Dir))
+	}
+	if !forceFlag && publishedInputDigest(ctx, server.Config) == inputDigest {
+		fmt.Printf("⏭️  %s is unchanged, skipping\n", result.name)
+		result.status, result.detail = batchSkipped, "unchanged since the last push"
+		return result
+	}
+
+	// Each server's build output is prefixed with its name, as several build at once
+	fmt.Printf("🔨 Building %s from %s...\n", result.name, server.Dir)
+	serverProcessor := *processor
+	logs := newPrefixWriter(os.Stdout, result.name+" │ ")
+	if !quietFlag {
+		serverProcessor.BuildLogs = logs
+	}
+	built, err := serverProcessor.ProcessZip(ctx, zipData, strings.ToLower(server.Config.Name)+".zip")
+	logs.Flush()
+	if err != nil {
+		return fail(fmt.Errorf("failed to process %s: %v", server.Dir, err))
+	}
+	defer func() {
+		if err := processor.Cleanup(built); err != nil {
+			fmt.Fprintf(os.Stderr, "⚠️  Failed to remove work directory %s: %v\n", built.WorkDir, err)
+		}
+	}()
+	for _, warning := range built.Warnings {
+		fmt.Printf("⚠️  %s: %s\n", result.name, warning)
+	}
+	for _, cached := range built.BuildCache {
+		printBuildCache(os.Stdout, result.name+": ", cached)
+	}
+
+	destination, _, err := publishImage(ctx, built, inputDigest, io.Discard)
+	if err != nil {
+		return fail(err)
+	}
+	fmt.Printf("✅ Pushed %s\n", result.name)
+	result.status, result.detail = batchPushed, destination
+	return result
+}
+
+// publishedInputDigest returns the input digest recorded when a server was last pushed, or ""
+// when it was never pushed or its metadata cannot be read
+func publishedInputDigest(ctx context.Context, config models.MCPConfig) string {
+	var metadata *models.ServerMetadata
+	if registry := registryHost(); registry != "" {
+		client, err := services.NewRegistryClient(registry)
+		if err != nil {
+			return ""
+		}
+		if metadata, err = client.GetMetadata(ctx, services.RegistryRepository(config.Author, config.Name), "latest"); err != nil {
+			return ""
+		}
+	} else {
+		s3Service, err := services.NewS3Service(ctx)
+		if err != nil {
+			return ""
+		}
+		if metadata, err = s3Service.GetMetadata(ctx, config.Author, config.Name); err != nil {
+			return ""
+		}
+	}
+	return metadata.InputDigest
+}
+
+// printBatchSummary prints a table of batch results followed by the errors of failed servers,
+// and returns the number of failures
+func printBatchSummary(results []batchResult) int {
+	counts := make(map[string]int)
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SERVER\tSTATUS\tTIME\tDETAIL")
+	for _, result := range results {
+		counts[result.status]++
+		detail := result.detail
+		if result.err != nil {
+			detail, _, _ = strings.Cut(result.err.Error(), "\n")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", result.name, result.status, result.duration.Round(time.Second), detail)
+	}
+	w.Flush()
+
+	for _, result := range results {
+		if result.err != nil && strings.Contains(result.err.Error(), "\n") {
+			fmt.Fprintf(os.Stderr, "\n❌ %s: %v\n", result.name, result.err)
+		}
+	}
+	fmt.Printf("\n📊 %d pushed, %d skipped, %d failed\n", counts[batchPushed], counts[batchSkipped], counts[batchFailed])
+	return counts[batchFailed]
+}
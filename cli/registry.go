@@ -0,0 +1,72 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"mcphub/models"
+	"mcphub/services"
+)
+
+// registryHost returns the OCI registry selected by --registry or MCPHUB_REGISTRY; empty means S3
+func registryHost() string {
+	if registryFlag != "" {
+		return registryFlag
+	}
+	return os.Getenv("MCPHUB_REGISTRY")
+}
+
+// pushToRegistry uploads the built image and its metadata to an OCI registry, writing progress to out,
+// and returns where it went and the manifest digest
+func pushToRegistry(ctx context.Context, host string, result *models.DockerfileResponse, metadata *models.ServerMetadata, out io.Writer) (string, string, error) {
+	client, err := services.NewRegistryClient(host)
+	if err != nil {
+		return "", "", err
+	}
+
+	repository := services.RegistryRepository(result.Config.Author, result.Config.Name)
+	push, err := client.PushImage(ctx, repository, services.RegistryTags(result.Config.Version), imageArchives(result), metadata)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to push to registry: %v", err)
+	}
+
+	fmt.Fprintf(out, "🧩 Layers: %d uploaded, %d already in the registry\n", push.Uploaded, push.Existing)
+	return fmt.Sprintf("%s/%s@%s", client.Host(), repository, push.Digest), push.Digest, nil
+}
+
+// pullFromRegistry writes the archive of repository:reference for platform to w, tagged imageName:latest
+func pullFromRegistry(ctx context.Context, host, platform, author, imageName, reference string, w io.Writer) error {
+	client, err := services.NewRegistryClient(host)
+	if err != nil {
+		return err
+	}
+	client.Platform = platform
+
+	repository := services.RegistryRepository(author, imageName)
+	digest, err := client.PullImage(ctx, repository, reference, strings.ToLower(imageName)+":latest", w)
+	if err != nil {
+		return fmt.Errorf("failed to pull from registry: %v", err)
+	}
+	fmt.Printf("📥 Pulled %s/%s:%s (%s)\n", client.Host(), repository, reference, digest)
+	return nil
+}
+
+// cacheFromRegistry stores repository:reference for platform in the cache, tagged imageName:latest
+func cacheFromRegistry(ctx context.Context, cache *services.ArtifactCache, host, platform, author, imageName, reference string) (*services.CachedImage, error) {
+	client, err := services.NewRegistryClient(host)
+	if err != nil {
+		return nil, err
+	}
+	client.Platform = platform
+
+	repository := services.RegistryRepository(author, imageName)
+	image, err := client.PullToCache(ctx, cache, repository, reference, strings.ToLower(imageName)+":latest")
+	if err != nil {
+		return nil, fmt.Errorf("failed to pull from registry: %v", err)
+	}
+	fmt.Printf("📥 Pulled %s/%s:%s (%s)\n", client.Host(), repository, reference, image.Digest)
+	return image, nil
+}
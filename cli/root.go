@@ -1,18 +1,81 @@
 package cli
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"mcphub/services"
 
 	"github.com/spf13/cobra"
 )
 
 // Global flag variables
 var (
-	yesFlag  bool
-	detached bool
-	portFlag string
-	nameFlag string
+	engineFlag  string
+	yesFlag     bool
+	detached    bool
+	portFlag    string
+	nameFlag    string
+	envFlags    []string
+	envFileFlag string
+	keyFileFlag string
+
+	profileFlag      string
+	memoryFlag       string
+	cpusFlag         string
+	pidsLimitFlag    int
+	networkFlag      string
+	allowHostFlags   []string
+	tmpfsFlags       []string
+	volumeFlags      []string
+	mountFlags       []string
+	forceMountFlag   bool
+	skipProbeFlag    bool
+	builderFlag      string
+	baseImageFlag    string
+	registryFlag     string
+	partSizeFlag     string
+	uploadConcFlag   int
+	streamFlag       bool
+	workDirFlag      string
+	keepWorkDirFlag  bool
+	pushTimeoutFlag  time.Duration
+	pushAllFlag      bool
+	pushJobsFlag     int
+	forceFlag        bool
+	platformsFlag    []string
+	noBuildCacheFlag bool
+	quietFlag        bool
+	pushOutputFlag   string
+
+	cacheMaxSizeFlag string
+
+	conformanceFlag       bool
+	conformanceReportFlag string
+	reportFlag            string
+	reportFormatFlag      string
+
+	clientFlag     string
+	configFileFlag string
+	writeFlag      bool
+
+	outputFlag   string
+	urlFlag      string
+	timeoutFlag  time.Duration
+	argFlags     []string
+	jsonArgsFlag string
+
+	callOutputFlag  string
+	callTimeoutFlag time.Duration
+
+	psAllFlag   bool
+	followFlag  bool
+	tailFlag    string
+	forceRmFlag bool
 )
 
 var rootCmd = &cobra.Command{
@@ -21,15 +84,43 @@ var rootCmd = &cobra.Command{
 	Long: `MCPHub CLI allows you to build and manage Model Context Protocol (MCP) servers.
 
 Commands:
-  init  - Initialize a new mcp.json configuration file
-  push  - Build Docker image from MCP server zip file  
-  pull  - Load Docker image from tar file
-  run   - Run Docker container from loaded image`,
+  init     - Initialize a new mcp.json configuration file
+  push     - Build Docker image from MCP server zip file  
+  pull     - Load Docker image from tar file
+  run      - Run Docker container from loaded image
+  ps       - List containers started by mcphub run
+  logs     - Show the logs of an MCPHub container
+  stop     - Stop the MCPHub containers of a server
+  restart  - Restart the MCPHub containers of a server
+  rm       - Remove the MCPHub containers of a server
+  inspect  - List the tools, resources and prompts of an MCP server
+  search   - Search pushed MCP servers by name, keyword or tool
+  call     - Invoke a single tool on an MCP server
+  test     - Run the MCP protocol conformance suite against a server
+  config   - Generate MCP host configuration for pulled servers
+  secrets  - Manage encrypted run-time secrets for MCP servers
+  cache    - Manage the cache of pulled images`,
 }
 
 // Execute is the entry point for the CLI
 func Execute() {
-	if err := rootCmd.Execute(); err != nil {
+	// The first interrupt cancels the command's context so it can clean up; once the
+	// signal is unregistered, a second one quits immediately
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	finished := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			stop()
+			fmt.Fprintln(os.Stderr, "\n🛑 Interrupted, cleaning up (press Ctrl-C again to quit immediately)")
+		case <-finished:
+		}
+	}()
+
+	err := rootCmd.ExecuteContext(ctx)
+	close(finished)
+	stop()
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
@@ -41,12 +132,96 @@ func init() {
 	rootCmd.AddCommand(pushCmd)
 	rootCmd.AddCommand(pullCmd)
 	rootCmd.AddCommand(runCmd)
+	rootCmd.AddCommand(psCmd, logsCmd, stopCmd, restartCmd, rmCmd)
+	rootCmd.AddCommand(inspectCmd)
+	rootCmd.AddCommand(searchCmd)
+	rootCmd.AddCommand(callCmd)
+	rootCmd.AddCommand(testCmd)
+	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configExportCmd)
+	rootCmd.AddCommand(secretsCmd)
+	secretsCmd.AddCommand(secretsSetCmd, secretsGetCmd, secretsListCmd, secretsRmCmd)
+	rootCmd.AddCommand(cacheCmd)
+	cacheCmd.AddCommand(cacheLsCmd, cachePruneCmd, cacheClearCmd)
+
+	rootCmd.PersistentFlags().StringVar(&engineFlag, "engine", "", "Container engine: auto, docker or podman (default: $MCPHUB_ENGINE, otherwise auto)")
 
 	// Flags for 'init' command
 	initCmd.Flags().BoolVarP(&yesFlag, "yes", "y", false, "Use default values without prompting")
 
+	// Flags for 'push' command
+	pushCmd.Flags().StringVar(&builderFlag, "builder", "engine", "Image builder: engine (docker/podman build) or oci (daemonless, writes an OCI layout)")
+	pushCmd.Flags().StringVar(&baseImageFlag, "base-image", "", "OCI layout directory or tarball holding the base image for --builder oci")
+	pushCmd.Flags().StringVar(&registryFlag, "registry", "", "OCI registry (host[:port]) to push to instead of S3 (default: $MCPHUB_REGISTRY)")
+	pushCmd.Flags().StringVar(&partSizeFlag, "part-size", "16m", "Part size for multipart S3 uploads (e.g. 8m, 64m; at least 5m)")
+	pushCmd.Flags().IntVar(&uploadConcFlag, "upload-concurrency", services.DefaultUploadConcurrency, "Number of parts uploaded to S3 at once")
+	pushCmd.Flags().StringVar(&workDirFlag, "workdir", "", "Directory to extract and build in (default: $MCPHUB_WORKDIR, otherwise the system temp directory)")
+	pushCmd.Flags().BoolVar(&keepWorkDirFlag, "keep-workdir", false, "Keep the extracted sources and image archive for debugging")
+	pushCmd.Flags().BoolVar(&pushAllFlag, "all", false, "Push every server under the given directory (one per mcp.json)")
+	pushCmd.Flags().IntVarP(&pushJobsFlag, "jobs", "j", 4, "Number of servers built and pushed at once with --all")
+	pushCmd.Flags().BoolVar(&forceFlag, "force", false, "Push servers with --all even if their sources are unchanged since the last push")
+	pushCmd.Flags().DurationVar(&pushTimeoutFlag, "timeout", 0, "Give up on the push after this long (e.g. 30m; default: no limit)")
+	pushCmd.Flags().StringSliceVar(&platformsFlag, "platform", nil, "Platforms to build images for, replacing the platforms in mcp.json (e.g. linux/amd64,linux/arm64)")
+	pushCmd.Flags().BoolVarP(&quietFlag, "quiet", "q", false, "Don't show the output of image builds as they run")
+	pushCmd.Flags().StringVarP(&pushOutputFlag, "output", "o", "text", "Output format (text or json); json prints the result to stdout and progress to stderr")
+	pushCmd.Flags().BoolVar(&noBuildCacheFlag, "no-build-cache", false, "Install dependencies from scratch instead of reusing the dependency image of an earlier push")
+	pushCmd.Flags().BoolVar(&skipProbeFlag, "skip-probe", false, "Don't start the built image to record its MCP capabilities")
+	pushCmd.Flags().BoolVar(&conformanceFlag, "conformance", false, "Run the MCP conformance suite and refuse to publish on failure")
+	pushCmd.Flags().StringVar(&conformanceReportFlag, "conformance-report", "", "Write the conformance report to this file (JUnit XML for .xml, otherwise JSON)")
+
+	// Flags for 'pull' command
+	pullCmd.Flags().StringVar(&registryFlag, "registry", "", "OCI registry (host[:port]) to pull from instead of S3 (default: $MCPHUB_REGISTRY)")
+	pullCmd.Flags().BoolVar(&streamFlag, "stream", false, "Pipe the image straight into the container engine as it downloads, bypassing the cache")
+
 	// Flags for 'run' command
 	runCmd.Flags().BoolVarP(&detached, "detach", "d", true, "Run container in detached mode")
 	runCmd.Flags().StringVarP(&portFlag, "port", "p", "", "Port mapping (e.g., 8080:8080)")
 	runCmd.Flags().StringVarP(&nameFlag, "name", "n", "", "Container name (defaults to image name)")
+	runCmd.Flags().StringArrayVarP(&envFlags, "env", "e", nil, "Set an environment variable (KEY=VALUE, or KEY to copy it from the current environment)")
+	runCmd.Flags().StringVar(&envFileFlag, "env-file", "", "Read environment variables from a KEY=VALUE file")
+	runCmd.Flags().StringVar(&keyFileFlag, "key-file", "", "Key file that unlocks the secrets store")
+	runCmd.Flags().StringVar(&profileFlag, "profile", "", "Sandbox profile (strict, default or permissive)")
+	runCmd.Flags().StringVar(&memoryFlag, "memory", "", "Memory limit (e.g. 512m, 2g)")
+	runCmd.Flags().StringVar(&cpusFlag, "cpus", "", "CPU limit (e.g. 0.5, 2)")
+	runCmd.Flags().IntVar(&pidsLimitFlag, "pids-limit", 0, "Maximum number of processes")
+	runCmd.Flags().StringVar(&networkFlag, "network", "", "Network mode (none, bridge or allowlist)")
+	runCmd.Flags().StringArrayVar(&allowHostFlags, "allow-host", nil, "Host reachable on the allowlist network, e.g. api.example.com or *.example.com (repeatable)")
+	runCmd.Flags().StringArrayVar(&tmpfsFlags, "tmpfs", nil, "Mount a tmpfs at this container path (repeatable)")
+	runCmd.Flags().StringArrayVarP(&volumeFlags, "volume", "v", nil, "Bind mount /host/path:/container/path[:rw], read-only by default (repeatable)")
+	runCmd.Flags().StringArrayVar(&mountFlags, "mount", nil, "Mount a host path at a declared mount as name=/host/path[:rw], read-only by default (repeatable)")
+	runCmd.Flags().BoolVar(&forceMountFlag, "force-mount", false, "Allow mounting sensitive host paths such as /, ~/.ssh or the Docker socket")
+
+	// Flags for container lifecycle commands
+	psCmd.Flags().BoolVarP(&psAllFlag, "all", "a", false, "Include stopped containers")
+	logsCmd.Flags().BoolVarP(&followFlag, "follow", "f", false, "Stream new log output")
+	logsCmd.Flags().StringVar(&tailFlag, "tail", "", "Number of lines to show from the end of the logs")
+	rmCmd.Flags().BoolVarP(&forceRmFlag, "force", "f", false, "Stop and remove running containers")
+
+	// Flags for 'inspect' command
+	inspectCmd.Flags().StringVarP(&outputFlag, "output", "o", "table", "Output format (table or json)")
+	inspectCmd.Flags().StringVar(&urlFlag, "url", "", "Connect to a running server's streamable HTTP endpoint instead of launching the image")
+	inspectCmd.Flags().DurationVar(&timeoutFlag, "timeout", 30*time.Second, "Time allowed for the handshake and list calls")
+
+	// Flags for 'call' command
+	callCmd.Flags().StringArrayVar(&argFlags, "arg", nil, "Tool argument as key=value (repeatable)")
+	callCmd.Flags().StringVar(&jsonArgsFlag, "json", "", "Tool arguments as a JSON object")
+	callCmd.Flags().StringVarP(&callOutputFlag, "output", "o", "text", "Output format (text or json)")
+	callCmd.Flags().StringVar(&urlFlag, "url", "", "Connect to a running server's streamable HTTP endpoint instead of launching the image")
+	callCmd.Flags().DurationVar(&callTimeoutFlag, "timeout", 60*time.Second, "Time allowed for the handshake and tool call")
+
+	// Flags for 'test' command
+	testCmd.Flags().StringVar(&urlFlag, "url", "", "Test a running server's streamable HTTP endpoint instead of launching the image")
+	testCmd.Flags().StringVar(&reportFlag, "report", "", "Write the report to this file (- for stdout)")
+	testCmd.Flags().StringVar(&reportFormatFlag, "report-format", "junit", "Report format (junit or json)")
+
+	// Flags for 'config export' command
+	configExportCmd.Flags().StringVar(&clientFlag, "client", "generic", "MCP host (claude-desktop, vscode, cursor or generic)")
+	configExportCmd.Flags().StringVar(&configFileFlag, "file", "", "Merge the entry into this config file")
+	configExportCmd.Flags().BoolVar(&writeFlag, "write", false, "Merge the entry into the host's default config file")
+
+	// Flags for 'cache prune' command
+	cachePruneCmd.Flags().StringVar(&cacheMaxSizeFlag, "max-size", "", "Evict the least recently used images until the cache fits (e.g. 5g; default: $MCPHUB_CACHE_MAX_SIZE, otherwise 10g)")
+
+	// Flags for 'secrets' commands
+	secretsCmd.PersistentFlags().StringVar(&keyFileFlag, "key-file", "", "Key file that unlocks the secrets store (instead of a passphrase)")
 }
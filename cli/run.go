@@ -1,24 +1,37 @@
 package cli
 
 import (
+	"bufio"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"sort"
 	"strings"
 
+	"mcphub/models"
+	"mcphub/services"
+
 	"github.com/spf13/cobra"
 )
 
 var runCmd = &cobra.Command{
 	Use:   "run <image_name>",
 	Short: "Run a Docker container from a loaded image",
-	Long:  `Start a Docker container from an image that was loaded with mcphub pull`,
-	Args:  cobra.ExactArgs(1),
+	Long: `Start a Docker container from an image that was loaded with mcphub pull.
+Environment variables declared in the image's mcp.json are taken from -e, --env-file, the
+secrets store (mcphub secrets) or their defaults, and required ones are prompted for when
+running in a terminal. Values never appear on a command line.`,
+	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		if !dockerAvailable() {
-			fmt.Println("❌ Docker is not running or not installed. Please start Docker and try again.")
+		engine, err := containerEngine()
+		if err != nil {
+			fmt.Println(err)
 			return
 		}
+		ctx := cmd.Context()
 
 		imageName := args[0]
 		containerName := nameFlag
@@ -26,54 +39,247 @@ var runCmd = &cobra.Command{
 			containerName = imageName
 		}
 
-		// Build docker run command
-		dockerArgs := []string{"run"}
+		// Images not built by mcphub have no embedded mcp.json and run with command-line settings only
+		config, _ := services.ReadImageConfig(ctx, engine, imageName)
 
-		if detached {
-			dockerArgs = append(dockerArgs, "-d")
-		} else {
-			dockerArgs = append(dockerArgs, "-it")
+		env, err := resolveRunEnv(config)
+		if err != nil {
+			fmt.Printf("❌ %v\n", err)
+			fmt.Println("💡 Provide values with -e KEY=VALUE or --env-file")
+			return
 		}
 
-		dockerArgs = append(dockerArgs, "--name", containerName)
+		sandbox, err := resolveRunSandbox(config)
+		if err != nil {
+			fmt.Printf("❌ %v\n", err)
+			return
+		}
+		if portFlag != "" && sandbox.Network != "bridge" {
+			fmt.Printf("❌ Port %s cannot be published on the %s network. Use --network bridge\n", portFlag, sandbox.Network)
+			return
+		}
 
+		spec := &services.ContainerSpec{
+			Image:   imageName,
+			Name:    containerName,
+			Labels:  services.ManagedContainerLabels(config, imageName),
+			Env:     env,
+			Sandbox: sandbox,
+		}
 		if portFlag != "" {
-			dockerArgs = append(dockerArgs, "-p", portFlag)
+			spec.Ports = []string{portFlag}
 		}
 
-		dockerArgs = append(dockerArgs, imageName)
+		envNames := make([]string, 0, len(env))
+		for name := range env {
+			envNames = append(envNames, name)
+		}
+		sort.Strings(envNames)
 
 		fmt.Printf("🚀 Running container from image '%s'...\n", imageName)
-		if detached {
-			fmt.Printf("🔧 Command: docker %s\n", strings.Join(dockerArgs, " "))
-		}
 
-		dockerCmd := exec.Command("docker", dockerArgs...)
+		if err := services.StartEgress(ctx, engine, spec); err != nil {
+			fmt.Printf("❌ %v\n", err)
+			return
+		}
 
 		if detached {
-			runOut, err := dockerCmd.CombinedOutput()
+			containerID, err := engine.RunContainer(ctx, spec)
 			if err != nil {
-				fmt.Printf("❌ Failed to run container: %s\n", string(runOut))
+				services.RemoveEgress(context.Background(), engine, containerName)
+				fmt.Printf("❌ Failed to run container: %v\n", err)
 				return
 			}
-			containerID := strings.TrimSpace(string(runOut))
 			fmt.Println("✅ Container started successfully!")
 			fmt.Printf("🆔 Container ID: %s\n", containerID)
 			fmt.Printf("📋 Container Name: %s\n", containerName)
 			if portFlag != "" {
 				fmt.Printf("🌐 Port mapping: %s\n", portFlag)
 			}
-			fmt.Printf("💡 To view logs: docker logs %s\n", containerName)
-			fmt.Printf("💡 To stop: docker stop %s\n", containerName)
+			fmt.Printf("🛡️  Sandbox: %s\n", sandbox.Summary())
+			for _, volume := range sandbox.Volumes {
+				mode := "read-only"
+				if volume.Writable {
+					mode = "read-write"
+				}
+				fmt.Printf("📂 Mount: %s → %s (%s)\n", volume.Source, volume.Target, mode)
+			}
+			if len(envNames) > 0 {
+				fmt.Printf("🔑 Environment: %s\n", strings.Join(envNames, ", "))
+			}
+			fmt.Printf("💡 To view logs: mcphub logs %s\n", containerName)
+			fmt.Printf("💡 To stop: mcphub stop %s\n", containerName)
 		} else {
-			// Run container interactively in foreground
-			dockerCmd.Stdout = os.Stdout
-			dockerCmd.Stderr = os.Stderr
-			dockerCmd.Stdin = os.Stdin
+			// Run container in the foreground with its stdio connected to the terminal
+			defer services.RemoveEgress(context.Background(), engine, containerName)
+			container, err := engine.AttachContainer(ctx, spec, os.Stderr)
+			if err != nil {
+				fmt.Printf("❌ Failed to run container: %v\n", err)
+				return
+			}
+			go func() {
+				io.Copy(container.Stdin, os.Stdin)
+				container.Stdin.Close()
+			}()
+			io.Copy(os.Stdout, container.Stdout)
 
-			if err := dockerCmd.Run(); err != nil {
+			if err := container.Wait(); err != nil {
 				fmt.Printf("❌ Container exited with error: %v\n", err)
 			}
 		}
 	},
 }
+
+// resolveRunEnv collects the environment for the container from -e, --env-file, the secrets store,
+// declared defaults and prompts. config is nil for images not built by mcphub.
+func resolveRunEnv(config *models.MCPConfig) (map[string]string, error) {
+	var decls []models.EnvVar
+	if config != nil {
+		decls = config.Env
+	}
+
+	flagValues, err := services.ParseEnvAssignments(envFlags)
+	if err != nil {
+		return nil, err
+	}
+	sources := []map[string]string{flagValues}
+
+	if envFileFlag != "" {
+		fileValues, err := services.ParseEnvFile(envFileFlag)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, fileValues)
+	}
+
+	if config != nil {
+		secrets, err := runSecrets(config, sources)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, secrets)
+	}
+
+	var prompt func(models.EnvVar) (string, error)
+	if isTerminal(os.Stdin) {
+		prompt = promptEnvVar(bufio.NewReader(os.Stdin))
+	}
+
+	return services.ResolveEnv(decls, sources, prompt)
+}
+
+// resolveRunSandbox layers the sandbox flags over the image's declared sandbox
+func resolveRunSandbox(config *models.MCPConfig) (*services.SandboxOptions, error) {
+	var declared *models.SandboxConfig
+	if config != nil {
+		declared = config.Run.Sandbox
+	}
+
+	overrides := &models.SandboxConfig{
+		Profile:    profileFlag,
+		Memory:     memoryFlag,
+		CPUs:       cpusFlag,
+		PidsLimit:  pidsLimitFlag,
+		Network:    networkFlag,
+		AllowHosts: allowHostFlags,
+		Tmpfs:      tmpfsFlags,
+	}
+	// Host paths only come from the user's --volume and --mount flags, and both are checked
+	for _, spec := range volumeFlags {
+		volume, err := services.ParseVolumeSpec(spec)
+		if err != nil {
+			return nil, err
+		}
+		if volume.Source, err = services.ResolveHostPath(volume.Source); err != nil {
+			return nil, fmt.Errorf("volume %s: %v", volume.Target, err)
+		}
+		if !forceMountFlag {
+			if err := services.CheckHostPath(volume.Source); err != nil {
+				return nil, fmt.Errorf("volume %s: %v", volume.Target, err)
+			}
+		}
+		overrides.Volumes = append(overrides.Volumes, volume)
+	}
+
+	var mountDecls []models.Mount
+	if config != nil {
+		mountDecls = config.Mounts
+	}
+	mounts, err := services.ResolveMounts(mountDecls, mountFlags, forceMountFlag)
+	if err != nil {
+		return nil, err
+	}
+	overrides.Volumes = append(overrides.Volumes, mounts...)
+
+	return services.ResolveSandbox(declared, overrides)
+}
+
+// runSecrets returns the stored secrets of the server. The store is only unlocked with a passphrase
+// prompt when a declared variable has no explicit value; otherwise a locked store is skipped.
+func runSecrets(config *models.MCPConfig, sources []map[string]string) (map[string]string, error) {
+	path, err := services.DefaultSecretsPath()
+	if err != nil {
+		return nil, nil
+	}
+	if _, err := os.Stat(path); err != nil {
+		return nil, nil
+	}
+
+	needed := false
+	for _, decl := range config.Env {
+		provided := false
+		for _, source := range sources {
+			if _, ok := source[decl.Name]; ok {
+				provided = true
+			}
+		}
+		if !provided {
+			needed = true
+		}
+	}
+
+	store, err := openSecretsStore(needed)
+	if errors.Is(err, errNoSecretsKey) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return store.Values(config.Author + "/" + config.Name), nil
+}
+
+// promptEnvVar asks for a missing required variable, hiding the input of secrets
+func promptEnvVar(reader *bufio.Reader) func(models.EnvVar) (string, error) {
+	return func(decl models.EnvVar) (string, error) {
+		if decl.Description != "" {
+			fmt.Printf("🔑 %s (%s): ", decl.Name, decl.Description)
+		} else {
+			fmt.Printf("🔑 %s: ", decl.Name)
+		}
+
+		if decl.Secret {
+			setTerminalEcho(false)
+			defer func() {
+				setTerminalEcho(true)
+				fmt.Println()
+			}()
+		}
+		return readLine(reader), nil
+	}
+}
+
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// setTerminalEcho toggles echo of typed characters; it is a no-op where stty is unavailable
+func setTerminalEcho(on bool) {
+	mode := "-echo"
+	if on {
+		mode = "echo"
+	}
+	stty := exec.Command("stty", mode)
+	stty.Stdin = os.Stdin
+	stty.Run()
+}
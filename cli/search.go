@@ -0,0 +1,48 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"mcphub/services"
+
+	"github.com/spf13/cobra"
+)
+
+var searchCmd = &cobra.Command{
+	Use:   "search <query>",
+	Short: "Search pushed MCP servers by name, keyword or tool",
+	Long:  "Search the metadata of pushed MCP servers by name, description, keywords and tool names",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		s3Service, err := services.NewS3Service(cmd.Context())
+		if err != nil {
+			return fmt.Errorf("failed to initialize S3 service: %v", err)
+		}
+
+		matches, err := s3Service.SearchMCPs(cmd.Context(), args[0])
+		if err != nil {
+			return fmt.Errorf("failed to search S3: %v", err)
+		}
+
+		if len(matches) == 0 {
+			fmt.Printf("🔍 No MCP servers match '%s'\n", args[0])
+			return nil
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "SERVER\tVERSION\tTOOLS\tDESCRIPTION")
+		for _, match := range matches {
+			var toolNames []string
+			if match.Inspection != nil {
+				for _, tool := range match.Inspection.Tools {
+					toolNames = append(toolNames, tool.Name)
+				}
+			}
+			fmt.Fprintf(w, "%s/%s\t%s\t%s\t%s\n", match.Config.Author, match.Config.Name, match.Config.Version, strings.Join(toolNames, ", "), firstLine(match.Config.Description))
+		}
+		return w.Flush()
+	},
+}
@@ -0,0 +1,195 @@
+package cli
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+
+	"mcphub/services"
+
+	"github.com/spf13/cobra"
+)
+
+var secretsCmd = &cobra.Command{
+	Use:   "secrets",
+	Short: "Manage encrypted run-time secrets for MCP servers",
+	Long: `Store secrets for MCP servers in an encrypted file under the user config directory.
+The store is unlocked with --key-file (or MCPHUB_SECRETS_KEY_FILE), MCPHUB_SECRETS_PASSPHRASE,
+or a passphrase prompt. mcphub run injects a server's secrets as container environment variables.`,
+}
+
+var secretsSetCmd = &cobra.Command{
+	Use:   "set <author/image-name> <KEY>",
+	Short: "Store a secret, read without echo from a prompt or from stdin",
+	Long: `Store a secret for a server. The value is prompted for without echo, or read from stdin
+when it is piped, so it never appears on the command line or in the shell history.`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		store, err := openSecretsStore(true)
+		if err != nil {
+			return err
+		}
+
+		value, err := readSecretValue(fmt.Sprintf("🔑 Value for %s: ", args[1]))
+		if err != nil {
+			return err
+		}
+		if value == "" {
+			return fmt.Errorf("no value given for %s", args[1])
+		}
+
+		if err := store.Set(args[0], args[1], value); err != nil {
+			return err
+		}
+		if err := store.Save(); err != nil {
+			return err
+		}
+		fmt.Printf("✅ Stored %s for %s\n", args[1], services.SecretsScope(args[0]))
+		return nil
+	},
+}
+
+var secretsGetCmd = &cobra.Command{
+	Use:   "get <author/image-name> <KEY>",
+	Short: "Print a stored secret",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		store, err := openSecretsStore(true)
+		if err != nil {
+			return err
+		}
+
+		value, ok := store.Get(args[0], args[1])
+		if !ok {
+			return fmt.Errorf("no secret %s stored for %s", args[1], services.SecretsScope(args[0]))
+		}
+		fmt.Println(value)
+		return nil
+	},
+}
+
+var secretsListCmd = &cobra.Command{
+	Use:   "list [author/image-name]",
+	Short: "List stored secret names (values are never shown)",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		store, err := openSecretsStore(true)
+		if err != nil {
+			return err
+		}
+
+		names := store.List()
+		scopes := make([]string, 0, len(names))
+		for scope := range names {
+			if len(args) == 0 || scope == services.SecretsScope(args[0]) {
+				scopes = append(scopes, scope)
+			}
+		}
+		sort.Strings(scopes)
+
+		if len(scopes) == 0 {
+			fmt.Println("🔑 No secrets stored")
+			return nil
+		}
+		for _, scope := range scopes {
+			fmt.Printf("📦 %s\n", scope)
+			for _, name := range names[scope] {
+				fmt.Printf("   🔑 %s\n", name)
+			}
+		}
+		return nil
+	},
+}
+
+var secretsRmCmd = &cobra.Command{
+	Use:   "rm <author/image-name> <KEY>",
+	Short: "Remove a stored secret",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		store, err := openSecretsStore(true)
+		if err != nil {
+			return err
+		}
+
+		if !store.Remove(args[0], args[1]) {
+			return fmt.Errorf("no secret %s stored for %s", args[1], services.SecretsScope(args[0]))
+		}
+		if err := store.Save(); err != nil {
+			return err
+		}
+		fmt.Printf("🗑️  Removed %s from %s\n", args[1], services.SecretsScope(args[0]))
+		return nil
+	},
+}
+
+// errNoSecretsKey means the store exists but no key is available without prompting
+var errNoSecretsKey = errors.New("no key available for the secrets store")
+
+// openSecretsStore unlocks the default store, prompting for a passphrase only when interactive is set.
+// A passphrase for a store that does not exist yet is asked for twice, since it is the one the store
+// gets created with.
+func openSecretsStore(interactive bool) (*services.SecretsStore, error) {
+	path, err := services.DefaultSecretsPath()
+	if err != nil {
+		return nil, err
+	}
+
+	var key services.SecretsKey
+	keyFile := keyFileFlag
+	if keyFile == "" {
+		keyFile = os.Getenv("MCPHUB_SECRETS_KEY_FILE")
+	}
+
+	switch {
+	case keyFile != "":
+		if key.KeyFile, err = os.ReadFile(keyFile); err != nil {
+			return nil, fmt.Errorf("failed to read key file: %v", err)
+		}
+	case os.Getenv("MCPHUB_SECRETS_PASSPHRASE") != "":
+		key.Passphrase = []byte(os.Getenv("MCPHUB_SECRETS_PASSPHRASE"))
+	case interactive && isTerminal(os.Stdin):
+		passphrase, err := readSecretValue("🔐 Secrets passphrase: ")
+		if err != nil {
+			return nil, err
+		}
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			confirmation, err := readSecretValue("🔐 Confirm the new store's passphrase: ")
+			if err != nil {
+				return nil, err
+			}
+			if confirmation != passphrase {
+				return nil, fmt.Errorf("passphrases do not match")
+			}
+		}
+		key.Passphrase = []byte(passphrase)
+	default:
+		return nil, errNoSecretsKey
+	}
+
+	store, err := services.OpenSecretsStore(path, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open secrets store: %v", err)
+	}
+	return store, nil
+}
+
+// readSecretValue prompts without echo on a terminal, or reads one line from piped stdin
+func readSecretValue(prompt string) (string, error) {
+	reader := bufio.NewReader(os.Stdin)
+	if !isTerminal(os.Stdin) {
+		return readLine(reader), nil
+	}
+
+	fmt.Print(prompt)
+	setTerminalEcho(false)
+	value := readLine(reader)
+	setTerminalEcho(true)
+	fmt.Println()
+
+	if value == "" {
+		return "", fmt.Errorf("no value entered")
+	}
+	return value, nil
+}
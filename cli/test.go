@@ -0,0 +1,97 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"mcphub/models"
+	"mcphub/services"
+
+	"github.com/spf13/cobra"
+)
+
+var testCmd = &cobra.Command{
+	Use:   "test [author/image-name]",
+	Short: "Run the MCP protocol conformance suite against a server",
+	Long: `Run protocol conformance checks against an MCP server image (or a running server with --url):
+initialize and version negotiation, ping, tools/list pagination, tool input schema validity,
+errors for unknown methods, cancellation of requests in flight and stale ones, and shutdown.
+Exits with a non-zero status when any check fails.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if reportFormatFlag != "json" && reportFormatFlag != "junit" {
+			return fmt.Errorf("invalid report format %q. Use: json or junit", reportFormatFlag)
+		}
+
+		// Every check that needs a fresh session gets its own connection
+		target := urlFlag
+		if target == "" {
+			if len(args) == 0 {
+				return fmt.Errorf("specify an image (author/image-name) or a server --url")
+			}
+			target = services.ImageFromRef(args[0])
+		}
+		connect := func() (*services.MCPClient, error) { return connectMCP(cmd.Context(), args) }
+
+		fmt.Printf("🧪 Running MCP conformance suite against %s...\n", target)
+		report := services.RunConformanceSuite(cmd.Context(), target, connect)
+		printConformanceReport(report)
+
+		if reportFlag != "" {
+			if err := writeConformanceReport(report, reportFlag, reportFormatFlag); err != nil {
+				return fmt.Errorf("failed to write report: %v", err)
+			}
+			fmt.Printf("📄 Report written to %s\n", reportFlag)
+		}
+
+		if report.Failed > 0 {
+			return fmt.Errorf("%d conformance checks failed", report.Failed)
+		}
+		return nil
+	},
+}
+
+func printConformanceReport(report *models.ConformanceReport) {
+	if report.ServerInfo.Name != "" {
+		fmt.Printf("🖥️  Server: %s v%s (protocol %s)\n", report.ServerInfo.Name, report.ServerInfo.Version, report.ProtocolVersion)
+	}
+
+	for _, result := range report.Results {
+		icon := "✅"
+		switch result.Status {
+		case models.CheckFailed:
+			icon = "❌"
+		case models.CheckSkipped:
+			icon = "⏭️ "
+		}
+		line := fmt.Sprintf("%s %-22s %6dms", icon, result.Name, result.Duration.Milliseconds())
+		if result.Message != "" {
+			line += "  " + result.Message
+		}
+		fmt.Println(line)
+	}
+
+	fmt.Printf("📊 %d passed, %d failed, %d skipped\n", report.Passed, report.Failed, report.Skipped)
+}
+
+// writeConformanceReport writes the report to path ("-" for stdout) as JSON or JUnit XML
+func writeConformanceReport(report *models.ConformanceReport, path, format string) error {
+	var out io.Writer = os.Stdout
+	if path != "-" {
+		file, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+
+	if format == "junit" {
+		return services.WriteJUnitReport(out, report)
+	}
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+const (
+	CheckPassed  = "passed"
+	CheckFailed  = "failed"
+	CheckSkipped = "skipped"
+)
+
+type ConformanceResult struct {
+	Name     string        `json:"name"`
+	Status   string        `json:"status"`
+	Message  string        `json:"message,omitempty"`
+	Duration time.Duration `json:"duration_ns"`
+}
+
+type ConformanceReport struct {
+	Target          string              `json:"target"`
+	ServerInfo      Implementation      `json:"server_info"`
+	ProtocolVersion string              `json:"protocol_version"`
+	Results         []ConformanceResult `json:"results"`
+	Passed          int                 `json:"passed"`
+	Failed          int                 `json:"failed"`
+	Skipped         int                 `json:"skipped"`
+	Duration        time.Duration       `json:"duration_ns"`
+}
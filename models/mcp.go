@@ -1,5 +1,7 @@
 package models
 
+import "time"
+
 type MCPConfig struct {
 	Name        string     `json:"name"`
 	Version     string     `json:"version"`
@@ -9,6 +11,9 @@ type MCPConfig struct {
 	Keywords    []string   `json:"keywords"`
 	Repository  Repository `json:"repository"`
 	Run         RunConfig  `json:"run"`
+	Env         []EnvVar   `json:"env,omitempty"`
+	Mounts      []Mount    `json:"mounts,omitempty"`
+	Platforms   []string   `json:"platforms,omitempty"`
 }
 
 type Repository struct {
@@ -20,6 +25,56 @@ type RunConfig struct {
 	Command string   `json:"command"`
 	Args    []string `json:"args"`
 	Port    int      `json:"port"`
+	// Transport is "stdio" or "http"; when unset, servers declaring a port serve streamable HTTP
+	Transport string         `json:"transport,omitempty"`
+	Sandbox   *SandboxConfig `json:"sandbox,omitempty"`
+}
+
+// UsesHTTP reports whether the server is reached over streamable HTTP at /mcp on its port rather
+// than over stdio
+func (r RunConfig) UsesHTTP() bool {
+	if r.Transport != "" {
+		return r.Transport == "http"
+	}
+	return r.Port > 0
+}
+
+// SandboxConfig limits what a running server can use; unset fields come from the named profile
+type SandboxConfig struct {
+	Profile   string `json:"profile,omitempty"`
+	Memory    string `json:"memory,omitempty"`
+	CPUs      string `json:"cpus,omitempty"`
+	PidsLimit int    `json:"pids_limit,omitempty"`
+	Network   string `json:"network,omitempty"`
+	// AllowHosts are the hosts reachable on the allowlist network; *.example.com allows subdomains
+	AllowHosts []string      `json:"allow_hosts,omitempty"`
+	Tmpfs      []string      `json:"tmpfs,omitempty"`
+	Volumes    []VolumeMount `json:"volumes,omitempty"`
+}
+
+// VolumeMount binds a host path into the container, read-only unless Writable is set
+type VolumeMount struct {
+	Source   string `json:"source"`
+	Target   string `json:"target"`
+	Writable bool   `json:"writable,omitempty"`
+}
+
+// EnvVar declares an environment variable the server reads at run time
+type EnvVar struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Required    bool   `json:"required,omitempty"`
+	Default     string `json:"default,omitempty"`
+	Secret      bool   `json:"secret,omitempty"`
+}
+
+// Mount declares a container path the server expects a host directory or file to be mounted at
+type Mount struct {
+	Name        string `json:"name"`
+	Path        string `json:"path"`
+	Description string `json:"description,omitempty"`
+	Access      string `json:"access,omitempty"`
+	Required    bool   `json:"required,omitempty"`
 }
 
 type DockerfileRequest struct {
@@ -27,11 +82,62 @@ type DockerfileRequest struct {
 }
 
 type DockerfileResponse struct {
-	ExtractedPath  string    `json:"extracted_path"`
-	DockerfilePath string    `json:"dockerfile_path"`
-	ImageName      string    `json:"image_name"`
-	TarFilePath    string    `json:"tar_file_path"`
-	Config         MCPConfig `json:"config"`
-	Success        bool      `json:"success"`
-	Message        string    `json:"message,omitempty"`
+	WorkDir        string             `json:"work_dir"`
+	ExtractedPath  string             `json:"extracted_path"`
+	DockerfilePath string             `json:"dockerfile_path"`
+	ImageName      string             `json:"image_name"`
+	TarFilePath    string             `json:"tar_file_path"`
+	Images         []PlatformImage    `json:"images,omitempty"`
+	BuildCache     []BuildCacheResult `json:"build_cache,omitempty"`
+	Timings        []StepTiming       `json:"timings,omitempty"`
+	Config         MCPConfig          `json:"config"`
+	Inspection     *ServerInspection  `json:"inspection,omitempty"`
+	Conformance    *ConformanceReport `json:"conformance,omitempty"`
+	Warnings       []string           `json:"warnings,omitempty"`
+	Success        bool               `json:"success"`
+	Message        string             `json:"message,omitempty"`
+}
+
+// PlatformImage is the image a multi-platform build made for one platform
+type PlatformImage struct {
+	Platform    string `json:"platform"`
+	ImageName   string `json:"image_name"`
+	TarFilePath string `json:"tar_file_path"`
+}
+
+// BuildCacheResult says whether a build reused the dependency image of an earlier push (a hit) or
+// had to install its dependencies (a miss)
+type BuildCacheResult struct {
+	Platform string `json:"platform,omitempty"`
+	Image    string `json:"image"`
+	Key      string `json:"key"`
+	Hit      bool   `json:"hit"`
+}
+
+// StepTiming is how long one step of a push took: extract, generate, build, probe, conformance,
+// save or upload
+type StepTiming struct {
+	Step       string `json:"step"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// ServerMetadata is stored next to each pushed image so the registry can be searched without pulling
+type ServerMetadata struct {
+	Config      MCPConfig         `json:"config"`
+	Inspection  *ServerInspection `json:"inspection,omitempty"`
+	PushedAt    time.Time         `json:"pushed_at"`
+	InputDigest string            `json:"input_digest,omitempty"`
+}
+
+// ManagedContainer is a container started by mcphub run
+type ManagedContainer struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Server  string `json:"server"`
+	Author  string `json:"author,omitempty"`
+	Version string `json:"version,omitempty"`
+	Image   string `json:"image"`
+	State   string `json:"state"`
+	Status  string `json:"status"`
+	Ports   string `json:"ports,omitempty"`
 }
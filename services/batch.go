@@ -0,0 +1,177 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"mcphub/models"
+)
+
+// ServerSource is an MCP server found in a source tree, ready to be pushed
+type ServerSource struct {
+	// Dir is the directory holding the server's mcp.json
+	Dir    string
+	Config models.MCPConfig
+}
+
+// skipSourceDir reports whether a directory is left out of discovery and server archives:
+// version control metadata and installed dependencies, which the image build recreates
+func skipSourceDir(name string) bool {
+	switch name {
+	case ".git", ".hg", ".svn", "node_modules", "__pycache__", ".venv":
+		return true
+	}
+	return false
+}
+
+// DiscoverServers finds every mcp.json under root. A directory holding an mcp.json is a server of
+// its own, so directories below it are part of that server and are not searched further.
+func DiscoverServers(root string) ([]ServerSource, error) {
+	var servers []ServerSource
+	names := make(map[string]string)
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.IsDir() {
+			return nil
+		}
+		if path != root && skipSourceDir(entry.Name()) {
+			return filepath.SkipDir
+		}
+
+		configPath := filepath.Join(path, "mcp.json")
+		if _, err := os.Stat(configPath); err != nil {
+			return nil
+		}
+		config, err := ReadMCPConfig(configPath)
+		if err != nil {
+			return fmt.Errorf("%s: %w", configPath, err)
+		}
+		key := config.Author + "/" + strings.ToLower(config.Name)
+		if other, ok := names[key]; ok {
+			return fmt.Errorf("%s and %s both define server %s", other, path, key)
+		}
+		names[key] = path
+		servers = append(servers, ServerSource{Dir: path, Config: *config})
+		return filepath.SkipDir
+	})
+	if err != nil {
+		return nil, err
+	}
+	return servers, nil
+}
+
+// PackServer zips a server directory for ProcessZip and returns the digest of its sources: the
+// path, mode and content of every file, so it changes only when the sources do. Symlinks to files
+// are packed as the files they point to; symlinks to directories cannot be packed.
+func PackServer(dir string) ([]byte, string, error) {
+	var paths []string
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if path != dir && skipSourceDir(entry.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if entry.Type()&fs.ModeSymlink != 0 {
+			info, err := os.Stat(path)
+			if err != nil {
+				return fmt.Errorf("broken symlink %s: %w", path, err)
+			}
+			if !info.Mode().IsRegular() {
+				return fmt.Errorf("symlink %s does not point to a file; replace it with a copy of what it points to", path)
+			}
+			paths = append(paths, path)
+			return nil
+		}
+		if entry.Type().IsRegular() {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	sort.Strings(paths)
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	digest := sha256.New()
+	for _, path := range paths {
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return nil, "", err
+		}
+		rel = filepath.ToSlash(rel)
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, "", err
+		}
+
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return nil, "", err
+		}
+		header.Name = rel
+		header.Method = zip.Deflate
+		w, err := archive.CreateHeader(header)
+		if err != nil {
+			return nil, "", err
+		}
+		content := sha256.New()
+		if err := copyFile(io.MultiWriter(w, content), path); err != nil {
+			return nil, "", err
+		}
+		fmt.Fprintf(digest, "%s %o %x\n", rel, info.Mode().Perm(), content.Sum(nil))
+	}
+	if err := archive.Close(); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), "sha256:" + hex.EncodeToString(digest.Sum(nil)), nil
+}
+
+// InputDigest returns the digest of everything an image built by zp from a packed server depends
+// on: its sources, whose digest PackServer returned, and the settings it is built with, including
+// the Dockerfile generated for it, which changes with mcphub itself
+func (zp *ZipProcessor) InputDigest(sourceDigest string, config *models.MCPConfig) string {
+	digest := sha256.New()
+	fmt.Fprintf(digest, "sources %s\n", sourceDigest)
+
+	platforms := zp.Platforms
+	if len(platforms) == 0 {
+		platforms = config.Platforms
+	}
+	fmt.Fprintf(digest, "platforms %s\n", strings.Join(platforms, ","))
+	if zp.OCIBuilder != nil {
+		fmt.Fprintf(digest, "builder oci %s\n", zp.OCIBuilder.BaseImage)
+	} else {
+		fmt.Fprintf(digest, "builder engine, build cache %t\n", zp.BuildCache != nil)
+	}
+	fmt.Fprintf(digest, "dockerfile\n%s", zp.dockerfileGenerator.Generate(config))
+
+	return "sha256:" + hex.EncodeToString(digest.Sum(nil))
+}
+
+// copyFile writes the content of the file at path to w
+func copyFile(w io.Writer, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(w, file)
+	return err
+}
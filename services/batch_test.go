@@ -0,0 +1,100 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"mcphub/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTestTree(t *testing.T, root string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+}
+
+func TestInputDigest(t *testing.T) {
+	config := &models.MCPConfig{Name: "Weather", Run: models.RunConfig{Command: "python", Args: []string{"server.py"}}}
+	processor := NewZipProcessor(nil)
+	digest := processor.InputDigest("sha256:sources", config)
+	assert.Equal(t, digest, processor.InputDigest("sha256:sources", config))
+	assert.NotEqual(t, digest, processor.InputDigest("sha256:changed", config))
+
+	// Settings that change the built image change the digest too
+	processor.Platforms = []string{"linux/arm64"}
+	assert.NotEqual(t, digest, processor.InputDigest("sha256:sources", config))
+	processor.Platforms = nil
+	processor.OCIBuilder = &OCIBuilder{}
+	assert.NotEqual(t, digest, processor.InputDigest("sha256:sources", config))
+	processor.OCIBuilder = nil
+	assert.NotEqual(t, digest, processor.InputDigest("sha256:sources", &models.MCPConfig{Name: "Weather", Run: models.RunConfig{Command: "node", Args: []string{"server.js"}}}))
+}
+
+func TestDiscoverAndPackServers(t *testing.T) {
+	root := t.TempDir()
+	writeTestTree(t, root, map[string]string{
+		"servers/weather/mcp.json":                    `{"name":"Weather","author":"acme","run":{"command":"python","args":["server.py"]}}`,
+		"servers/weather/server.py":                   "print('weather')\n",
+		"servers/weather/examples/mcp.json":           `{"name":"Example","run":{"command":"python"}}`,
+		"servers/notes/mcp.json":                      `{"name":"Notes","author":"acme","run":{"command":"node","args":["index.js"]}}`,
+		"servers/notes/index.js":                      "console.log('notes')\n",
+		"servers/notes/node_modules/dep/mcp.json":     `{"name":"Dep","run":{"command":"node"}}`,
+		"servers/notes/node_modules/dep/package.json": "{}",
+	})
+
+	servers, err := DiscoverServers(root)
+	require.NoError(t, err)
+	require.Len(t, servers, 2)
+	assert.Equal(t, "Notes", servers[0].Config.Name)
+	assert.Equal(t, "Weather", servers[1].Config.Name)
+
+	// Installed dependencies are left out of the archive and its digest
+	zipData, digest, err := PackServer(servers[0].Dir)
+	require.NoError(t, err)
+	reader, err := zip.NewReader(bytes.NewReader(zipData), int64(len(zipData)))
+	require.NoError(t, err)
+	var names []string
+	for _, file := range reader.File {
+		names = append(names, file.Name)
+	}
+	assert.Equal(t, []string{"index.js", "mcp.json"}, names)
+
+	// The digest follows the sources, not their timestamps
+	_, same, err := PackServer(servers[0].Dir)
+	require.NoError(t, err)
+	assert.Equal(t, digest, same)
+	writeTestTree(t, servers[0].Dir, map[string]string{"node_modules/dep/index.js": "changed"})
+	_, same, err = PackServer(servers[0].Dir)
+	require.NoError(t, err)
+	assert.Equal(t, digest, same)
+	writeTestTree(t, servers[0].Dir, map[string]string{"index.js": "console.log('notes v2')\n"})
+	_, changed, err := PackServer(servers[0].Dir)
+	require.NoError(t, err)
+	assert.NotEqual(t, digest, changed)
+
+	// Symlinked files are packed as the files they point to, and symlinked directories fail the pack
+	writeTestTree(t, root, map[string]string{"shared/util.js": "module.exports = {}\n"})
+	require.NoError(t, os.Symlink(filepath.Join(root, "shared/util.js"), filepath.Join(servers[0].Dir, "util.js")))
+	zipData, _, err = PackServer(servers[0].Dir)
+	require.NoError(t, err)
+	reader, err = zip.NewReader(bytes.NewReader(zipData), int64(len(zipData)))
+	require.NoError(t, err)
+	require.Len(t, reader.File, 3)
+	assert.Equal(t, "util.js", reader.File[2].Name)
+	require.NoError(t, os.Symlink(filepath.Join(root, "shared"), filepath.Join(servers[0].Dir, "shared")))
+	_, _, err = PackServer(servers[0].Dir)
+	assert.ErrorContains(t, err, "does not point to a file")
+
+	// Two servers may not publish under the same name
+	writeTestTree(t, root, map[string]string{"other/mcp.json": `{"name":"weather","author":"acme","run":{"command":"python"}}`})
+	_, err = DiscoverServers(root)
+	assert.ErrorContains(t, err, "both define server acme/weather")
+}
@@ -0,0 +1,147 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"mcphub/models"
+)
+
+// BuildCache keeps, for each server, images with its dependencies installed. They are tagged with
+// a digest of the dependency manifests and the steps installing them, so a push whose dependencies
+// did not change builds on the image of an earlier push and only copies the project on top.
+// Which images a server has is recorded in a small state file, so that images for dependencies
+// that changed since are removed from the engine.
+type BuildCache struct {
+	dir string
+}
+
+// buildCacheState lists the dependency images of a server by platform ("" for the engine's own)
+type buildCacheState struct {
+	Images map[string]string `json:"images"`
+}
+
+// dependencyBuild describes the dependency image of one build
+type dependencyBuild struct {
+	key       string
+	tag       string
+	manifests []string
+}
+
+// DefaultBuildCacheDir returns the directory holding the build cache's state
+func DefaultBuildCacheDir() (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find user cache directory: %w", err)
+	}
+	return filepath.Join(cacheDir, "mcphub", "build-cache"), nil
+}
+
+// NewBuildCache opens the build cache whose state is kept in dir
+func NewBuildCache(dir string) (*BuildCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create build cache directory: %w", err)
+	}
+	return &BuildCache{dir: dir}, nil
+}
+
+// dependencies works out the dependency image for building the project of server ("author/name")
+// in contextDir for platform. It returns nil when the project has none of the dependency manifests.
+func (c *BuildCache) dependencies(config *models.MCPConfig, contextDir, server, platform string) (*dependencyBuild, error) {
+	generator := NewDockerfileGenerator()
+	var manifests []string
+	for _, name := range generator.dependencyManifestNames(config.Run.Command) {
+		if info, err := os.Stat(filepath.Join(contextDir, name)); err == nil && info.Mode().IsRegular() {
+			manifests = append(manifests, name)
+		}
+	}
+	if len(manifests) == 0 {
+		return nil, nil
+	}
+
+	digest := sha256.New()
+	fmt.Fprintf(digest, "platform %s\n", platform)
+	io.WriteString(digest, generator.GenerateDependencies(config, manifests))
+	for _, name := range manifests {
+		content := sha256.New()
+		if err := copyFile(content, filepath.Join(contextDir, name)); err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(digest, "%s %x\n", name, content.Sum(nil))
+	}
+	key := "sha256:" + hex.EncodeToString(digest.Sum(nil))
+	return &dependencyBuild{
+		key:       key,
+		tag:       fmt.Sprintf("mcphub-deps/%s:%s", server, strings.TrimPrefix(key, "sha256:")[:12]),
+		manifests: manifests,
+	}, nil
+}
+
+// prepare makes sure the dependency image exists in the engine, building it from a context holding
+// only the manifests when it does not, and reports whether it was already there. The build's
+// output is written to logs.
+func (c *BuildCache) prepare(ctx context.Context, engine ContainerEngine, config *models.MCPConfig, deps *dependencyBuild, contextDir, buildDir, platform string, logs io.Writer) (bool, error) {
+	if _, err := engine.ImageLabels(ctx, deps.tag); err == nil {
+		return true, nil
+	}
+
+	if err := os.MkdirAll(buildDir, 0755); err != nil {
+		return false, fmt.Errorf("failed to create dependency build directory: %w", err)
+	}
+	for _, name := range deps.manifests {
+		data, err := os.ReadFile(filepath.Join(contextDir, name))
+		if err != nil {
+			return false, err
+		}
+		if err := os.WriteFile(filepath.Join(buildDir, name), data, 0644); err != nil {
+			return false, err
+		}
+	}
+	dockerfile := NewDockerfileGenerator().GenerateDependencies(config, deps.manifests)
+	if err := os.WriteFile(filepath.Join(buildDir, "Dockerfile"), []byte(dockerfile), 0644); err != nil {
+		return false, fmt.Errorf("failed to write dependency Dockerfile: %w", err)
+	}
+	if err := engine.BuildImage(ctx, buildDir, deps.tag, platform, logs); err != nil {
+		return false, fmt.Errorf("dependency image build failed: %w", err)
+	}
+	return false, nil
+}
+
+// statePath returns the state file of the dependency images of server ("author/name")
+func (c *BuildCache) statePath(server string) string {
+	return filepath.Join(c.dir, filepath.FromSlash(server)+".json")
+}
+
+// record notes the dependency image server was built on for platform, and removes the one it was
+// built on before when that differs
+func (c *BuildCache) record(ctx context.Context, engine ContainerEngine, server, platform, tag string) error {
+	path := c.statePath(server)
+	var state buildCacheState
+	if data, err := os.ReadFile(path); err == nil {
+		json.Unmarshal(data, &state)
+	}
+	if state.Images == nil {
+		state.Images = make(map[string]string)
+	}
+	if previous := state.Images[platform]; previous != "" && previous != tag {
+		// The image may be gone already, or still used by another tag
+		engine.RemoveImage(ctx, previous)
+	}
+	state.Images[platform] = tag
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
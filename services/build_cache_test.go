@@ -0,0 +1,90 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"mcphub/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateDependencies(t *testing.T) {
+	generator := NewDockerfileGenerator()
+	config := &models.MCPConfig{Name: "weather", Run: models.RunConfig{Command: "python", Args: []string{"server.py"}}}
+
+	deps := generator.GenerateDependencies(config, []string{"requirements.txt"})
+	assert.Contains(t, deps, "FROM python:3.11-slim")
+	assert.Contains(t, deps, "COPY requirements.txt ./")
+	assert.Contains(t, deps, "pip install --no-cache-dir -r requirements.txt")
+	assert.NotContains(t, deps, "COPY . .")
+	assert.NotContains(t, deps, "pyproject.toml")
+
+	// Building on the dependency image leaves out what it installed, but not what needs the sources
+	output := generator.GenerateOnDependencies(config, "weather-deps:0123456789ab")
+	assert.Contains(t, output, "FROM weather-deps:0123456789ab")
+	assert.Contains(t, output, "COPY . .")
+	assert.NotContains(t, output, "requirements.txt")
+	assert.Contains(t, output, "uv pip install --system .")
+	assert.Contains(t, output, `CMD ["python", "server.py"]`)
+}
+
+func TestBuildCacheDependencies(t *testing.T) {
+	cache, err := NewBuildCache(t.TempDir())
+	require.NoError(t, err)
+	config := &models.MCPConfig{Name: "weather", Run: models.RunConfig{Command: "python", Args: []string{"server.py"}}}
+	dir := t.TempDir()
+	writeTestTree(t, dir, map[string]string{"server.py": "print('weather')\n"})
+
+	// Without manifests there is nothing worth caching
+	deps, err := cache.dependencies(config, dir, "acme/weather", "")
+	require.NoError(t, err)
+	assert.Nil(t, deps)
+
+	writeTestTree(t, dir, map[string]string{"requirements.txt": "httpx==0.27.0\n"})
+	deps, err = cache.dependencies(config, dir, "acme/weather", "")
+	require.NoError(t, err)
+	require.NotNil(t, deps)
+	assert.Equal(t, []string{"requirements.txt"}, deps.manifests)
+	assert.Equal(t, "mcphub-deps/acme/weather:"+deps.key[len("sha256:"):][:12], deps.tag)
+
+	// The key follows the manifests and the platform, not the sources
+	writeTestTree(t, dir, map[string]string{"server.py": "print('weather v2')\n"})
+	same, err := cache.dependencies(config, dir, "acme/weather", "")
+	require.NoError(t, err)
+	assert.Equal(t, deps.key, same.key)
+
+	other, err := cache.dependencies(config, dir, "acme/weather", "linux/arm64")
+	require.NoError(t, err)
+	assert.NotEqual(t, deps.key, other.key)
+
+	writeTestTree(t, dir, map[string]string{"requirements.txt": "httpx==0.28.0\n"})
+	changed, err := cache.dependencies(config, dir, "acme/weather", "")
+	require.NoError(t, err)
+	assert.NotEqual(t, deps.key, changed.key)
+
+	// Recording a server's dependency image removes the one it was built on before
+	var removed []string
+	engine := newFakeEngineAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			removed = append(removed, strings.TrimPrefix(r.URL.Path, "/images/"))
+		}
+		w.Write([]byte("[]"))
+	}))
+	require.NoError(t, cache.record(context.Background(), engine, "acme/weather", "", deps.tag))
+	require.NoError(t, cache.record(context.Background(), engine, "acme/weather", "", deps.tag))
+	assert.Empty(t, removed)
+	require.NoError(t, cache.record(context.Background(), engine, "acme/weather", "", changed.tag))
+	assert.Equal(t, []string{deps.tag}, removed)
+	// Servers of other authors with the same name keep images of their own
+	require.NoError(t, cache.record(context.Background(), engine, "bob/weather", "", "mcphub-deps/bob/weather:0123456789ab"))
+	assert.Equal(t, []string{deps.tag}, removed)
+	data, err := os.ReadFile(filepath.Join(cache.dir, "acme", "weather.json"))
+	require.NoError(t, err)
+	assert.Contains(t, string(data), changed.tag)
+}
@@ -0,0 +1,441 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultCacheMaxSize is the size the artifact cache is pruned to after a pull when none is configured
+const DefaultCacheMaxSize = 10 << 30
+
+// staleDownloadAge is how long a partial download is kept for a pull to resume before pruning removes it
+const staleDownloadAge = 24 * time.Hour
+
+// ArtifactCache keeps pulled images under the user cache directory. Blobs are stored once by
+// digest and shared by every image that uses them, so a pull only downloads the blobs the cache
+// does not already hold. Each pulled reference is recorded with the digest it resolved to:
+//
+//	blobs/sha256/<hex>   image layers, configs and manifests, and whole archives of legacy images
+//	refs/<hex>.json      a pulled reference, keyed by the SHA-256 of its name
+//	downloads/           partial downloads, resumed by the next pull
+//	lock                 held shared by pulls and exclusively by prune and clear
+type ArtifactCache struct {
+	dir string
+}
+
+// CachedImage is a pulled reference recorded in the cache
+type CachedImage struct {
+	Ref    string `json:"ref"`
+	Digest string `json:"digest"`
+	// Tag is the name the image is loaded as; Archive marks whole archives, which carry their own
+	Tag     string `json:"tag,omitempty"`
+	Archive bool   `json:"archive,omitempty"`
+	// ETag is the S3 ETag of a legacy archive, compared to skip downloading it again
+	ETag     string    `json:"etag,omitempty"`
+	Size     int64     `json:"size"`
+	LastUsed time.Time `json:"lastUsed"`
+
+	// Downloaded and Cached count the blobs fetched by the pull and those already in the cache
+	Downloaded int `json:"-"`
+	Cached     int `json:"-"`
+}
+
+// CacheUsage describes what the cache holds on disk
+type CacheUsage struct {
+	Blobs int
+	Size  int64
+}
+
+// DefaultArtifactCacheDir returns the cache directory for pulled images
+func DefaultArtifactCacheDir() (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find user cache directory: %w", err)
+	}
+	return filepath.Join(cacheDir, "mcphub", "artifacts"), nil
+}
+
+// NewArtifactCache opens the cache in dir, creating it if needed
+func NewArtifactCache(dir string) (*ArtifactCache, error) {
+	for _, sub := range []string{filepath.Join("blobs", "sha256"), "refs", "downloads"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create cache directory: %w", err)
+		}
+	}
+	return &ArtifactCache{dir: dir}, nil
+}
+
+// Dir returns the directory the cache is stored in
+func (c *ArtifactCache) Dir() string {
+	return c.dir
+}
+
+func (c *ArtifactCache) blobPath(digest string) string {
+	return filepath.Join(c.dir, filepath.FromSlash(blobName(digest)))
+}
+
+func (c *ArtifactCache) refPath(ref string) string {
+	sum := sha256.Sum256([]byte(ref))
+	return filepath.Join(c.dir, "refs", hex.EncodeToString(sum[:])+".json")
+}
+
+// downloadPath returns where the download named name is written until it is complete
+func (c *ArtifactCache) downloadPath(name string) string {
+	sum := sha256.Sum256([]byte(name))
+	return filepath.Join(c.dir, "downloads", hex.EncodeToString(sum[:]))
+}
+
+// lock takes the cache's lock: shared while storing or reading images, exclusive while removing
+// anything, so that pruning in one process never removes what a pull in another is writing. It
+// returns the function releasing the lock.
+func (c *ArtifactCache) lock(exclusive bool) (func(), error) {
+	file, err := os.OpenFile(filepath.Join(c.dir, "lock"), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cache lock: %w", err)
+	}
+	if err := lockFile(file, exclusive); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to lock cache: %w", err)
+	}
+	return func() { file.Close() }, nil
+}
+
+func (c *ArtifactCache) hasBlob(descriptor ociDescriptor) bool {
+	info, err := os.Stat(c.blobPath(descriptor.Digest))
+	return err == nil && info.Size() == descriptor.Size
+}
+
+// lookup returns the recorded image for ref, if any
+func (c *ArtifactCache) lookup(ref string) (*CachedImage, bool) {
+	data, err := os.ReadFile(c.refPath(ref))
+	if err != nil {
+		return nil, false
+	}
+	var image CachedImage
+	if err := json.Unmarshal(data, &image); err != nil {
+		return nil, false
+	}
+	return &image, true
+}
+
+// record saves image as the latest pull of its reference
+func (c *ArtifactCache) record(image *CachedImage) error {
+	image.LastUsed = time.Now().UTC()
+	data, err := json.MarshalIndent(image, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(c.refPath(image.Ref), data, 0644)
+}
+
+// storeImage caches the manifest of an image and fetches the blobs the cache does not hold yet.
+// fetch downloads a blob to path; the blob is checked against its digest before it is cached.
+func (c *ArtifactCache) storeImage(ref, tag string, manifestData []byte, fetch func(descriptor ociDescriptor, path string) error) (*CachedImage, error) {
+	unlock, err := c.lock(false)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	var manifest ociManifest
+	if err := json.Unmarshal(manifestData, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid image manifest: %w", err)
+	}
+	image := &CachedImage{Ref: ref, Digest: sha256Digest(manifestData), Tag: tag, Size: int64(len(manifestData))}
+	for _, descriptor := range append([]ociDescriptor{manifest.Config}, manifest.Layers...) {
+		if !digestPattern.MatchString(descriptor.Digest) {
+			return nil, fmt.Errorf("invalid image manifest: unsupported digest %q", descriptor.Digest)
+		}
+		image.Size += descriptor.Size
+		if c.hasBlob(descriptor) {
+			image.Cached++
+			continue
+		}
+
+		path := c.downloadPath(descriptor.Digest)
+		if err := fetch(descriptor, path); err != nil {
+			return nil, err
+		}
+		if err := verifyFile(path, descriptor.Digest); err != nil {
+			os.Remove(path)
+			return nil, err
+		}
+		if err := os.Rename(path, c.blobPath(descriptor.Digest)); err != nil {
+			return nil, fmt.Errorf("failed to cache blob %s: %w", descriptor.Digest, err)
+		}
+		image.Downloaded++
+	}
+
+	if err := os.WriteFile(c.blobPath(image.Digest), manifestData, 0644); err != nil {
+		return nil, fmt.Errorf("failed to cache manifest: %w", err)
+	}
+	return image, c.record(image)
+}
+
+// storeArchive moves a downloaded whole-image archive into the cache under its digest. The caller
+// holds the lock while downloading it.
+func (c *ArtifactCache) storeArchive(ref, etag, path string) (*CachedImage, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	file.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	image := &CachedImage{Ref: ref, Digest: "sha256:" + hex.EncodeToString(hash.Sum(nil)), Archive: true, ETag: etag, Size: size, Downloaded: 1}
+	if err := os.Rename(path, c.blobPath(image.Digest)); err != nil {
+		return nil, fmt.Errorf("failed to cache image archive: %w", err)
+	}
+	return image, c.record(image)
+}
+
+// cachedArchive returns the cached archive of ref when it was downloaded from an object with etag
+func (c *ArtifactCache) cachedArchive(ref, etag string) (*CachedImage, bool) {
+	image, ok := c.lookup(ref)
+	if !ok || !image.Archive || etag == "" || image.ETag != etag {
+		return nil, false
+	}
+	if !c.hasBlob(ociDescriptor{Digest: image.Digest, Size: image.Size}) {
+		return nil, false
+	}
+	image.Cached = 1
+	return image, c.record(image) == nil
+}
+
+// WriteArchive writes a cached image to w as an archive that docker load and podman load accept
+func (c *ArtifactCache) WriteArchive(image *CachedImage, w io.Writer) error {
+	unlock, err := c.lock(false)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	open := func(digest string) (io.ReadCloser, error) {
+		file, err := os.Open(c.blobPath(digest))
+		if err != nil {
+			return nil, fmt.Errorf("blob %s is missing from the cache: %w", digest, err)
+		}
+		return file, nil
+	}
+
+	if image.Archive {
+		file, err := open(image.Digest)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.Copy(w, file)
+		return err
+	}
+
+	manifestData, err := os.ReadFile(c.blobPath(image.Digest))
+	if err != nil {
+		return fmt.Errorf("manifest %s is missing from the cache: %w", image.Digest, err)
+	}
+	var manifest ociManifest
+	if err := json.Unmarshal(manifestData, &manifest); err != nil {
+		return fmt.Errorf("invalid cached manifest %s: %w", image.Digest, err)
+	}
+	_, err = writeImageArchive(w, &manifest, manifestData, image.Tag, open)
+	return err
+}
+
+// Images lists the cached images, most recently used first
+func (c *ArtifactCache) Images() ([]CachedImage, error) {
+	entries, err := os.ReadDir(filepath.Join(c.dir, "refs"))
+	if err != nil {
+		return nil, err
+	}
+	var images []CachedImage
+	for _, entry := range entries {
+		data, err := os.ReadFile(filepath.Join(c.dir, "refs", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var image CachedImage
+		if err := json.Unmarshal(data, &image); err != nil {
+			continue
+		}
+		images = append(images, image)
+	}
+	sort.Slice(images, func(i, j int) bool { return images[i].LastUsed.After(images[j].LastUsed) })
+	return images, nil
+}
+
+// Usage returns the number and total size of the blobs and partial downloads in the cache
+func (c *ArtifactCache) Usage() (CacheUsage, error) {
+	var usage CacheUsage
+	for _, sub := range []string{"blobs", "downloads"} {
+		err := filepath.WalkDir(filepath.Join(c.dir, sub), func(path string, entry fs.DirEntry, err error) error {
+			if err != nil || entry.IsDir() {
+				return err
+			}
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			usage.Blobs++
+			usage.Size += info.Size()
+			return nil
+		})
+		if err != nil {
+			return usage, err
+		}
+	}
+	return usage, nil
+}
+
+// Prune removes partial downloads no pull resumed for a day and blobs no cached image uses, then
+// evicts the least recently used images until the cache is no larger than maxSize. A maxSize of
+// zero or less evicts nothing. It waits for pulls in other processes to finish, and returns the
+// evicted images.
+func (c *ArtifactCache) Prune(maxSize int64) ([]CachedImage, error) {
+	unlock, err := c.lock(true)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	images, err := c.Images()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.removeStaleDownloads(); err != nil {
+		return nil, err
+	}
+
+	var evicted []CachedImage
+	for {
+		if err := c.removeUnused(images); err != nil {
+			return evicted, err
+		}
+		usage, err := c.Usage()
+		if err != nil {
+			return evicted, err
+		}
+		if maxSize <= 0 || usage.Size <= maxSize || len(images) == 0 {
+			return evicted, nil
+		}
+
+		oldest := images[len(images)-1]
+		if err := os.Remove(c.refPath(oldest.Ref)); err != nil {
+			return evicted, err
+		}
+		evicted = append(evicted, oldest)
+		images = images[:len(images)-1]
+	}
+}
+
+// removeStaleDownloads deletes the partial downloads last written more than staleDownloadAge ago
+func (c *ArtifactCache) removeStaleDownloads() error {
+	dir := filepath.Join(c.dir, "downloads")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if time.Since(info.ModTime()) > staleDownloadAge {
+			if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// removeUnused deletes the blobs none of images refers to
+func (c *ArtifactCache) removeUnused(images []CachedImage) error {
+	used := make(map[string]bool)
+	for _, image := range images {
+		used[image.Digest] = true
+		if image.Archive {
+			continue
+		}
+		data, err := os.ReadFile(c.blobPath(image.Digest))
+		if err != nil {
+			continue
+		}
+		var manifest ociManifest
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			continue
+		}
+		for _, descriptor := range append([]ociDescriptor{manifest.Config}, manifest.Layers...) {
+			used[descriptor.Digest] = true
+		}
+	}
+
+	dir := filepath.Join(c.dir, "blobs", "sha256")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !used["sha256:"+entry.Name()] {
+			if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Clear removes everything from the cache
+func (c *ArtifactCache) Clear() error {
+	unlock, err := c.lock(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	for _, sub := range []string{"blobs", "refs", "downloads"} {
+		if err := os.RemoveAll(filepath.Join(c.dir, sub)); err != nil {
+			return err
+		}
+	}
+	_, err = NewArtifactCache(c.dir)
+	return err
+}
+
+// verifyFile checks a file against a digest
+func verifyFile(path, digest string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	verifier, err := newDigestReader(file, digest)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(io.Discard, verifier); err != nil {
+		return err
+	}
+	return verifier.verify()
+}
+
+// ShortDigest abbreviates a digest for display
+func ShortDigest(digest string) string {
+	short := strings.TrimPrefix(digest, "sha256:")
+	if len(short) > 12 {
+		short = short[:12]
+	}
+	return short
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package services
+
+import "os"
+
+// lockFile does nothing where advisory locks are not available; the cache is then only safe to
+// use from one process at a time
+func lockFile(file *os.File, exclusive bool) error {
+	return nil
+}
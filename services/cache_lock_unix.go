@@ -0,0 +1,17 @@
+//go:build unix
+
+package services
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile waits for an advisory lock on file, released when the file is closed
+func lockFile(file *os.File, exclusive bool) error {
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	return syscall.Flock(int(file.Fd()), how)
+}
@@ -0,0 +1,75 @@
+package services
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// cacheTestImage stores an image of the given blobs in the cache, fetching them from blobs
+func cacheTestImage(t *testing.T, cache *ArtifactCache, ref string, blobs ...[]byte) *CachedImage {
+	contents := make(map[string][]byte)
+	descriptors := make([]ociDescriptor, len(blobs))
+	for i, blob := range blobs {
+		descriptors[i] = ociDescriptor{MediaType: MediaTypeOCILayer, Digest: sha256Digest(blob), Size: int64(len(blob))}
+		contents[descriptors[i].Digest] = blob
+	}
+	manifestData, err := json.Marshal(ociManifest{SchemaVersion: 2, MediaType: MediaTypeOCIManifest, Config: descriptors[0], Layers: descriptors[1:]})
+	require.NoError(t, err)
+
+	image, err := cache.storeImage(ref, "test:latest", manifestData, func(descriptor ociDescriptor, path string) error {
+		return os.WriteFile(path, contents[descriptor.Digest], 0644)
+	})
+	require.NoError(t, err)
+	return image
+}
+
+func TestArtifactCachePrune(t *testing.T) {
+	cache := newTestCache(t)
+	base := make([]byte, 1000)
+	older := cacheTestImage(t, cache, "s3://alice/Weather", []byte(`{"config":1}`), base, make([]byte, 3000))
+	time.Sleep(10 * time.Millisecond)
+	newer := cacheTestImage(t, cache, "s3://bob/News", []byte(`{"config":2}`), base, make([]byte, 2000))
+	assert.Equal(t, 1, newer.Cached)
+
+	images, err := cache.Images()
+	require.NoError(t, err)
+	require.Len(t, images, 2)
+	assert.Equal(t, newer.Ref, images[0].Ref)
+
+	// Pruning without a size limit keeps every image, and partial downloads a pull may still resume
+	fresh, stale := cache.downloadPath("fresh"), cache.downloadPath("stale")
+	require.NoError(t, os.WriteFile(fresh, []byte("partial"), 0644))
+	require.NoError(t, os.WriteFile(stale, []byte("partial"), 0644))
+	longAgo := time.Now().Add(-2 * staleDownloadAge)
+	require.NoError(t, os.Chtimes(stale, longAgo, longAgo))
+	evicted, err := cache.Prune(0)
+	require.NoError(t, err)
+	assert.Empty(t, evicted)
+	assert.FileExists(t, fresh)
+	assert.NoFileExists(t, stale)
+	require.NoError(t, os.Remove(fresh))
+
+	// The least recently used image goes first, keeping the base layer the other image shares
+	evicted, err = cache.Prune(4000)
+	require.NoError(t, err)
+	require.Len(t, evicted, 1)
+	assert.Equal(t, older.Ref, evicted[0].Ref)
+	assert.True(t, cache.hasBlob(ociDescriptor{Digest: sha256Digest(base), Size: 1000}))
+	assert.False(t, cache.hasBlob(ociDescriptor{Digest: sha256Digest(make([]byte, 3000)), Size: 3000}))
+	usage, err := cache.Usage()
+	require.NoError(t, err)
+	assert.Equal(t, 4, usage.Blobs)
+
+	require.NoError(t, cache.Clear())
+	usage, err = cache.Usage()
+	require.NoError(t, err)
+	assert.Zero(t, usage.Blobs)
+	images, err = cache.Images()
+	require.NoError(t, err)
+	assert.Empty(t, images)
+}
@@ -0,0 +1,146 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"mcphub/models"
+)
+
+// SupportedClients lists the MCP hosts config export can generate entries for
+var SupportedClients = []string{"claude-desktop", "vscode", "cursor", "generic"}
+
+// ClientServerEntry builds the server entry an MCP host uses to launch the image over stdio. The
+// entry only carries the sandbox the image declares, which may not include host paths. Hosts do not
+// launch HTTP servers: their entry points at the port the server is published on by mcphub run.
+func ClientServerEntry(client, imageName string, config *models.MCPConfig) (map[string]any, error) {
+	if !slices.Contains(SupportedClients, client) {
+		return nil, fmt.Errorf("unsupported client %q", client)
+	}
+	if config.Run.Sandbox != nil && len(config.Run.Sandbox.Volumes) > 0 {
+		return nil, fmt.Errorf("%s declares sandbox volumes; host paths are only mounted when given to mcphub run", imageName)
+	}
+	sandbox, err := ResolveSandbox(config.Run.Sandbox, nil)
+	if err != nil {
+		return nil, err
+	}
+	if sandbox.Network == "allowlist" {
+		return nil, fmt.Errorf("%s uses the allowlist network, whose egress proxy only mcphub run starts", imageName)
+	}
+	if config.Run.UsesHTTP() {
+		return httpServerEntry(client, imageName, config, sandbox)
+	}
+
+	args := []string{"run", "-i", "--rm"}
+	args = append(args, SandboxDockerArgs(sandbox)...)
+
+	// Hosts pass env values to docker, which forwards the named variables into the container
+	env := make(map[string]string)
+	for _, decl := range config.Env {
+		args = append(args, "-e", decl.Name)
+		env[decl.Name] = decl.Default
+	}
+	args = append(args, imageName)
+
+	entry := map[string]any{
+		"command": "docker",
+		"args":    args,
+	}
+	if len(env) > 0 {
+		entry["env"] = env
+	}
+
+	if client == "vscode" {
+		entry["type"] = "stdio"
+	}
+	return entry, nil
+}
+
+// httpServerEntry points the host at an HTTP server running on its port on this machine
+func httpServerEntry(client, imageName string, config *models.MCPConfig, sandbox *SandboxOptions) (map[string]any, error) {
+	if sandbox.Network == "none" {
+		return nil, fmt.Errorf("%s serves HTTP but its %s sandbox has no network to publish port %d on", imageName, sandbox.Profile, config.Run.Port)
+	}
+	url := HTTPServerURL(config)
+	if client == "claude-desktop" {
+		return nil, fmt.Errorf("%s serves HTTP, and Claude Desktop only launches stdio servers; start it with mcphub run and add %s as a custom connector", imageName, url)
+	}
+
+	entry := map[string]any{"url": url}
+	if client == "vscode" {
+		entry["type"] = "http"
+	}
+	return entry, nil
+}
+
+// HTTPServerURL is where an HTTP server answers once published on its own port of localhost
+func HTTPServerURL(config *models.MCPConfig) string {
+	return fmt.Sprintf("http://localhost:%d/mcp", config.Run.Port)
+}
+
+// clientServersKey is the top-level key holding server entries in each host's config file
+func clientServersKey(client string) string {
+	if client == "vscode" {
+		return "servers"
+	}
+	return "mcpServers"
+}
+
+// ClientConfigPath returns the config file a host reads by default
+func ClientConfigPath(client string) (string, error) {
+	switch client {
+	case "claude-desktop":
+		configDir, err := os.UserConfigDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(configDir, "Claude", "claude_desktop_config.json"), nil
+	case "vscode":
+		return filepath.Join(".vscode", "mcp.json"), nil
+	case "cursor":
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(home, ".cursor", "mcp.json"), nil
+	default:
+		return "", fmt.Errorf("client %q has no default config file; use --file", client)
+	}
+}
+
+// ClientConfigDocument wraps a single server entry in the host's config file layout
+func ClientConfigDocument(client, serverName string, entry map[string]any) map[string]any {
+	return map[string]any{
+		clientServersKey(client): map[string]any{serverName: entry},
+	}
+}
+
+// MergeClientConfig adds or replaces a server entry in an existing host config file, keeping everything else
+func MergeClientConfig(existing []byte, client, serverName string, entry map[string]any) ([]byte, error) {
+	document := make(map[string]any)
+	if len(existing) > 0 {
+		if err := json.Unmarshal(existing, &document); err != nil {
+			return nil, fmt.Errorf("existing config is not valid JSON: %w", err)
+		}
+	}
+
+	key := clientServersKey(client)
+	servers, ok := document[key].(map[string]any)
+	if !ok {
+		if document[key] != nil {
+			return nil, fmt.Errorf("existing config has a non-object %q key", key)
+		}
+		servers = make(map[string]any)
+	}
+	servers[serverName] = entry
+	document[key] = servers
+
+	data, err := json.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
@@ -0,0 +1,98 @@
+package services
+
+import (
+	"encoding/json"
+	"testing"
+
+	"mcphub/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientConfig(t *testing.T) {
+	config := &models.MCPConfig{Name: "weather", Run: models.RunConfig{Command: "node", Port: 5050, Transport: "stdio"}}
+
+	t.Run("Entry runs the image over stdio", func(t *testing.T) {
+		entry, err := ClientServerEntry("vscode", "weather", config)
+		require.NoError(t, err)
+		assert.Equal(t, "docker", entry["command"])
+		assert.Equal(t, "stdio", entry["type"])
+		assert.Equal(t, []string{"run", "-i", "--rm", "--memory", "2g", "--pids-limit", "512", "--network", "bridge", "--security-opt", "no-new-privileges", "weather"}, entry["args"])
+
+		_, err = ClientServerEntry("emacs", "weather", config)
+		assert.Error(t, err)
+	})
+
+	t.Run("Entry points at the port of HTTP servers", func(t *testing.T) {
+		served := *config
+		served.Run.Transport = ""
+
+		entry, err := ClientServerEntry("vscode", "weather", &served)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]any{"type": "http", "url": "http://localhost:5050/mcp"}, entry)
+		entry, err = ClientServerEntry("cursor", "weather", &served)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]any{"url": "http://localhost:5050/mcp"}, entry)
+
+		// Claude Desktop only launches stdio servers, and a server without a network cannot be reached
+		_, err = ClientServerEntry("claude-desktop", "weather", &served)
+		assert.ErrorContains(t, err, "custom connector")
+		served.Run.Sandbox = &models.SandboxConfig{Profile: "strict"}
+		_, err = ClientServerEntry("generic", "weather", &served)
+		assert.ErrorContains(t, err, "no network")
+	})
+
+	t.Run("Entry forwards declared env vars", func(t *testing.T) {
+		withEnv := *config
+		withEnv.Env = []models.EnvVar{{Name: "API_KEY", Required: true, Secret: true}, {Name: "REGION", Default: "eu"}}
+
+		entry, err := ClientServerEntry("cursor", "weather", &withEnv)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"run", "-i", "--rm", "--memory", "2g", "--pids-limit", "512", "--network", "bridge", "--security-opt", "no-new-privileges", "-e", "API_KEY", "-e", "REGION", "weather"}, entry["args"])
+		assert.Equal(t, map[string]string{"API_KEY": "", "REGION": "eu"}, entry["env"])
+	})
+
+	t.Run("Entry applies the declared sandbox", func(t *testing.T) {
+		strict := *config
+		strict.Run.Sandbox = &models.SandboxConfig{Profile: "strict"}
+
+		entry, err := ClientServerEntry("generic", "weather", &strict)
+		require.NoError(t, err)
+		args := entry["args"].([]string)
+		assert.NotContains(t, args, "-p")
+		assert.Contains(t, args, "--read-only")
+	})
+
+	t.Run("Entry refuses a sandbox the image widens itself", func(t *testing.T) {
+		permissive := *config
+		permissive.Run.Sandbox = &models.SandboxConfig{Profile: "permissive"}
+		_, err := ClientServerEntry("generic", "weather", &permissive)
+		assert.ErrorContains(t, err, "--profile")
+
+		mounted := *config
+		mounted.Run.Sandbox = &models.SandboxConfig{Volumes: []models.VolumeMount{{Source: "/home/user", Target: "/data", Writable: true}}}
+		_, err = ClientServerEntry("generic", "weather", &mounted)
+		assert.ErrorContains(t, err, "declares sandbox volumes")
+	})
+
+	t.Run("Merge keeps existing servers and settings", func(t *testing.T) {
+		existing := []byte(`{"globalShortcut":"Ctrl+Space","mcpServers":{"other":{"command":"npx"},"weather":{"command":"old"}}}`)
+		entry, _ := ClientServerEntry("claude-desktop", "weather", config)
+
+		merged, err := MergeClientConfig(existing, "claude-desktop", "weather", entry)
+		require.NoError(t, err)
+
+		var document map[string]any
+		require.NoError(t, json.Unmarshal(merged, &document))
+		servers := document["mcpServers"].(map[string]any)
+		assert.Equal(t, "Ctrl+Space", document["globalShortcut"])
+		assert.Contains(t, servers, "other")
+		assert.Equal(t, "docker", servers["weather"].(map[string]any)["command"])
+	})
+
+	t.Run("Merge rejects invalid files", func(t *testing.T) {
+		_, err := MergeClientConfig([]byte(`// comment`), "vscode", "weather", map[string]any{})
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,469 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"time"
+
+	"mcphub/models"
+)
+
+// conformanceCheckTimeout bounds each individual check
+const conformanceCheckTimeout = 15 * time.Second
+
+// errSkip marks a check that does not apply to the server under test
+type errSkip string
+
+func (e errSkip) Error() string { return string(e) }
+
+// conformanceSuite runs protocol checks against one server, sharing a single session between most checks
+type conformanceSuite struct {
+	connect func() (*MCPClient, error)
+	client  *MCPClient
+	init    *models.InitializeResult
+}
+
+type conformanceCheck struct {
+	name string
+	run  func(ctx context.Context, s *conformanceSuite) (string, error)
+}
+
+var conformanceChecks = []conformanceCheck{
+	{"initialize", checkInitialize},
+	{"version-negotiation", checkVersionNegotiation},
+	{"ping", checkPing},
+	{"tools-list-pagination", checkToolsPagination},
+	{"tool-input-schemas", checkToolInputSchemas},
+	{"unknown-method", checkUnknownMethod},
+	{"cancellation", checkCancellation},
+	{"stale-cancellation", checkStaleCancellation},
+	{"shutdown", checkShutdown},
+}
+
+// RunConformanceSuite checks a server's protocol behaviour. connect must return a fresh
+// connection to the server each time it is called.
+func RunConformanceSuite(ctx context.Context, target string, connect func() (*MCPClient, error)) *models.ConformanceReport {
+	suite := &conformanceSuite{connect: connect}
+	report := &models.ConformanceReport{Target: target, Results: []models.ConformanceResult{}}
+	start := time.Now()
+
+	for _, check := range conformanceChecks {
+		result := models.ConformanceResult{Name: check.name}
+		checkStart := time.Now()
+
+		var message string
+		var err error
+		if check.name != "initialize" && suite.init == nil {
+			err = errSkip("initialize failed")
+		} else {
+			ctx, cancel := context.WithTimeout(ctx, conformanceCheckTimeout)
+			message, err = check.run(ctx, suite)
+			cancel()
+		}
+		result.Duration = time.Since(checkStart)
+
+		var skip errSkip
+		switch {
+		case errors.As(err, &skip):
+			result.Status = models.CheckSkipped
+			result.Message = skip.Error()
+			report.Skipped++
+		case err != nil:
+			result.Status = models.CheckFailed
+			result.Message = err.Error()
+			report.Failed++
+		default:
+			result.Status = models.CheckPassed
+			result.Message = message
+			report.Passed++
+		}
+		report.Results = append(report.Results, result)
+	}
+
+	if suite.init != nil {
+		report.ServerInfo = suite.init.ServerInfo
+		report.ProtocolVersion = suite.init.ProtocolVersion
+	}
+	if suite.client != nil {
+		suite.client.Close()
+	}
+	report.Duration = time.Since(start)
+	return report
+}
+
+func checkInitialize(ctx context.Context, s *conformanceSuite) (string, error) {
+	client, err := s.connect()
+	if err != nil {
+		return "", err
+	}
+	s.client = client
+
+	init, err := client.Initialize(ctx, MCPProtocolVersion)
+	if err != nil {
+		return "", err
+	}
+	if !slices.Contains(SupportedProtocolVersions, init.ProtocolVersion) {
+		return "", fmt.Errorf("server answered with unsupported protocol version %q", init.ProtocolVersion)
+	}
+	if init.ServerInfo.Name == "" {
+		return "", fmt.Errorf("serverInfo.name is empty")
+	}
+
+	s.init = init
+	return fmt.Sprintf("negotiated %s with %s %s", init.ProtocolVersion, init.ServerInfo.Name, init.ServerInfo.Version), nil
+}
+
+func checkVersionNegotiation(ctx context.Context, s *conformanceSuite) (string, error) {
+	client, err := s.connect()
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+
+	// A server that doesn't support the requested version must offer one it does support
+	const bogusVersion = "1999-01-01"
+	init, err := client.Initialize(ctx, bogusVersion)
+	if err != nil {
+		return "", fmt.Errorf("server rejected an unknown version instead of offering its own: %w", err)
+	}
+	if init.ProtocolVersion == "" || init.ProtocolVersion == bogusVersion {
+		return "", fmt.Errorf("server accepted unknown protocol version %q", bogusVersion)
+	}
+	return fmt.Sprintf("server offered %s", init.ProtocolVersion), nil
+}
+
+func checkPing(ctx context.Context, s *conformanceSuite) (string, error) {
+	return "", s.client.Ping(ctx)
+}
+
+func checkToolsPagination(ctx context.Context, s *conformanceSuite) (string, error) {
+	if s.init.Capabilities.Tools == nil {
+		return "", errSkip("server does not advertise tools")
+	}
+
+	// Walk the pages one at a time, so each page and cursor can be checked
+	seen := make(map[string]bool)
+	cursors := make(map[string]bool)
+	var cursor string
+	pages := 0
+	for {
+		var params map[string]any
+		if cursor != "" {
+			params = map[string]any{"cursor": cursor}
+		}
+		var page struct {
+			Tools      []models.Tool `json:"tools"`
+			NextCursor string        `json:"nextCursor"`
+		}
+		if err := s.client.Call(ctx, "tools/list", params, &page); err != nil {
+			return "", fmt.Errorf("tools/list page %d failed: %w", pages+1, err)
+		}
+		pages++
+
+		for _, tool := range page.Tools {
+			if seen[tool.Name] {
+				return "", fmt.Errorf("tool %q listed more than once", tool.Name)
+			}
+			seen[tool.Name] = true
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		if cursors[page.NextCursor] {
+			return "", fmt.Errorf("tools/list returned cursor %q twice", page.NextCursor)
+		}
+		cursors[page.NextCursor] = true
+		cursor = page.NextCursor
+	}
+
+	// Servers must reject cursors they did not issue
+	err := s.client.Call(ctx, "tools/list", map[string]any{"cursor": "mcphub-invalid-cursor"}, nil)
+	var rpcErr *models.JSONRPCError
+	switch {
+	case err == nil:
+		return "", fmt.Errorf("server accepted a cursor it did not issue")
+	case !errors.As(err, &rpcErr):
+		return "", err
+	case rpcErr.Code != models.JSONRPCInvalidParams:
+		return "", fmt.Errorf("invalid cursor returned error code %d, want %d", rpcErr.Code, models.JSONRPCInvalidParams)
+	}
+	return fmt.Sprintf("listed %d tools in %d pages", len(seen), pages), nil
+}
+
+func checkToolInputSchemas(ctx context.Context, s *conformanceSuite) (string, error) {
+	if s.init.Capabilities.Tools == nil {
+		return "", errSkip("server does not advertise tools")
+	}
+
+	tools, err := s.client.ListTools(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	var problems []error
+	for _, tool := range tools {
+		if tool.Name == "" {
+			problems = append(problems, fmt.Errorf("tool with empty name"))
+			continue
+		}
+		if err := ValidateToolInputSchema(tool.InputSchema); err != nil {
+			problems = append(problems, fmt.Errorf("%s: %w", tool.Name, err))
+		}
+	}
+	if len(problems) > 0 {
+		return "", errors.Join(problems...)
+	}
+	return fmt.Sprintf("%d schemas valid", len(tools)), nil
+}
+
+func checkUnknownMethod(ctx context.Context, s *conformanceSuite) (string, error) {
+	err := s.client.Call(ctx, "mcphub/unknown-method", nil, nil)
+	var rpcErr *models.JSONRPCError
+	if !errors.As(err, &rpcErr) {
+		if err == nil {
+			return "", fmt.Errorf("unknown method succeeded")
+		}
+		return "", err
+	}
+	if rpcErr.Code != models.JSONRPCMethodNotFound {
+		return "", fmt.Errorf("unknown method returned error code %d, want %d", rpcErr.Code, models.JSONRPCMethodNotFound)
+	}
+	return "", nil
+}
+
+// cancellationGrace is how long a server gets to answer a cancelled request it should not answer
+const cancellationGrace = 2 * time.Second
+
+// checkCancellation cancels a request in flight, which the server must stop working on without
+// answering it. A server may answer before the notification arrives, so the answer only counts
+// against it when it comes after the server has answered a ping sent after the notification.
+func checkCancellation(ctx context.Context, s *conformanceSuite) (string, error) {
+	method := "ping"
+	if s.init.Capabilities.Tools != nil {
+		method = "tools/list"
+	}
+
+	requestCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	id, wait, err := s.client.send(requestCtx, method, nil)
+	if err != nil {
+		return "", err
+	}
+	if err := s.client.Notify(ctx, "notifications/cancelled", map[string]any{"requestId": id, "reason": "conformance test"}); err != nil {
+		return "", err
+	}
+	if err := s.client.Ping(ctx); err != nil {
+		return "", fmt.Errorf("server stopped responding after notifications/cancelled: %w", err)
+	}
+
+	answered, stop := context.WithCancel(ctx)
+	stop()
+	if _, err := wait(answered); err == nil {
+		return fmt.Sprintf("%s was answered before the cancellation arrived", method), nil
+	}
+
+	grace, stop := context.WithTimeout(ctx, cancellationGrace)
+	defer stop()
+	if _, err := wait(grace); err == nil {
+		return "", fmt.Errorf("server answered the cancelled %s request", method)
+	}
+	return "", nil
+}
+
+// checkStaleCancellation cancels a request that was already answered, which the server must ignore
+// and keep serving
+func checkStaleCancellation(ctx context.Context, s *conformanceSuite) (string, error) {
+	if err := s.client.Ping(ctx); err != nil {
+		return "", err
+	}
+	cancelled := s.client.nextID.Load()
+	if err := s.client.Notify(ctx, "notifications/cancelled", map[string]any{"requestId": cancelled, "reason": "conformance test"}); err != nil {
+		return "", err
+	}
+	if err := s.client.Ping(ctx); err != nil {
+		return "", fmt.Errorf("server stopped responding after notifications/cancelled: %w", err)
+	}
+	return "", nil
+}
+
+// checkShutdown ends the session the way its transport does: a stdio server must exit once its
+// stdin is closed, and an HTTP server must forget a session the client deleted.
+func checkShutdown(ctx context.Context, s *conformanceSuite) (string, error) {
+	client := s.client
+	s.client = nil
+
+	ctx, cancel := context.WithTimeout(ctx, shutdownTimeout)
+	defer cancel()
+
+	switch t := client.transport.(type) {
+	case *stdioTransport:
+		return "", t.shutdown(ctx)
+	case *httpTransport:
+		status, err := t.deleteSession(ctx)
+		switch {
+		case err != nil:
+			return "", fmt.Errorf("failed to delete the session: %w", err)
+		case status == 0:
+			return "", errSkip("server does not use sessions")
+		case status == http.StatusMethodNotAllowed:
+			return "", errSkip("server does not let clients delete sessions")
+		case status >= 300:
+			return "", fmt.Errorf("deleting the session returned HTTP %d", status)
+		}
+
+		// Requests in a deleted session must be answered with 404
+		err = client.Ping(ctx)
+		var statusErr *httpStatusError
+		if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound {
+			return "", nil
+		}
+		if err == nil {
+			return "", fmt.Errorf("server kept serving the session after it was deleted")
+		}
+		return "", fmt.Errorf("request in the deleted session did not return HTTP 404: %w", err)
+	}
+	return "", client.Close()
+}
+
+// ValidateToolInputSchema checks that a tool's input schema is a JSON Schema object description
+func ValidateToolInputSchema(raw json.RawMessage) error {
+	if len(raw) == 0 {
+		return fmt.Errorf("inputSchema is missing")
+	}
+
+	var schema map[string]any
+	if err := json.Unmarshal(raw, &schema); err != nil {
+		return fmt.Errorf("inputSchema is not a JSON object: %w", err)
+	}
+	if schema["type"] != "object" {
+		return fmt.Errorf(`inputSchema type must be "object", got %v`, schema["type"])
+	}
+
+	properties := map[string]any{}
+	if p, ok := schema["properties"]; ok {
+		if properties, ok = p.(map[string]any); !ok {
+			return fmt.Errorf("inputSchema properties must be an object")
+		}
+	}
+	for name, p := range properties {
+		property, ok := p.(map[string]any)
+		if !ok {
+			return fmt.Errorf("property %q must be a schema object", name)
+		}
+		if err := validateSchemaType(property["type"]); err != nil {
+			return fmt.Errorf("property %q: %w", name, err)
+		}
+	}
+
+	if r, ok := schema["required"]; ok {
+		required, ok := r.([]any)
+		if !ok {
+			return fmt.Errorf("inputSchema required must be an array")
+		}
+		for _, item := range required {
+			name, ok := item.(string)
+			if !ok {
+				return fmt.Errorf("inputSchema required entries must be strings")
+			}
+			if _, ok := properties[name]; !ok {
+				return fmt.Errorf("required property %q is not defined", name)
+			}
+		}
+	}
+
+	return nil
+}
+
+var jsonSchemaTypes = []string{"string", "number", "integer", "boolean", "object", "array", "null"}
+
+func validateSchemaType(t any) error {
+	switch t := t.(type) {
+	case nil:
+		return nil
+	case string:
+		if !slices.Contains(jsonSchemaTypes, t) {
+			return fmt.Errorf("unknown type %q", t)
+		}
+		return nil
+	case []any:
+		for _, item := range t {
+			if err := validateSchemaType(item); err != nil {
+				return err
+			}
+			if _, ok := item.(string); !ok {
+				return fmt.Errorf("type list entries must be strings")
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("type must be a string or an array of strings")
+	}
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name       `xml:"testsuites"`
+	Suites  []junitTestSet `xml:"testsuite"`
+}
+
+type junitTestSet struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Skipped  int             `xml:"skipped,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+}
+
+// WriteJUnitReport writes the report in the JUnit XML format understood by most CI systems
+func WriteJUnitReport(w io.Writer, report *models.ConformanceReport) error {
+	set := junitTestSet{
+		Name:     "mcp-conformance: " + report.Target,
+		Tests:    len(report.Results),
+		Failures: report.Failed,
+		Skipped:  report.Skipped,
+		Time:     fmt.Sprintf("%.3f", report.Duration.Seconds()),
+	}
+	for _, result := range report.Results {
+		testCase := junitTestCase{
+			Name:      result.Name,
+			ClassName: "mcp.conformance",
+			Time:      fmt.Sprintf("%.3f", result.Duration.Seconds()),
+		}
+		switch result.Status {
+		case models.CheckFailed:
+			testCase.Failure = &junitMessage{Message: result.Message}
+		case models.CheckSkipped:
+			testCase.Skipped = &junitMessage{Message: result.Message}
+		}
+		set.Cases = append(set.Cases, testCase)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(junitTestSuites{Suites: []junitTestSet{set}}); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
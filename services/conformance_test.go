@@ -0,0 +1,191 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"mcphub/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunConformanceSuite(t *testing.T) {
+	t.Run("Well-behaved server passes", func(t *testing.T) {
+		server := newToolServer([]models.Tool{
+			{Name: "add", InputSchema: json.RawMessage(`{"type":"object","properties":{"a":{"type":"number"}},"required":["a"]}`)},
+			{Name: "sub", InputSchema: json.RawMessage(`{"type":"object"}`)},
+			{Name: "mul", InputSchema: json.RawMessage(`{"type":"object"}`)},
+		})
+		report := RunConformanceSuite(context.Background(), "fake", func() (*MCPClient, error) { return server.connect(t), nil })
+
+		assert.Equal(t, 0, report.Failed, "%+v", report.Results)
+		assert.Equal(t, len(conformanceChecks), report.Passed)
+		assert.Equal(t, "fake", report.ServerInfo.Name)
+	})
+
+	t.Run("Invalid schemas and duplicate tools fail", func(t *testing.T) {
+		server := newToolServer([]models.Tool{
+			{Name: "add", InputSchema: json.RawMessage(`{"type":"string"}`)},
+			{Name: "add", InputSchema: json.RawMessage(`{"type":"object"}`)},
+		})
+		report := RunConformanceSuite(context.Background(), "fake", func() (*MCPClient, error) { return server.connect(t), nil })
+
+		statuses := make(map[string]string)
+		for _, result := range report.Results {
+			statuses[result.Name] = result.Status
+		}
+		assert.Equal(t, models.CheckFailed, statuses["tools-list-pagination"])
+		assert.Equal(t, models.CheckFailed, statuses["tool-input-schemas"])
+		assert.Equal(t, models.CheckPassed, statuses["unknown-method"])
+
+		var junit bytes.Buffer
+		require.NoError(t, WriteJUnitReport(&junit, report))
+		assert.Contains(t, junit.String(), `<testcase name="tool-input-schemas"`)
+		assert.Contains(t, junit.String(), `failures="2"`)
+	})
+}
+
+func TestCheckToolsPagination(t *testing.T) {
+	tools := []models.Tool{{Name: "add"}, {Name: "sub"}, {Name: "mul"}}
+	init := &models.InitializeResult{Capabilities: models.ServerCapabilities{Tools: &models.ListCapability{}}}
+
+	t.Run("Follows cursors and rejects unknown ones", func(t *testing.T) {
+		suite := &conformanceSuite{client: newToolServer(tools).connect(t), init: init}
+		message, err := checkToolsPagination(context.Background(), suite)
+		require.NoError(t, err)
+		assert.Equal(t, "listed 3 tools in 2 pages", message)
+	})
+
+	t.Run("Accepting an unknown cursor fails", func(t *testing.T) {
+		lenient := newToolServer(tools)
+		handle := lenient.handle
+		lenient.handle = func(msg *models.JSONRPCMessage) (any, *models.JSONRPCError) {
+			if msg.Method == "tools/list" && bytes.Contains(msg.Params, []byte("mcphub-invalid-cursor")) {
+				return map[string]any{"tools": []models.Tool{}}, nil
+			}
+			return handle(msg)
+		}
+		suite := &conformanceSuite{client: lenient.connect(t), init: init}
+		_, err := checkToolsPagination(context.Background(), suite)
+		assert.ErrorContains(t, err, "accepted a cursor it did not issue")
+	})
+}
+
+// connectHoldingServer connects to a server that holds the first request until it is cancelled,
+// then answers pings and, if answerCancelled, the cancelled request shortly after them
+func connectHoldingServer(t *testing.T, answerCancelled bool) *MCPClient {
+	clientReader, serverWriter := io.Pipe()
+	serverReader, clientWriter := io.Pipe()
+
+	go func() {
+		defer serverWriter.Close()
+		reply := func(id json.RawMessage) {
+			data, _ := json.Marshal(models.JSONRPCMessage{JSONRPC: "2.0", ID: id, Result: json.RawMessage("{}")})
+			serverWriter.Write(append(data, '\n'))
+		}
+
+		var held, cancelled json.RawMessage
+		scanner := bufio.NewScanner(serverReader)
+		for scanner.Scan() {
+			var msg models.JSONRPCMessage
+			json.Unmarshal(scanner.Bytes(), &msg)
+			switch {
+			case msg.Method == "notifications/cancelled":
+				cancelled = held
+			case held == nil:
+				held = msg.ID
+			default:
+				reply(msg.ID)
+				if answerCancelled && cancelled != nil {
+					time.Sleep(100 * time.Millisecond)
+					reply(cancelled)
+					cancelled = nil
+				}
+			}
+		}
+	}()
+
+	client := NewStdioMCPClient(clientReader, clientWriter)
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestCheckCancellation(t *testing.T) {
+	init := &models.InitializeResult{}
+
+	t.Run("Cancelled request left unanswered passes", func(t *testing.T) {
+		suite := &conformanceSuite{client: connectHoldingServer(t, false), init: init}
+		_, err := checkCancellation(context.Background(), suite)
+		assert.NoError(t, err)
+	})
+
+	t.Run("Answering a cancelled request fails", func(t *testing.T) {
+		suite := &conformanceSuite{client: connectHoldingServer(t, true), init: init}
+		_, err := checkCancellation(context.Background(), suite)
+		assert.ErrorContains(t, err, "answered the cancelled ping request")
+	})
+}
+
+func TestCheckShutdown(t *testing.T) {
+	t.Run("Stdio server exits when stdin is closed", func(t *testing.T) {
+		suite := &conformanceSuite{client: newToolServer(nil).connect(t)}
+		_, err := checkShutdown(context.Background(), suite)
+		assert.NoError(t, err)
+	})
+
+	// newSessionServer serves one session, which it forgets once deleted unless keepSessions is set
+	newSessionServer := func(keepSessions bool) *httptest.Server {
+		var deleted atomic.Bool
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodDelete {
+				deleted.Store(!keepSessions)
+				return
+			}
+			if deleted.Load() {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			var msg models.JSONRPCMessage
+			json.NewDecoder(r.Body).Decode(&msg)
+			w.Header().Set("Mcp-Session-Id", "session-1")
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(models.JSONRPCMessage{JSONRPC: "2.0", ID: msg.ID, Result: json.RawMessage("{}")})
+		}))
+	}
+
+	t.Run("HTTP server forgets a deleted session", func(t *testing.T) {
+		server := newSessionServer(false)
+		defer server.Close()
+
+		client := NewHTTPMCPClient(server.URL)
+		require.NoError(t, client.Ping(context.Background()))
+		_, err := checkShutdown(context.Background(), &conformanceSuite{client: client})
+		assert.NoError(t, err)
+	})
+
+	t.Run("HTTP server keeping a deleted session fails", func(t *testing.T) {
+		server := newSessionServer(true)
+		defer server.Close()
+
+		client := NewHTTPMCPClient(server.URL)
+		require.NoError(t, client.Ping(context.Background()))
+		_, err := checkShutdown(context.Background(), &conformanceSuite{client: client})
+		assert.ErrorContains(t, err, "kept serving the session")
+	})
+}
+
+func TestValidateToolInputSchema(t *testing.T) {
+	assert.NoError(t, ValidateToolInputSchema(json.RawMessage(`{"type":"object","properties":{"tags":{"type":["array","null"]}}}`)))
+	assert.Error(t, ValidateToolInputSchema(nil))
+	assert.Error(t, ValidateToolInputSchema(json.RawMessage(`{"type":"object","properties":{"a":{"type":"text"}}}`)))
+	assert.Error(t, ValidateToolInputSchema(json.RawMessage(`{"type":"object","required":["missing"]}`)))
+}
@@ -0,0 +1,73 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"mcphub/models"
+)
+
+// Labels put on every container started by mcphub run
+const (
+	ManagedLabel = "mcphub.managed"
+	ServerLabel  = "mcphub.server"
+	AuthorLabel  = "mcphub.author"
+	VersionLabel = "mcphub.version"
+)
+
+// ManagedContainerLabels identifies the server a container runs. config is nil for images not built by mcphub.
+func ManagedContainerLabels(config *models.MCPConfig, imageName string) map[string]string {
+	labels := map[string]string{
+		ManagedLabel: "true",
+		ServerLabel:  ImageFromRef(imageName),
+	}
+	if config != nil {
+		labels[ServerLabel] = strings.ToLower(config.Name)
+		if config.Author != "" {
+			labels[AuthorLabel] = config.Author
+		}
+		if config.Version != "" {
+			labels[VersionLabel] = config.Version
+		}
+	}
+	return labels
+}
+
+// FindManagedContainers returns the managed containers running a server, matched by container name or
+// by server name, and also by author when ref is an author/name reference
+func FindManagedContainers(ctx context.Context, engine ContainerEngine, ref string) ([]models.ManagedContainer, error) {
+	containers, err := ListManagedContainers(ctx, engine)
+	if err != nil {
+		return nil, err
+	}
+
+	server := ImageFromRef(ref)
+	author, _, hasAuthor := strings.Cut(ref, "/")
+	var matches []models.ManagedContainer
+	for _, container := range containers {
+		sameServer := container.Server == server && (!hasAuthor || strings.EqualFold(container.Author, author))
+		if sameServer || container.Name == ref {
+			matches = append(matches, container)
+		}
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("no mcphub-managed container found for %s", ref)
+	}
+	return matches, nil
+}
+
+// managedContainer fills in the server identity from a container's labels
+func managedContainer(id, name, image, state, status, ports string, labels map[string]string) models.ManagedContainer {
+	return models.ManagedContainer{
+		ID:      id,
+		Name:    name,
+		Server:  labels[ServerLabel],
+		Author:  labels[AuthorLabel],
+		Version: labels[VersionLabel],
+		Image:   image,
+		State:   state,
+		Status:  status,
+		Ports:   ports,
+	}
+}
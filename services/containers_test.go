@@ -0,0 +1,78 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"mcphub/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManagedContainerLabels(t *testing.T) {
+	labels := ManagedContainerLabels(&models.MCPConfig{Name: "Weather", Author: "alice", Version: "1.2.0"}, "weather")
+	assert.Equal(t, map[string]string{
+		ManagedLabel: "true",
+		ServerLabel:  "weather",
+		AuthorLabel:  "alice",
+		VersionLabel: "1.2.0",
+	}, labels)
+
+	labels = ManagedContainerLabels(nil, "bob/Other")
+	assert.Equal(t, map[string]string{ManagedLabel: "true", ServerLabel: "other"}, labels)
+}
+
+func TestParseContainerList(t *testing.T) {
+	out := []byte(`{"ID":"abc123","Image":"weather","Labels":"mcphub.managed=true,mcphub.server=weather,mcphub.version=1.2.0","Names":"weather","Ports":"127.0.0.1:8080->8080/tcp","State":"running","Status":"Up 2 minutes"}
+{"ID":"def456","Image":"notes","Labels":"mcphub.managed=true,mcphub.server=notes","Names":"notes-2","Ports":"","State":"exited","Status":"Exited (0) 1 hour ago"}
+`)
+
+	containers, err := parseContainerList(out)
+	require.NoError(t, err)
+	require.Len(t, containers, 2)
+
+	assert.Equal(t, models.ManagedContainer{
+		ID:      "abc123",
+		Name:    "weather",
+		Server:  "weather",
+		Version: "1.2.0",
+		Image:   "weather",
+		State:   "running",
+		Status:  "Up 2 minutes",
+		Ports:   "127.0.0.1:8080->8080/tcp",
+	}, containers[0])
+	assert.Equal(t, "notes", containers[1].Server)
+	assert.Equal(t, "exited", containers[1].State)
+
+	containers, err = parseContainerList([]byte("\n"))
+	require.NoError(t, err)
+	assert.Empty(t, containers)
+}
+
+func TestFindManagedContainers(t *testing.T) {
+	engine := newFakeEngineAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[
+			{"Id":"a1","Names":["/weather"],"Labels":{"mcphub.managed":"true","mcphub.server":"weather","mcphub.author":"alice"}},
+			{"Id":"b1","Names":["/weather-2"],"Labels":{"mcphub.managed":"true","mcphub.server":"weather","mcphub.author":"bob"}}]`))
+	}))
+	ids := func(ref string) []string {
+		containers, err := FindManagedContainers(context.Background(), engine, ref)
+		require.NoError(t, err)
+		var ids []string
+		for _, container := range containers {
+			ids = append(ids, container.ID)
+		}
+		return ids
+	}
+
+	// A reference with an author leaves other authors' servers of the same name alone
+	assert.Equal(t, []string{"a1"}, ids("alice/weather"))
+	assert.Equal(t, []string{"b1"}, ids("Bob/Weather"))
+	assert.Equal(t, []string{"a1", "b1"}, ids("weather"))
+	assert.Equal(t, []string{"b1"}, ids("weather-2"))
+
+	_, err := FindManagedContainers(context.Background(), engine, "carol/weather")
+	assert.Error(t, err)
+}
@@ -1,12 +1,18 @@
 package services
 
 import (
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"slices"
 	"strings"
 
 	"mcphub/models"
 )
 
+// ConfigLabel is the image label holding the full mcp.json, so pulled images can be run and exported without it
+const ConfigLabel = "mcphub.config"
+
 type DockerfileGenerator struct{}
 
 func NewDockerfileGenerator() *DockerfileGenerator {
@@ -14,10 +20,23 @@ func NewDockerfileGenerator() *DockerfileGenerator {
 }
 
 func (dg *DockerfileGenerator) Generate(config *models.MCPConfig) string {
+	return dg.generate(config, "")
+}
+
+// GenerateOnDependencies generates a Dockerfile that starts from dependencyImage, an image built
+// from GenerateDependencies, and runs only the install steps that need the whole project
+func (dg *DockerfileGenerator) GenerateOnDependencies(config *models.MCPConfig, dependencyImage string) string {
+	return dg.generate(config, dependencyImage)
+}
+
+func (dg *DockerfileGenerator) generate(config *models.MCPConfig, dependencyImage string) string {
 	var dockerfile strings.Builder
 
 	// Determine base image
 	baseImage := dg.getBaseImage(config.Run.Command)
+	if dependencyImage != "" {
+		baseImage = dependencyImage
+	}
 	dockerfile.WriteString(fmt.Sprintf("FROM %s\n\n", baseImage))
 
 	// Set working directory
@@ -30,13 +49,14 @@ func (dg *DockerfileGenerator) Generate(config *models.MCPConfig) string {
 	if config.Author != "" {
 		dockerfile.WriteString(fmt.Sprintf("LABEL author=\"%s\"\n", config.Author))
 	}
+	dockerfile.WriteString(fmt.Sprintf("LABEL %s=\"%s\"\n", ConfigLabel, EncodeConfigLabel(config)))
 	dockerfile.WriteString("\n")
 
 	// Copy app files
 	dockerfile.WriteString("COPY . .\n\n")
 
 	// Dependency installation
-	dg.addInstallCommands(&dockerfile, config.Run.Command)
+	dg.addInstallCommands(&dockerfile, config.Run.Command, dependencyImage != "")
 
 	// Expose port
 	if config.Run.Port > 0 {
@@ -67,23 +87,84 @@ func (dg *DockerfileGenerator) getBaseImage(command string) string {
 	}
 }
 
-func (dg *DockerfileGenerator) addInstallCommands(dockerfile *strings.Builder, command string) {
+// installStep is a RUN step installing dependencies. Steps with manifests read only those files and
+// can run in a dependency image; the others need the whole project.
+type installStep struct {
+	run       string
+	manifests []string
+}
+
+func (dg *DockerfileGenerator) installSteps(command string) []installStep {
 	switch command {
 	case "node":
-		dockerfile.WriteString("RUN if [ -f package.json ]; then npm install --only=production; fi\n")
-		dockerfile.WriteString("RUN if [ -f yarn.lock ]; then yarn install --production; fi\n\n")
+		return []installStep{
+			{run: "if [ -f package.json ]; then npm install --only=production; fi", manifests: []string{"package.json", "package-lock.json"}},
+			{run: "if [ -f yarn.lock ]; then yarn install --production; fi", manifests: []string{"package.json", "yarn.lock"}},
+		}
 	case "python", "python3":
-		dockerfile.WriteString("RUN if [ -f requirements.txt ]; then pip install --no-cache-dir -r requirements.txt; fi\n")
-		dockerfile.WriteString("RUN if [ -f pyproject.toml ]; then pip install uv && uv pip install --system .; fi\n")
-		dockerfile.WriteString("RUN if [ -f Pipfile ]; then pip install pipenv && pipenv install --system --deploy; fi\n\n")
+		return []installStep{
+			{run: "if [ -f requirements.txt ]; then pip install --no-cache-dir -r requirements.txt; fi", manifests: []string{"requirements.txt"}},
+			{run: "if [ -f pyproject.toml ]; then pip install uv && uv pip install --system .; fi"},
+			{run: "if [ -f Pipfile ]; then pip install pipenv && pipenv install --system --deploy; fi", manifests: []string{"Pipfile", "Pipfile.lock"}},
+		}
 	case "go":
-		dockerfile.WriteString("RUN if [ -f go.mod ]; then go mod download; fi\n")
-		dockerfile.WriteString("RUN if [ -f go.mod ]; then go build -o main .; fi\n\n")
-	default:
+		return []installStep{
+			{run: "if [ -f go.mod ]; then go mod download; fi", manifests: []string{"go.mod", "go.sum"}},
+			{run: "if [ -f go.mod ]; then go build -o main .; fi"},
+		}
+	}
+	return nil
+}
+
+// addInstallCommands writes the install steps, leaving out those a dependency image already ran
+func (dg *DockerfileGenerator) addInstallCommands(dockerfile *strings.Builder, command string, onDependencies bool) {
+	steps := dg.installSteps(command)
+	if steps == nil {
 		dockerfile.WriteString("# Add any custom installation commands here\n\n")
+		return
+	}
+
+	written := false
+	for _, step := range steps {
+		if onDependencies && step.manifests != nil {
+			continue
+		}
+		dockerfile.WriteString("RUN " + step.run + "\n")
+		written = true
+	}
+	if written {
+		dockerfile.WriteString("\n")
 	}
 }
 
+// dependencyManifestNames lists the files the install steps that run in a dependency image read
+func (dg *DockerfileGenerator) dependencyManifestNames(command string) []string {
+	var names []string
+	for _, step := range dg.installSteps(command) {
+		for _, name := range step.manifests {
+			if !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// GenerateDependencies generates the Dockerfile of a dependency image: the base image with the
+// manifests present copied in and the install steps that need only them run
+func (dg *DockerfileGenerator) GenerateDependencies(config *models.MCPConfig, manifests []string) string {
+	var dockerfile strings.Builder
+	dockerfile.WriteString(fmt.Sprintf("FROM %s\n\n", dg.getBaseImage(config.Run.Command)))
+	dockerfile.WriteString("WORKDIR /app\n\n")
+	dockerfile.WriteString(fmt.Sprintf("COPY %s ./\n\n", strings.Join(manifests, " ")))
+	for _, step := range dg.installSteps(config.Run.Command) {
+		if step.manifests != nil {
+			dockerfile.WriteString("RUN " + step.run + "\n")
+		}
+	}
+	return dockerfile.String()
+}
+
 func (dg *DockerfileGenerator) formatCommand(cmdArgs []string) string {
 	if len(cmdArgs) == 0 {
 		return "[\"\"]"
@@ -96,3 +177,23 @@ func (dg *DockerfileGenerator) formatCommand(cmdArgs []string) string {
 
 	return fmt.Sprintf("[%s]", strings.Join(quotedArgs, ", "))
 }
+
+// EncodeConfigLabel encodes the config as base64 JSON, which needs no escaping inside a Dockerfile
+func EncodeConfigLabel(config *models.MCPConfig) string {
+	data, _ := json.Marshal(config)
+	return base64.StdEncoding.EncodeToString(data)
+}
+
+// DecodeConfigLabel reverses EncodeConfigLabel
+func DecodeConfigLabel(value string) (*models.MCPConfig, error) {
+	data, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s label: %w", ConfigLabel, err)
+	}
+
+	var config models.MCPConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("invalid %s label: %w", ConfigLabel, err)
+	}
+	return &config, nil
+}
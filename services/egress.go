@@ -0,0 +1,117 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"mcphub/models"
+)
+
+// EgressProxyImage runs the proxy carrying the traffic of containers on the allowlist network
+var EgressProxyImage = "ubuntu/squid:5.2-22.04_beta"
+
+// EgressLabel names the container an egress proxy or network serves
+const EgressLabel = "mcphub.egress-for"
+
+const egressProxyPort = 3128
+
+// egressNames returns the network and proxy container of a container on the allowlist network
+func egressNames(container string) (network, proxy string) {
+	return "mcphub-egress-" + container, container + "-egress"
+}
+
+// StartEgress sets up the allowlist network of spec, which must be named. The container joins an
+// internal network of its own, which has no route out of the host, and reaches the allowed hosts
+// through a proxy joined to both that network and the default bridge. The proxy refuses every other
+// destination, including addresses used directly, so clients that ignore the proxy settings get no
+// network at all. RemoveEgress takes both down once the container is removed.
+func StartEgress(ctx context.Context, engine ContainerEngine, spec *ContainerSpec) error {
+	if spec.Sandbox == nil || spec.Sandbox.Network != "allowlist" {
+		return nil
+	}
+	if spec.Name == "" {
+		return fmt.Errorf("containers on the allowlist network need a name")
+	}
+	network, proxyName := egressNames(spec.Name)
+	labels := map[string]string{EgressLabel: spec.Name}
+
+	if err := engine.CreateNetwork(ctx, network, true, labels); err != nil {
+		return fmt.Errorf("failed to create the allowlist network: %w", err)
+	}
+	proxy := &ContainerSpec{
+		Image:  EgressProxyImage,
+		Name:   proxyName,
+		Labels: labels,
+		Env:    map[string]string{"SQUID_CONFIG": squidConfig(spec.Sandbox.AllowHosts)},
+		// The config is only passed in the environment, so nothing has to be mounted into the proxy
+		Command: []string{"sh", "-c", `printf '%s\n' "$SQUID_CONFIG" > /tmp/squid.conf && exec squid -N -f /tmp/squid.conf`},
+		Network: "bridge",
+		Pull:    true,
+	}
+	proxyID, err := engine.RunContainer(ctx, proxy)
+	if err == nil {
+		err = engine.ConnectNetwork(ctx, network, proxyID)
+	}
+	if err != nil {
+		RemoveEgress(context.Background(), engine, spec.Name)
+		return fmt.Errorf("failed to start the egress proxy: %w", err)
+	}
+
+	proxyURL := fmt.Sprintf("http://%s:%d", proxyName, egressProxyPort)
+	if spec.Env == nil {
+		spec.Env = make(map[string]string)
+	}
+	for _, name := range []string{"HTTP_PROXY", "HTTPS_PROXY", "http_proxy", "https_proxy"} {
+		spec.Env[name] = proxyURL
+	}
+	spec.Env["NO_PROXY"] = "localhost,127.0.0.1"
+	spec.Env["no_proxy"] = spec.Env["NO_PROXY"]
+	spec.Network = network
+	return nil
+}
+
+// RemoveEgress removes the egress proxy and allowlist network of a container, if it has them
+func RemoveEgress(ctx context.Context, engine ContainerEngine, container string) error {
+	proxies, err := EgressProxies(ctx, engine, container)
+	if err != nil {
+		return err
+	}
+	for _, proxy := range proxies {
+		if err := engine.RemoveContainer(ctx, proxy.ID, true); err != nil {
+			return err
+		}
+	}
+	network, _ := egressNames(container)
+	if err := engine.RemoveNetwork(ctx, network); err != nil && len(proxies) > 0 {
+		return err
+	}
+	return nil
+}
+
+// EgressProxies returns the egress proxy containers serving a container
+func EgressProxies(ctx context.Context, engine ContainerEngine, container string) ([]models.ManagedContainer, error) {
+	return engine.ListContainers(ctx, map[string]string{EgressLabel: container})
+}
+
+// squidConfig only lets requests through to the allowed hosts, over HTTP or HTTPS. Destinations are
+// matched by name without lookups, so an address used directly matches no allowed host.
+func squidConfig(hosts []string) string {
+	domains := make([]string, len(hosts))
+	for i, host := range hosts {
+		// Squid writes *.example.com as .example.com, which also matches example.com itself
+		domains[i] = strings.TrimPrefix(host, "*")
+	}
+	return strings.Join([]string{
+		fmt.Sprintf("http_port %d", egressProxyPort),
+		"acl allowed dstdomain -n " + strings.Join(domains, " "),
+		"acl web_ports port 80 443",
+		"http_access deny !web_ports",
+		"http_access allow allowed",
+		"http_access deny all",
+		"cache deny all",
+		"pid_filename none",
+		"access_log stdio:/dev/stdout",
+		"cache_log /dev/stderr",
+	}, "\n")
+}
@@ -0,0 +1,85 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"mcphub/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStartEgress(t *testing.T) {
+	var requests []string
+	var network map[string]any
+	var proxy apiContainerConfig
+	var connected map[string]string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		switch r.URL.Path {
+		case "/networks/create":
+			json.NewDecoder(r.Body).Decode(&network)
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"Id":"net1"}`))
+		case "/containers/create":
+			json.NewDecoder(r.Body).Decode(&proxy)
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"Id":"proxy1"}`))
+		case "/networks/mcphub-egress-weather/connect":
+			json.NewDecoder(r.Body).Decode(&connected)
+		case "/containers/json":
+			assert.Equal(t, `{"label":["mcphub.egress-for=weather"]}`, r.URL.Query().Get("filters"))
+			w.Write([]byte(`[{"Id":"proxy1","Names":["/weather-egress"],"Labels":{"mcphub.egress-for":"weather"}}]`))
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+	engine := newFakeEngineAPI(t, mux)
+
+	sandbox, err := ResolveSandbox(nil, &models.SandboxConfig{Network: "allowlist", AllowHosts: []string{"api.example.com", "*.example.org"}})
+	require.NoError(t, err)
+	spec := &ContainerSpec{Image: "weather", Name: "weather", Sandbox: sandbox}
+
+	// Without its proxy, the container is not started with a network that reaches anything
+	_, err = containerConfig(spec, false)
+	assert.ErrorContains(t, err, "egress proxy")
+
+	require.NoError(t, StartEgress(context.Background(), engine, spec))
+	assert.Equal(t, []string{
+		"POST /networks/create",
+		"POST /containers/create",
+		"POST /containers/proxy1/start",
+		"POST /networks/mcphub-egress-weather/connect",
+	}, requests)
+	assert.Equal(t, "mcphub-egress-weather", network["Name"])
+	assert.Equal(t, true, network["Internal"])
+	assert.Equal(t, EgressProxyImage, proxy.Image)
+	assert.Equal(t, "bridge", proxy.HostConfig.NetworkMode)
+	assert.Equal(t, []string{"sh"}, proxy.Entrypoint)
+	assert.Contains(t, proxy.Env, "SQUID_CONFIG="+squidConfig(sandbox.AllowHosts))
+	assert.Equal(t, "proxy1", connected["Container"])
+
+	// The server only has the internal network, and is pointed at the proxy
+	config, err := containerConfig(spec, false)
+	require.NoError(t, err)
+	assert.Equal(t, "mcphub-egress-weather", config.HostConfig.NetworkMode)
+	assert.Contains(t, config.Env, "HTTPS_PROXY=http://weather-egress:3128")
+
+	requests = nil
+	require.NoError(t, RemoveEgress(context.Background(), engine, "weather"))
+	assert.Equal(t, []string{"GET /containers/json", "DELETE /containers/proxy1", "DELETE /networks/mcphub-egress-weather"}, requests)
+}
+
+func TestSquidConfig(t *testing.T) {
+	config := squidConfig([]string{"api.example.com", "*.example.org"})
+	assert.Contains(t, config, "acl allowed dstdomain -n api.example.com .example.org\n")
+	// Only the allowed hosts get through; everything else is refused
+	allow := strings.Index(config, "http_access allow allowed")
+	deny := strings.Index(config, "http_access deny all")
+	assert.True(t, allow >= 0 && allow < deny)
+}
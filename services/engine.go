@@ -0,0 +1,357 @@
+package services
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"mcphub/models"
+
+	"github.com/moby/patternmatcher"
+	"github.com/moby/patternmatcher/ignorefile"
+)
+
+// ContainerEngine builds, moves and runs images
+type ContainerEngine interface {
+	// Name identifies the engine in command output
+	Name() string
+	// Ping checks that the engine is installed and running
+	Ping(ctx context.Context) error
+	// Platform returns the os/arch of the images the engine runs natively
+	Platform(ctx context.Context) (string, error)
+
+	// BuildImage builds the Dockerfile in contextDir as tag for platform (empty for the engine's own),
+	// streaming build output to logs
+	BuildImage(ctx context.Context, contextDir, tag, platform string, logs io.Writer) error
+	// SaveImage writes the image as a tar archive to w
+	SaveImage(ctx context.Context, image string, w io.Writer) error
+	// LoadImage loads a tar archive and returns the tags it contained
+	LoadImage(ctx context.Context, r io.Reader) ([]string, error)
+	// ImageLabels returns the labels of a local image
+	ImageLabels(ctx context.Context, image string) (map[string]string, error)
+	// TagImage adds the tag ref (repository[:tag]) to a local image
+	TagImage(ctx context.Context, image, ref string) error
+	// RemoveImage removes a local image tag
+	RemoveImage(ctx context.Context, image string) error
+
+	// RunContainer starts a detached container and returns its ID
+	RunContainer(ctx context.Context, spec *ContainerSpec) (string, error)
+	// AttachContainer starts a container with its stdin and stdout connected to the caller; stderr goes to stderr
+	AttachContainer(ctx context.Context, spec *ContainerSpec, stderr io.Writer) (*AttachedContainer, error)
+	// ContainerPort returns the host address a container port is published on
+	ContainerPort(ctx context.Context, id string, port int) (string, error)
+	// ListContainers returns every container, running or not, carrying all of labels
+	ListContainers(ctx context.Context, labels map[string]string) ([]models.ManagedContainer, error)
+	StopContainer(ctx context.Context, id string) error
+	RestartContainer(ctx context.Context, id string) error
+	// RemoveContainer removes a container, stopping it first when force is set
+	RemoveContainer(ctx context.Context, id string, force bool) error
+	// ContainerLogs copies a container's logs, streaming new output when follow is set
+	ContainerLogs(ctx context.Context, id string, follow bool, tail string, stdout, stderr io.Writer) error
+
+	// CreateNetwork creates a bridge network; an internal one has no route out of the host
+	CreateNetwork(ctx context.Context, name string, internal bool, labels map[string]string) error
+	// ConnectNetwork attaches a container to a further network
+	ConnectNetwork(ctx context.Context, network, container string) error
+	RemoveNetwork(ctx context.Context, name string) error
+}
+
+// ContainerSpec describes a container to start
+type ContainerSpec struct {
+	Image  string
+	Name   string
+	Labels map[string]string
+	// Env values are handed to the engine without appearing on a command line
+	Env map[string]string
+	// Ports use the docker -p syntax: [ip:][hostPort:]containerPort
+	Ports   []string
+	Sandbox *SandboxOptions
+	// Network attaches the container to a named network instead of the sandbox's network mode.
+	// Containers on the allowlist network must have one, see StartEgress.
+	Network string
+	// Command replaces the image's entrypoint and command when set
+	Command []string
+	// Pull fetches the image from its registry when it is not present locally
+	Pull bool
+	// Remove deletes the container once it exits
+	Remove bool
+}
+
+// containerNetwork returns the network mode of spec
+func containerNetwork(spec *ContainerSpec) (string, error) {
+	if spec.Network != "" {
+		return spec.Network, nil
+	}
+	if spec.Sandbox == nil {
+		return "", nil
+	}
+	if spec.Sandbox.Network == "allowlist" {
+		return "", fmt.Errorf("the allowlist network needs its egress proxy started first")
+	}
+	return spec.Sandbox.Network, nil
+}
+
+// EngineError is a failed engine operation
+type EngineError struct {
+	Engine string
+	Op     string
+	// Status is the HTTP status of Engine API errors, or 0
+	Status  int
+	Message string
+}
+
+func (e *EngineError) Error() string {
+	return fmt.Sprintf("%s %s failed: %s", e.Engine, e.Op, e.Message)
+}
+
+// AttachedContainer is a running container whose stdio is connected to the caller
+type AttachedContainer struct {
+	Stdin  io.WriteCloser
+	Stdout io.Reader
+
+	wait func() error
+	kill func() error
+}
+
+// Wait blocks until the container exits
+func (c *AttachedContainer) Wait() error {
+	return c.wait()
+}
+
+// Kill stops the container immediately
+func (c *AttachedContainer) Kill() error {
+	return c.kill()
+}
+
+// ContainerEngines lists the engines that can be selected by name
+var ContainerEngines = []string{"docker", "podman"}
+
+// NewContainerEngine connects to the named engine, or detects one when name is empty or "auto".
+// Each engine is reached through its API socket when that answers, otherwise through its binary.
+func NewContainerEngine(ctx context.Context, name string) (ContainerEngine, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	switch name {
+	case "", "auto":
+		for _, candidate := range ContainerEngines {
+			if engine, err := connectEngine(ctx, candidate); err == nil {
+				return engine, nil
+			}
+		}
+		return nil, fmt.Errorf("no container engine is running. Please start Docker or Podman and try again")
+	case "docker", "podman":
+		return connectEngine(ctx, name)
+	default:
+		return nil, fmt.Errorf("unknown container engine %q. Use: auto, %s", name, strings.Join(ContainerEngines, ", "))
+	}
+}
+
+func connectEngine(ctx context.Context, name string) (ContainerEngine, error) {
+	var api *DockerAPIEngine
+	var err error
+	if name == "podman" {
+		api, err = NewPodmanAPIEngine(podmanHost())
+	} else {
+		api, err = NewDockerAPIEngine(os.Getenv("DOCKER_HOST"))
+	}
+	if err == nil && api.Ping(ctx) == nil {
+		return api, nil
+	}
+
+	if _, err := exec.LookPath(name); err == nil {
+		cli := NewExecEngine(name)
+		if name == "podman" {
+			cli = NewPodmanEngine()
+		}
+		if cli.Ping(ctx) == nil {
+			return cli, nil
+		}
+	}
+	return nil, fmt.Errorf("%s is not running or not installed", name)
+}
+
+// podmanHost returns the Podman API socket: CONTAINER_HOST, the rootless socket, then the rootful one
+func podmanHost() string {
+	if host := os.Getenv("CONTAINER_HOST"); host != "" {
+		return host
+	}
+	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+		socket := filepath.Join(runtimeDir, "podman", "podman.sock")
+		if _, err := os.Stat(socket); err == nil {
+			return "unix://" + socket
+		}
+	}
+	return "unix:///run/podman/podman.sock"
+}
+
+// ListManagedContainers returns every container, running or not, started by mcphub
+func ListManagedContainers(ctx context.Context, engine ContainerEngine) ([]models.ManagedContainer, error) {
+	return engine.ListContainers(ctx, map[string]string{ManagedLabel: "true"})
+}
+
+// archiveRepoTags reads the tags of a docker save archive from its manifest.json
+func archiveRepoTags(r io.Reader) []string {
+	archive := tar.NewReader(r)
+	for {
+		header, err := archive.Next()
+		if err != nil {
+			return nil
+		}
+		if header.Name != "manifest.json" {
+			continue
+		}
+
+		var manifest []struct {
+			RepoTags []string `json:"RepoTags"`
+		}
+		if err := json.NewDecoder(archive).Decode(&manifest); err != nil {
+			return nil
+		}
+		var tags []string
+		for _, entry := range manifest {
+			tags = append(tags, entry.RepoTags...)
+		}
+		return tags
+	}
+}
+
+// loadArchive hands the archive to load while reading its tags from the same stream
+func loadArchive(r io.Reader, load func(io.Reader) error) ([]string, error) {
+	pr, pw := io.Pipe()
+	tags := make(chan []string, 1)
+	go func() {
+		tags <- archiveRepoTags(pr)
+		io.Copy(io.Discard, pr)
+	}()
+
+	err := load(io.TeeReader(r, pw))
+	pw.Close()
+	found := <-tags
+	if err != nil {
+		return nil, err
+	}
+	return found, nil
+}
+
+// tarDirectory writes dir as a tar archive, the build context format of the Engine API. Like the
+// docker CLI it leaves out only what .dockerignore excludes, so both engines build the same context.
+func tarDirectory(dir string, w io.Writer) error {
+	// The Dockerfile is always sent, as the docker CLI does
+	ignore, err := readDockerignore(dir, "Dockerfile")
+	if err != nil {
+		return err
+	}
+
+	archive := tar.NewWriter(w)
+	err = walkBuildContext(dir, ignore, func(path, rel string, info os.FileInfo) error {
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+		if err := archive.WriteHeader(header); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.Copy(archive, file)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return archive.Close()
+}
+
+// readDockerignore compiles the patterns of dir's .dockerignore, or returns nil when it has none.
+// The files in keep are never excluded.
+func readDockerignore(dir string, keep ...string) (*patternmatcher.PatternMatcher, error) {
+	file, err := os.Open(filepath.Join(dir, ".dockerignore"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	patterns, err := ignorefile.ReadAll(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read .dockerignore: %w", err)
+	}
+	for _, name := range keep {
+		patterns = append(patterns, "!"+name)
+	}
+	return patternmatcher.New(patterns)
+}
+
+// walkBuildContext calls fn for every file and directory below dir that ignore, when not nil, does
+// not exclude. Both builders walk the build context with it, so they put the same files in images.
+func walkBuildContext(dir string, ignore *patternmatcher.PatternMatcher, fn func(path, rel string, info os.FileInfo) error) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		if ignore != nil {
+			excluded, err := ignore.MatchesOrParentMatches(rel)
+			if err != nil {
+				return err
+			}
+			if excluded {
+				// Exclusions may bring back files below an excluded directory
+				if info.IsDir() && !ignore.Exclusions() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+		}
+		return fn(path, rel, info)
+	})
+}
+
+// ignoreGeneratedDockerfile adds the Dockerfile generated into dir to its .dockerignore. The engine
+// is still sent the Dockerfile to build with, but it is not copied into the image by either builder.
+func ignoreGeneratedDockerfile(dir string) error {
+	file, err := os.OpenFile(filepath.Join(dir, ".dockerignore"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.WriteString("\nDockerfile\n"); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// ImageFromRef maps an author/image-name reference to the local image tag created by push and pull
+func ImageFromRef(ref string) string {
+	if i := strings.LastIndex(ref, "/"); i >= 0 {
+		ref = ref[i+1:]
+	}
+	return strings.ToLower(ref)
+}
@@ -0,0 +1,745 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"mcphub/models"
+)
+
+// DefaultDockerHost is the Docker Engine API socket used when DOCKER_HOST is not set
+const DefaultDockerHost = "unix:///var/run/docker.sock"
+
+// DockerAPIEngine talks to the Docker Engine API, or Podman's compatible API, over a Unix socket or TCP
+type DockerAPIEngine struct {
+	name    string
+	network string
+	address string
+	client  *http.Client
+}
+
+// NewDockerAPIEngine connects to host, a unix:// or tcp:// address (DefaultDockerHost when empty)
+func NewDockerAPIEngine(host string) (*DockerAPIEngine, error) {
+	if host == "" {
+		host = DefaultDockerHost
+	}
+	return newAPIEngine("docker", host)
+}
+
+// NewPodmanAPIEngine connects to the Docker-compatible API of a Podman service at host
+func NewPodmanAPIEngine(host string) (*DockerAPIEngine, error) {
+	return newAPIEngine("podman", host)
+}
+
+func newAPIEngine(name, host string) (*DockerAPIEngine, error) {
+	e := &DockerAPIEngine{name: name}
+	switch {
+	case strings.HasPrefix(host, "unix://"):
+		e.network, e.address = "unix", strings.TrimPrefix(host, "unix://")
+	case strings.HasPrefix(host, "tcp://"):
+		e.network, e.address = "tcp", strings.TrimPrefix(host, "tcp://")
+	default:
+		return nil, fmt.Errorf("unsupported engine host %q (use unix:// or tcp://)", host)
+	}
+
+	e.client = &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return e.dial(ctx)
+			},
+		},
+	}
+	return e, nil
+}
+
+func (e *DockerAPIEngine) Name() string {
+	return e.name
+}
+
+func (e *DockerAPIEngine) dial(ctx context.Context) (net.Conn, error) {
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, e.network, e.address)
+}
+
+func (e *DockerAPIEngine) Ping(ctx context.Context) error {
+	resp, err := e.do(ctx, "ping", http.MethodGet, "/_ping", nil, nil, "")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (e *DockerAPIEngine) Platform(ctx context.Context) (string, error) {
+	var version struct {
+		Os   string `json:"Os"`
+		Arch string `json:"Arch"`
+	}
+	if err := e.doJSON(ctx, "version", http.MethodGet, "/version", nil, nil, &version); err != nil {
+		return "", err
+	}
+	return version.Os + "/" + version.Arch, nil
+}
+
+// engineMessage is one object of the JSON streams returned by build, load and pull
+type engineMessage struct {
+	Stream      string `json:"stream"`
+	Status      string `json:"status"`
+	Progress    string `json:"progress"`
+	ID          string `json:"id"`
+	Error       string `json:"error"`
+	ErrorDetail struct {
+		Message string `json:"message"`
+	} `json:"errorDetail"`
+}
+
+func (e *DockerAPIEngine) BuildImage(ctx context.Context, contextDir, tag, platform string, logs io.Writer) error {
+	if logs == nil {
+		logs = io.Discard
+	}
+
+	body, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(tarDirectory(contextDir, writer))
+	}()
+	defer body.Close()
+
+	query := url.Values{"t": {tag}, "rm": {"1"}, "forcerm": {"1"}}
+	if platform != "" {
+		query.Set("platform", platform)
+	}
+	resp, err := e.do(ctx, "build", http.MethodPost, "/build", query, body, "application/x-tar")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return e.readMessages("build", resp.Body, func(msg *engineMessage) {
+		switch {
+		case msg.Stream != "":
+			io.WriteString(logs, msg.Stream)
+		case msg.Status != "":
+			line := msg.Status
+			if msg.ID != "" {
+				line = msg.ID + ": " + line
+			}
+			if msg.Progress != "" {
+				line += " " + msg.Progress
+			}
+			fmt.Fprintln(logs, line)
+		}
+	})
+}
+
+func (e *DockerAPIEngine) SaveImage(ctx context.Context, image string, w io.Writer) error {
+	resp, err := e.do(ctx, "save", http.MethodGet, "/images/get", url.Values{"names": {image}}, nil, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return fmt.Errorf("failed to save image %s: %w", image, err)
+	}
+	return nil
+}
+
+func (e *DockerAPIEngine) LoadImage(ctx context.Context, r io.Reader) ([]string, error) {
+	return loadArchive(r, func(archive io.Reader) error {
+		resp, err := e.do(ctx, "load", http.MethodPost, "/images/load", url.Values{"quiet": {"1"}}, archive, "application/x-tar")
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		return e.readMessages("load", resp.Body, func(*engineMessage) {})
+	})
+}
+
+// imagePath returns the API path of an image. Each component of its name is escaped, so a name
+// holding "?", "#" or "%" cannot change the request, while the slashes between them are kept.
+func imagePath(image string) string {
+	components := strings.Split(image, "/")
+	for i, component := range components {
+		components[i] = url.PathEscape(component)
+	}
+	return "/images/" + strings.Join(components, "/")
+}
+
+func (e *DockerAPIEngine) ImageLabels(ctx context.Context, image string) (map[string]string, error) {
+	var inspect struct {
+		Config struct {
+			Labels map[string]string `json:"Labels"`
+		} `json:"Config"`
+	}
+	if err := e.doJSON(ctx, "inspect", http.MethodGet, imagePath(image)+"/json", nil, nil, &inspect); err != nil {
+		return nil, err
+	}
+	return inspect.Config.Labels, nil
+}
+
+func (e *DockerAPIEngine) TagImage(ctx context.Context, image, ref string) error {
+	repo, tag := ref, "latest"
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		repo, tag = ref[:i], ref[i+1:]
+	}
+	query := url.Values{"repo": {repo}, "tag": {tag}}
+	return e.doJSON(ctx, "tag", http.MethodPost, imagePath(image)+"/tag", query, nil, nil)
+}
+
+func (e *DockerAPIEngine) RemoveImage(ctx context.Context, image string) error {
+	return e.doJSON(ctx, "rmi", http.MethodDelete, imagePath(image), nil, nil, nil)
+}
+
+// apiContainerConfig is the body of POST /containers/create
+type apiContainerConfig struct {
+	Image        string              `json:"Image"`
+	Env          []string            `json:"Env,omitempty"`
+	Labels       map[string]string   `json:"Labels,omitempty"`
+	ExposedPorts map[string]struct{} `json:"ExposedPorts,omitempty"`
+	Entrypoint   []string            `json:"Entrypoint,omitempty"`
+	Cmd          []string            `json:"Cmd,omitempty"`
+	OpenStdin    bool                `json:"OpenStdin,omitempty"`
+	StdinOnce    bool                `json:"StdinOnce,omitempty"`
+	AttachStdin  bool                `json:"AttachStdin,omitempty"`
+	AttachStdout bool                `json:"AttachStdout,omitempty"`
+	AttachStderr bool                `json:"AttachStderr,omitempty"`
+	HostConfig   apiHostConfig       `json:"HostConfig"`
+}
+
+type apiHostConfig struct {
+	AutoRemove     bool                        `json:"AutoRemove,omitempty"`
+	PortBindings   map[string][]apiPortBinding `json:"PortBindings,omitempty"`
+	Memory         int64                       `json:"Memory,omitempty"`
+	NanoCpus       int64                       `json:"NanoCpus,omitempty"`
+	PidsLimit      int64                       `json:"PidsLimit,omitempty"`
+	NetworkMode    string                      `json:"NetworkMode,omitempty"`
+	ReadonlyRootfs bool                        `json:"ReadonlyRootfs,omitempty"`
+	CapDrop        []string                    `json:"CapDrop,omitempty"`
+	SecurityOpt    []string                    `json:"SecurityOpt,omitempty"`
+	Tmpfs          map[string]string           `json:"Tmpfs,omitempty"`
+	Binds          []string                    `json:"Binds,omitempty"`
+}
+
+type apiPortBinding struct {
+	HostIP   string `json:"HostIp"`
+	HostPort string `json:"HostPort"`
+}
+
+// containerConfig translates spec into the create request
+func containerConfig(spec *ContainerSpec, attach bool) (*apiContainerConfig, error) {
+	config := &apiContainerConfig{
+		Image:  spec.Image,
+		Labels: spec.Labels,
+		HostConfig: apiHostConfig{
+			AutoRemove: spec.Remove,
+		},
+	}
+	for _, name := range sortedKeys(spec.Env) {
+		config.Env = append(config.Env, name+"="+spec.Env[name])
+	}
+	if len(spec.Command) > 0 {
+		config.Entrypoint = spec.Command[:1]
+		config.Cmd = spec.Command[1:]
+	}
+	if attach {
+		config.OpenStdin = true
+		config.StdinOnce = true
+		config.AttachStdin = true
+		config.AttachStdout = true
+		config.AttachStderr = true
+	}
+
+	for _, mapping := range spec.Ports {
+		port, binding, err := parsePortSpec(mapping)
+		if err != nil {
+			return nil, err
+		}
+		if config.ExposedPorts == nil {
+			config.ExposedPorts = make(map[string]struct{})
+			config.HostConfig.PortBindings = make(map[string][]apiPortBinding)
+		}
+		config.ExposedPorts[port] = struct{}{}
+		config.HostConfig.PortBindings[port] = append(config.HostConfig.PortBindings[port], binding)
+	}
+
+	if spec.Sandbox != nil {
+		if err := applySandbox(&config.HostConfig, spec.Sandbox); err != nil {
+			return nil, err
+		}
+	}
+	network, err := containerNetwork(spec)
+	if err != nil {
+		return nil, err
+	}
+	config.HostConfig.NetworkMode = network
+	return config, nil
+}
+
+// applySandbox sets the host config equivalent of SandboxDockerArgs
+func applySandbox(host *apiHostConfig, opts *SandboxOptions) error {
+	if opts.Memory != "" {
+		memory, err := parseMemory(opts.Memory)
+		if err != nil {
+			return err
+		}
+		host.Memory = memory
+	}
+	if opts.CPUs != "" {
+		cpus, err := strconv.ParseFloat(opts.CPUs, 64)
+		if err != nil {
+			return fmt.Errorf("invalid CPU limit %q", opts.CPUs)
+		}
+		host.NanoCpus = int64(cpus * 1e9)
+	}
+	host.PidsLimit = int64(opts.PidsLimit)
+
+	host.ReadonlyRootfs = opts.ReadOnlyRootfs
+	if opts.DropCaps {
+		host.CapDrop = []string{"ALL"}
+	}
+	if opts.NoNewPrivileges {
+		host.SecurityOpt = []string{"no-new-privileges"}
+	}
+	for _, tmpfs := range opts.Tmpfs {
+		if host.Tmpfs == nil {
+			host.Tmpfs = make(map[string]string)
+		}
+		path, options, _ := strings.Cut(tmpfs, ":")
+		host.Tmpfs[path] = options
+	}
+	for _, volume := range opts.Volumes {
+		mode := "ro"
+		if volume.Writable {
+			mode = "rw"
+		}
+		host.Binds = append(host.Binds, fmt.Sprintf("%s:%s:%s", volume.Source, volume.Target, mode))
+	}
+	return nil
+}
+
+// parsePortSpec parses [ip:][hostPort:]containerPort[/protocol]
+func parsePortSpec(spec string) (string, apiPortBinding, error) {
+	var binding apiPortBinding
+	parts := strings.Split(spec, ":")
+	switch len(parts) {
+	case 1:
+	case 2:
+		binding.HostPort = parts[0]
+	case 3:
+		binding.HostIP, binding.HostPort = parts[0], parts[1]
+	default:
+		return "", binding, fmt.Errorf("invalid port mapping %q", spec)
+	}
+
+	port := parts[len(parts)-1]
+	if !strings.Contains(port, "/") {
+		port += "/tcp"
+	}
+	number, _, _ := strings.Cut(port, "/")
+	if _, err := strconv.Atoi(number); err != nil {
+		return "", binding, fmt.Errorf("invalid port mapping %q", spec)
+	}
+	return port, binding, nil
+}
+
+// parseMemory converts a docker memory limit such as 512m into bytes
+func parseMemory(value string) (int64, error) {
+	n, err := ParseSize(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid memory limit %q", value)
+	}
+	return n, nil
+}
+
+func (e *DockerAPIEngine) createContainer(ctx context.Context, spec *ContainerSpec, attach bool) (string, error) {
+	config, err := containerConfig(spec, attach)
+	if err != nil {
+		return "", err
+	}
+
+	var query url.Values
+	if spec.Name != "" {
+		query = url.Values{"name": {spec.Name}}
+	}
+	var created struct {
+		ID string `json:"Id"`
+	}
+	err = e.doJSON(ctx, "create", http.MethodPost, "/containers/create", query, config, &created)
+	var engineErr *EngineError
+	if spec.Pull && errors.As(err, &engineErr) && engineErr.Status == http.StatusNotFound {
+		if err := e.pullImage(ctx, spec.Image); err != nil {
+			return "", err
+		}
+		err = e.doJSON(ctx, "create", http.MethodPost, "/containers/create", query, config, &created)
+	}
+	if err != nil {
+		return "", err
+	}
+	return created.ID, nil
+}
+
+// pullImage fetches image from its registry
+func (e *DockerAPIEngine) pullImage(ctx context.Context, image string) error {
+	resp, err := e.do(ctx, "pull", http.MethodPost, "/images/create", url.Values{"fromImage": {image}}, nil, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return e.readMessages("pull", resp.Body, func(*engineMessage) {})
+}
+
+func (e *DockerAPIEngine) RunContainer(ctx context.Context, spec *ContainerSpec) (string, error) {
+	id, err := e.createContainer(ctx, spec, false)
+	if err != nil {
+		return "", err
+	}
+	if err := e.doJSON(ctx, "start", http.MethodPost, "/containers/"+id+"/start", nil, nil, nil); err != nil {
+		e.RemoveContainer(context.Background(), id, true)
+		return "", err
+	}
+	return id, nil
+}
+
+func (e *DockerAPIEngine) AttachContainer(ctx context.Context, spec *ContainerSpec, stderr io.Writer) (*AttachedContainer, error) {
+	if stderr == nil {
+		stderr = io.Discard
+	}
+
+	id, err := e.createContainer(ctx, spec, true)
+	if err != nil {
+		return nil, err
+	}
+
+	query := url.Values{"stream": {"1"}, "stdin": {"1"}, "stdout": {"1"}, "stderr": {"1"}}
+	conn, reader, err := e.hijack(ctx, "attach", "/containers/"+id+"/attach", query)
+	if err != nil {
+		e.RemoveContainer(context.Background(), id, true)
+		return nil, err
+	}
+	if err := e.doJSON(ctx, "start", http.MethodPost, "/containers/"+id+"/start", nil, nil, nil); err != nil {
+		conn.Close()
+		e.RemoveContainer(context.Background(), id, true)
+		return nil, err
+	}
+
+	// The attach stream multiplexes stdout and stderr and ends when the container exits
+	stdout, stdoutWriter := io.Pipe()
+	exited := make(chan error, 1)
+	go func() {
+		err := demuxStream(reader, stdoutWriter, stderr)
+		stdoutWriter.Close()
+		exited <- err
+	}()
+
+	return &AttachedContainer{
+		Stdin:  &halfCloseWriter{conn: conn},
+		Stdout: stdout,
+		wait: func() error {
+			err := <-exited
+			conn.Close()
+			return err
+		},
+		kill: func() error {
+			err := e.doJSON(context.Background(), "kill", http.MethodPost, "/containers/"+id+"/kill", nil, nil, nil)
+			conn.Close()
+			return err
+		},
+	}, nil
+}
+
+// halfCloseWriter closes only the sending side of a hijacked connection, signalling EOF on the container's stdin
+type halfCloseWriter struct {
+	conn net.Conn
+}
+
+func (w *halfCloseWriter) Write(p []byte) (int, error) {
+	return w.conn.Write(p)
+}
+
+func (w *halfCloseWriter) Close() error {
+	if closer, ok := w.conn.(interface{ CloseWrite() error }); ok {
+		return closer.CloseWrite()
+	}
+	return w.conn.Close()
+}
+
+// hijack sends an upgrade request and returns the raw connection for attach streams
+func (e *DockerAPIEngine) hijack(ctx context.Context, op, path string, query url.Values) (net.Conn, *bufio.Reader, error) {
+	conn, err := e.dial(ctx)
+	if err != nil {
+		return nil, nil, &EngineError{Engine: e.name, Op: op, Message: err.Error()}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://docker"+path+"?"+query.Encode(), nil)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "tcp")
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, nil, &EngineError{Engine: e.name, Op: op, Message: err.Error()}
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		conn.Close()
+		return nil, nil, &EngineError{Engine: e.name, Op: op, Message: err.Error()}
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols && resp.StatusCode != http.StatusOK {
+		defer conn.Close()
+		return nil, nil, e.responseError(op, resp)
+	}
+	return conn, reader, nil
+}
+
+// demuxStream splits a multiplexed attach or logs stream: each frame has an 8-byte header naming
+// the stream (1 stdout, 2 stderr) and the payload size
+func demuxStream(r io.Reader, stdout, stderr io.Writer) error {
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+
+		w := stdout
+		if header[0] == 2 {
+			w = stderr
+		}
+		if _, err := io.CopyN(w, r, int64(binary.BigEndian.Uint32(header[4:]))); err != nil {
+			return err
+		}
+	}
+}
+
+func (e *DockerAPIEngine) ContainerPort(ctx context.Context, id string, port int) (string, error) {
+	var inspect struct {
+		NetworkSettings struct {
+			Ports map[string][]apiPortBinding `json:"Ports"`
+		} `json:"NetworkSettings"`
+	}
+	if err := e.doJSON(ctx, "port", http.MethodGet, "/containers/"+id+"/json", nil, nil, &inspect); err != nil {
+		return "", err
+	}
+
+	bindings := inspect.NetworkSettings.Ports[fmt.Sprintf("%d/tcp", port)]
+	if len(bindings) == 0 {
+		return "", &EngineError{Engine: e.name, Op: "port", Message: fmt.Sprintf("port %d is not published", port)}
+	}
+	return net.JoinHostPort(bindings[0].HostIP, bindings[0].HostPort), nil
+}
+
+func (e *DockerAPIEngine) ListContainers(ctx context.Context, labels map[string]string) ([]models.ManagedContainer, error) {
+	filter := map[string][]string{}
+	for _, key := range sortedKeys(labels) {
+		filter["label"] = append(filter["label"], key+"="+labels[key])
+	}
+	filters, err := json.Marshal(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []struct {
+		ID     string            `json:"Id"`
+		Names  []string          `json:"Names"`
+		Image  string            `json:"Image"`
+		State  string            `json:"State"`
+		Status string            `json:"Status"`
+		Labels map[string]string `json:"Labels"`
+		Ports  []struct {
+			IP          string `json:"IP"`
+			PrivatePort int    `json:"PrivatePort"`
+			PublicPort  int    `json:"PublicPort"`
+			Type        string `json:"Type"`
+		} `json:"Ports"`
+	}
+	query := url.Values{"all": {"1"}, "filters": {string(filters)}}
+	if err := e.doJSON(ctx, "ps", http.MethodGet, "/containers/json", query, nil, &entries); err != nil {
+		return nil, err
+	}
+
+	containers := make([]models.ManagedContainer, 0, len(entries))
+	for _, entry := range entries {
+		var ports []string
+		for _, port := range entry.Ports {
+			if port.PublicPort == 0 {
+				ports = append(ports, fmt.Sprintf("%d/%s", port.PrivatePort, port.Type))
+				continue
+			}
+			ports = append(ports, fmt.Sprintf("%s->%d/%s", net.JoinHostPort(port.IP, strconv.Itoa(port.PublicPort)), port.PrivatePort, port.Type))
+		}
+		name := ""
+		if len(entry.Names) > 0 {
+			name = strings.TrimPrefix(entry.Names[0], "/")
+		}
+		containers = append(containers, managedContainer(entry.ID, name, entry.Image, entry.State, entry.Status, strings.Join(ports, ", "), entry.Labels))
+	}
+	return containers, nil
+}
+
+func (e *DockerAPIEngine) StopContainer(ctx context.Context, id string) error {
+	return e.doJSON(ctx, "stop", http.MethodPost, "/containers/"+id+"/stop", nil, nil, nil)
+}
+
+func (e *DockerAPIEngine) RestartContainer(ctx context.Context, id string) error {
+	return e.doJSON(ctx, "restart", http.MethodPost, "/containers/"+id+"/restart", nil, nil, nil)
+}
+
+func (e *DockerAPIEngine) RemoveContainer(ctx context.Context, id string, force bool) error {
+	var query url.Values
+	if force {
+		query = url.Values{"force": {"1"}}
+	}
+	return e.doJSON(ctx, "rm", http.MethodDelete, "/containers/"+id, query, nil, nil)
+}
+
+func (e *DockerAPIEngine) ContainerLogs(ctx context.Context, id string, follow bool, tail string, stdout, stderr io.Writer) error {
+	// Containers with a TTY send raw output; all others multiplex stdout and stderr
+	var inspect struct {
+		Config struct {
+			Tty bool `json:"Tty"`
+		} `json:"Config"`
+	}
+	if err := e.doJSON(ctx, "logs", http.MethodGet, "/containers/"+id+"/json", nil, nil, &inspect); err != nil {
+		return err
+	}
+
+	query := url.Values{"stdout": {"1"}, "stderr": {"1"}}
+	if follow {
+		query.Set("follow", "1")
+	}
+	if tail != "" {
+		query.Set("tail", tail)
+	}
+	resp, err := e.do(ctx, "logs", http.MethodGet, "/containers/"+id+"/logs", query, nil, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if inspect.Config.Tty {
+		_, err = io.Copy(stdout, resp.Body)
+		return err
+	}
+	return demuxStream(resp.Body, stdout, stderr)
+}
+
+func (e *DockerAPIEngine) CreateNetwork(ctx context.Context, name string, internal bool, labels map[string]string) error {
+	body := map[string]any{"Name": name, "Driver": "bridge", "Internal": internal, "Labels": labels}
+	return e.doJSON(ctx, "network create", http.MethodPost, "/networks/create", nil, body, nil)
+}
+
+func (e *DockerAPIEngine) ConnectNetwork(ctx context.Context, network, container string) error {
+	body := map[string]string{"Container": container}
+	return e.doJSON(ctx, "network connect", http.MethodPost, "/networks/"+url.PathEscape(network)+"/connect", nil, body, nil)
+}
+
+func (e *DockerAPIEngine) RemoveNetwork(ctx context.Context, name string) error {
+	return e.doJSON(ctx, "network rm", http.MethodDelete, "/networks/"+url.PathEscape(name), nil, nil, nil)
+}
+
+// do sends a request and turns error statuses into an EngineError
+func (e *DockerAPIEngine) do(ctx context.Context, op, method, path string, query url.Values, body io.Reader, contentType string) (*http.Response, error) {
+	target := "http://docker" + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, &EngineError{Engine: e.name, Op: op, Message: err.Error()}
+	}
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		return nil, e.responseError(op, resp)
+	}
+	return resp, nil
+}
+
+// doJSON sends in as a JSON body (if not nil) and decodes the response into out (if not nil)
+func (e *DockerAPIEngine) doJSON(ctx context.Context, op, method, path string, query url.Values, in, out any) error {
+	var body io.Reader
+	contentType := ""
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = strings.NewReader(string(data))
+		contentType = "application/json"
+	}
+
+	resp, err := e.do(ctx, op, method, path, query, body, contentType)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return &EngineError{Engine: e.name, Op: op, Message: fmt.Sprintf("invalid response: %v", err)}
+	}
+	return nil
+}
+
+// responseError reads the {"message": ...} body of a failed request
+func (e *DockerAPIEngine) responseError(op string, resp *http.Response) error {
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	var body struct {
+		Message string `json:"message"`
+	}
+	message := strings.TrimSpace(string(data))
+	if json.Unmarshal(data, &body) == nil && body.Message != "" {
+		message = body.Message
+	}
+	if message == "" {
+		message = resp.Status
+	}
+	return &EngineError{Engine: e.name, Op: op, Status: resp.StatusCode, Message: message}
+}
+
+// readMessages decodes a JSON message stream, returning the first error message it carries
+func (e *DockerAPIEngine) readMessages(op string, r io.Reader, handle func(*engineMessage)) error {
+	decoder := json.NewDecoder(r)
+	for {
+		var msg engineMessage
+		if err := decoder.Decode(&msg); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return &EngineError{Engine: e.name, Op: op, Message: fmt.Sprintf("invalid response: %v", err)}
+		}
+		if msg.Error != "" || msg.ErrorDetail.Message != "" {
+			message := msg.ErrorDetail.Message
+			if message == "" {
+				message = msg.Error
+			}
+			return &EngineError{Engine: e.name, Op: op, Message: strings.TrimSpace(message)}
+		}
+		handle(&msg)
+	}
+}
@@ -0,0 +1,409 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"mcphub/models"
+)
+
+// ExecEngine drives a docker-compatible command-line tool
+type ExecEngine struct {
+	binary string
+	// builder builds images instead of binary when set
+	builder string
+	podman  bool
+}
+
+// NewExecEngine creates an engine that runs binary (such as docker) for every operation
+func NewExecEngine(binary string) *ExecEngine {
+	return &ExecEngine{binary: binary}
+}
+
+// NewPodmanEngine creates an engine that runs podman, building with buildah when it is installed.
+// Both share the same image storage, so images built by buildah are visible to podman.
+func NewPodmanEngine() *ExecEngine {
+	e := &ExecEngine{binary: "podman", podman: true}
+	if _, err := exec.LookPath("buildah"); err == nil {
+		e.builder = "buildah"
+	}
+	return e
+}
+
+func (e *ExecEngine) Name() string {
+	return e.binary
+}
+
+func (e *ExecEngine) Ping(ctx context.Context) error {
+	_, err := e.output(ctx, "info", "info")
+	return err
+}
+
+func (e *ExecEngine) Platform(ctx context.Context) (string, error) {
+	format := "{{.Server.Os}}/{{.Server.Arch}}"
+	if e.podman {
+		format = "{{.Server.OsArch}}"
+	}
+	out, err := e.output(ctx, "version", "version", "--format", format)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func (e *ExecEngine) BuildImage(ctx context.Context, contextDir, tag, platform string, logs io.Writer) error {
+	if logs == nil {
+		logs = io.Discard
+	}
+	builder := e.binary
+	if e.builder != "" {
+		builder = e.builder
+	}
+	args := []string{"build", "-t", tag}
+	if platform != "" {
+		args = append(args, "--platform", platform)
+	}
+	if e.podman {
+		// Podman and Buildah default to the OCI format, which drops HEALTHCHECK
+		args = append(args, "--format", "docker")
+	}
+	args = append(args, ".")
+
+	output := &tailBuffer{limit: 4096}
+	cmd := exec.CommandContext(ctx, builder, args...)
+	cmd.Dir = contextDir
+	cmd.Stdout = io.MultiWriter(logs, output)
+	cmd.Stderr = cmd.Stdout
+	if err := cmd.Run(); err != nil {
+		return &EngineError{Engine: builder, Op: "build", Message: failureMessage(err, output.String())}
+	}
+	return nil
+}
+
+func (e *ExecEngine) SaveImage(ctx context.Context, image string, w io.Writer) error {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, e.binary, "save", image)
+	cmd.Stdout = w
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return e.error("save", err, stderr.String())
+	}
+	return nil
+}
+
+func (e *ExecEngine) LoadImage(ctx context.Context, r io.Reader) ([]string, error) {
+	return loadArchive(r, func(archive io.Reader) error {
+		cmd := exec.CommandContext(ctx, e.binary, "load")
+		cmd.Stdin = archive
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return e.error("load", err, string(out))
+		}
+		return nil
+	})
+}
+
+func (e *ExecEngine) ImageLabels(ctx context.Context, image string) (map[string]string, error) {
+	out, err := e.output(ctx, "inspect", "image", "inspect", "--format", "{{json .Config.Labels}}", image)
+	if err != nil {
+		return nil, err
+	}
+	var labels map[string]string
+	if err := json.Unmarshal(out, &labels); err != nil {
+		return nil, fmt.Errorf("failed to parse labels of image %s: %w", image, err)
+	}
+	return labels, nil
+}
+
+func (e *ExecEngine) TagImage(ctx context.Context, image, ref string) error {
+	_, err := e.output(ctx, "tag", "tag", image, ref)
+	return err
+}
+
+func (e *ExecEngine) RemoveImage(ctx context.Context, image string) error {
+	_, err := e.output(ctx, "rmi", "rmi", image)
+	return err
+}
+
+func (e *ExecEngine) RunContainer(ctx context.Context, spec *ContainerSpec) (string, error) {
+	cmd, err := e.runCommand(ctx, spec, "-d")
+	if err != nil {
+		return "", err
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", e.error("run", err, string(out))
+	}
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	return lines[len(lines)-1], nil
+}
+
+func (e *ExecEngine) AttachContainer(ctx context.Context, spec *ContainerSpec, stderr io.Writer) (*AttachedContainer, error) {
+	cmd, err := e.runCommand(ctx, spec, "-i")
+	if err != nil {
+		return nil, err
+	}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open container stdin: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open container stdout: %w", err)
+	}
+	cmd.Stderr = stderr
+	if err := cmd.Start(); err != nil {
+		return nil, e.error("run", err, "")
+	}
+
+	return &AttachedContainer{
+		Stdin:  stdin,
+		Stdout: stdout,
+		wait:   cmd.Wait,
+		kill:   cmd.Process.Kill,
+	}, nil
+}
+
+// runCommand builds "<binary> run" for spec; env values reach the engine through its environment
+func (e *ExecEngine) runCommand(ctx context.Context, spec *ContainerSpec, mode string) (*exec.Cmd, error) {
+	args := []string{"run", mode}
+	if spec.Remove {
+		args = append(args, "--rm")
+	}
+	if spec.Name != "" {
+		args = append(args, "--name", spec.Name)
+	}
+	for _, key := range sortedKeys(spec.Labels) {
+		args = append(args, "--label", key+"="+spec.Labels[key])
+	}
+	for _, port := range spec.Ports {
+		args = append(args, "-p", port)
+	}
+	if spec.Sandbox != nil {
+		args = append(args, SandboxDockerArgs(spec.Sandbox)...)
+	}
+	if _, err := containerNetwork(spec); err != nil {
+		return nil, err
+	}
+	if spec.Network != "" {
+		args = append(args, "--network", spec.Network)
+	}
+	if spec.Pull {
+		args = append(args, "--pull", "missing")
+	}
+	if len(spec.Command) > 0 {
+		args = append(args, "--entrypoint", spec.Command[0])
+	}
+	envNames := sortedKeys(spec.Env)
+	for _, name := range envNames {
+		args = append(args, "-e", name)
+	}
+	args = append(args, spec.Image)
+	if len(spec.Command) > 1 {
+		args = append(args, spec.Command[1:]...)
+	}
+
+	cmd := exec.CommandContext(ctx, e.binary, args...)
+	cmd.Env = os.Environ()
+	for _, name := range envNames {
+		cmd.Env = append(cmd.Env, name+"="+spec.Env[name])
+	}
+	return cmd, nil
+}
+
+func (e *ExecEngine) ContainerPort(ctx context.Context, id string, port int) (string, error) {
+	out, err := e.output(ctx, "port", "port", id, fmt.Sprintf("%d/tcp", port))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(strings.Split(string(out), "\n")[0]), nil
+}
+
+func (e *ExecEngine) ListContainers(ctx context.Context, labels map[string]string) ([]models.ManagedContainer, error) {
+	format := "{{json .}}"
+	if e.podman {
+		format = "json"
+	}
+	args := []string{"ps", "-a", "--no-trunc", "--format", format}
+	for _, key := range sortedKeys(labels) {
+		args = append(args, "--filter", "label="+key+"="+labels[key])
+	}
+	out, err := e.output(ctx, "ps", args...)
+	if err != nil {
+		return nil, err
+	}
+	if e.podman {
+		return parsePodmanContainerList(out)
+	}
+	return parseContainerList(out)
+}
+
+func (e *ExecEngine) StopContainer(ctx context.Context, id string) error {
+	_, err := e.output(ctx, "stop", "stop", id)
+	return err
+}
+
+func (e *ExecEngine) RestartContainer(ctx context.Context, id string) error {
+	_, err := e.output(ctx, "restart", "restart", id)
+	return err
+}
+
+func (e *ExecEngine) RemoveContainer(ctx context.Context, id string, force bool) error {
+	if force {
+		_, err := e.output(ctx, "rm", "rm", "-f", id)
+		return err
+	}
+	_, err := e.output(ctx, "rm", "rm", id)
+	return err
+}
+
+func (e *ExecEngine) ContainerLogs(ctx context.Context, id string, follow bool, tail string, stdout, stderr io.Writer) error {
+	args := []string{"logs"}
+	if follow {
+		args = append(args, "-f")
+	}
+	if tail != "" {
+		args = append(args, "--tail", tail)
+	}
+	args = append(args, id)
+
+	cmd := exec.CommandContext(ctx, e.binary, args...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return e.error("logs", err, "")
+	}
+	return nil
+}
+
+func (e *ExecEngine) CreateNetwork(ctx context.Context, name string, internal bool, labels map[string]string) error {
+	args := []string{"network", "create"}
+	if internal {
+		args = append(args, "--internal")
+	}
+	for _, key := range sortedKeys(labels) {
+		args = append(args, "--label", key+"="+labels[key])
+	}
+	_, err := e.output(ctx, "network create", append(args, name)...)
+	return err
+}
+
+func (e *ExecEngine) ConnectNetwork(ctx context.Context, network, container string) error {
+	_, err := e.output(ctx, "network connect", "network", "connect", network, container)
+	return err
+}
+
+func (e *ExecEngine) RemoveNetwork(ctx context.Context, name string) error {
+	_, err := e.output(ctx, "network rm", "network", "rm", name)
+	return err
+}
+
+// output runs the binary and returns its stdout, turning failures into an EngineError with its stderr
+func (e *ExecEngine) output(ctx context.Context, op string, args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, e.binary, args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, e.error(op, err, stderr.String())
+	}
+	return out, nil
+}
+
+func (e *ExecEngine) error(op string, err error, output string) error {
+	return &EngineError{Engine: e.binary, Op: op, Message: failureMessage(err, output)}
+}
+
+// failureMessage prefers the tool's own output over the exit status
+func failureMessage(err error, output string) string {
+	if message := strings.TrimSpace(output); message != "" {
+		return message
+	}
+	return err.Error()
+}
+
+// parseContainerList parses docker ps --format '{{json .}}' output, one JSON object per line
+func parseContainerList(out []byte) ([]models.ManagedContainer, error) {
+	var containers []models.ManagedContainer
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if line == "" {
+			continue
+		}
+
+		var entry struct {
+			ID     string `json:"ID"`
+			Names  string `json:"Names"`
+			Image  string `json:"Image"`
+			State  string `json:"State"`
+			Status string `json:"Status"`
+			Ports  string `json:"Ports"`
+			Labels string `json:"Labels"`
+		}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			return nil, fmt.Errorf("failed to parse container list: %w", err)
+		}
+
+		labels := make(map[string]string)
+		for _, pair := range strings.Split(entry.Labels, ",") {
+			if key, value, ok := strings.Cut(pair, "="); ok {
+				labels[key] = value
+			}
+		}
+		containers = append(containers, managedContainer(entry.ID, entry.Names, entry.Image, entry.State, entry.Status, entry.Ports, labels))
+	}
+	return containers, nil
+}
+
+// parsePodmanContainerList parses podman ps --format json output, a single JSON array
+func parsePodmanContainerList(out []byte) ([]models.ManagedContainer, error) {
+	var entries []struct {
+		ID     string            `json:"Id"`
+		Names  []string          `json:"Names"`
+		Image  string            `json:"Image"`
+		State  string            `json:"State"`
+		Status string            `json:"Status"`
+		Labels map[string]string `json:"Labels"`
+		Ports  []struct {
+			HostIP        string `json:"host_ip"`
+			ContainerPort int    `json:"container_port"`
+			HostPort      int    `json:"host_port"`
+			Protocol      string `json:"protocol"`
+		} `json:"Ports"`
+	}
+	if err := json.Unmarshal(out, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse container list: %w", err)
+	}
+
+	containers := make([]models.ManagedContainer, 0, len(entries))
+	for _, entry := range entries {
+		var ports []string
+		for _, port := range entry.Ports {
+			hostIP := port.HostIP
+			if hostIP == "" {
+				hostIP = "0.0.0.0"
+			}
+			ports = append(ports, fmt.Sprintf("%s:%d->%d/%s", hostIP, port.HostPort, port.ContainerPort, port.Protocol))
+		}
+		name := ""
+		if len(entry.Names) > 0 {
+			name = entry.Names[0]
+		}
+		containers = append(containers, managedContainer(entry.ID, name, entry.Image, entry.State, entry.Status, strings.Join(ports, ", "), entry.Labels))
+	}
+	return containers, nil
+}
+
+func sortedKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,276 @@
+package services
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"mcphub/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newFakeEngineAPI serves handler on a Unix socket and returns an engine connected to it
+func newFakeEngineAPI(t *testing.T, handler http.Handler) *DockerAPIEngine {
+	dir, err := os.MkdirTemp("", "engine")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	socket := filepath.Join(dir, "docker.sock")
+	listener, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+
+	server := httptest.NewUnstartedServer(handler)
+	server.Listener = listener
+	server.Start()
+	t.Cleanup(server.Close)
+
+	engine, err := NewDockerAPIEngine("unix://" + socket)
+	require.NoError(t, err)
+	return engine
+}
+
+func muxFrame(stream byte, payload string) []byte {
+	header := make([]byte, 8)
+	header[0] = stream
+	binary.BigEndian.PutUint32(header[4:], uint32(len(payload)))
+	return append(header, payload...)
+}
+
+func TestDockerAPIEngine(t *testing.T) {
+	var created apiContainerConfig
+	var createdName string
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/_ping", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	})
+	mux.HandleFunc("/images/weather/json", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"Config":{"Labels":{"name":"weather"}}}`))
+	})
+	var removed string
+	mux.HandleFunc("/images/mcphub-build/acme/", func(w http.ResponseWriter, r *http.Request) {
+		removed = r.URL.EscapedPath()
+		w.Write([]byte(`[]`))
+	})
+	mux.HandleFunc("/images/missing/json", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"message":"No such image: missing"}`))
+	})
+	mux.HandleFunc("/containers/create", func(w http.ResponseWriter, r *http.Request) {
+		createdName = r.URL.Query().Get("name")
+		json.NewDecoder(r.Body).Decode(&created)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"Id":"abc123"}`))
+	})
+	mux.HandleFunc("/containers/abc123/start", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("/containers/json", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, `{"label":["mcphub.managed=true"]}`, r.URL.Query().Get("filters"))
+		w.Write([]byte(`[{"Id":"abc123","Names":["/weather"],"Image":"weather","State":"running","Status":"Up 1 minute",
+			"Labels":{"mcphub.managed":"true","mcphub.server":"weather"},
+			"Ports":[{"IP":"127.0.0.1","PrivatePort":8080,"PublicPort":49153,"Type":"tcp"}]}]`))
+	})
+	mux.HandleFunc("/build", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "weather", r.URL.Query().Get("t"))
+		archive := tar.NewReader(r.Body)
+		header, err := archive.Next()
+		require.NoError(t, err)
+		assert.Equal(t, "Dockerfile", header.Name)
+
+		w.Write([]byte(`{"stream":"Step 1/2 : FROM python:3.11-slim\n"}` + "\n"))
+		w.Write([]byte(`{"errorDetail":{"message":"pip install failed"},"error":"pip install failed"}` + "\n"))
+	})
+	mux.HandleFunc("/images/load", func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Write([]byte(`{"stream":"Loaded image: weather:latest\n"}` + "\n"))
+	})
+	engine := newFakeEngineAPI(t, mux)
+	ctx := context.Background()
+
+	require.NoError(t, engine.Ping(ctx))
+
+	labels, err := engine.ImageLabels(ctx, "weather")
+	require.NoError(t, err)
+	assert.Equal(t, "weather", labels["name"])
+
+	_, err = engine.ImageLabels(ctx, "missing")
+	var engineErr *EngineError
+	require.True(t, errors.As(err, &engineErr))
+	assert.Equal(t, http.StatusNotFound, engineErr.Status)
+	assert.Equal(t, "No such image: missing", engineErr.Message)
+
+	// Image names are escaped component by component
+	require.NoError(t, engine.RemoveImage(ctx, "mcphub-build/acme/weather?force=1#x:abc"))
+	assert.Equal(t, "/images/mcphub-build/acme/weather%3Fforce=1%23x:abc", removed)
+
+	sandbox, err := ResolveSandbox(&models.SandboxConfig{Profile: "strict"}, nil)
+	require.NoError(t, err)
+	id, err := engine.RunContainer(ctx, &ContainerSpec{
+		Image:   "weather",
+		Name:    "weather",
+		Labels:  map[string]string{ManagedLabel: "true"},
+		Env:     map[string]string{"API_KEY": "secret"},
+		Ports:   []string{"127.0.0.1::8080"},
+		Sandbox: sandbox,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "abc123", id)
+	assert.Equal(t, "weather", createdName)
+	assert.Equal(t, []string{"API_KEY=secret"}, created.Env)
+	assert.Equal(t, []apiPortBinding{{HostIP: "127.0.0.1"}}, created.HostConfig.PortBindings["8080/tcp"])
+	assert.Equal(t, int64(512<<20), created.HostConfig.Memory)
+	assert.Equal(t, int64(1e9), created.HostConfig.NanoCpus)
+	assert.Equal(t, "none", created.HostConfig.NetworkMode)
+	assert.True(t, created.HostConfig.ReadonlyRootfs)
+	assert.Equal(t, []string{"ALL"}, created.HostConfig.CapDrop)
+
+	containers, err := ListManagedContainers(ctx, engine)
+	require.NoError(t, err)
+	require.Len(t, containers, 1)
+	assert.Equal(t, "weather", containers[0].Name)
+	assert.Equal(t, "weather", containers[0].Server)
+	assert.Equal(t, "127.0.0.1:49153->8080/tcp", containers[0].Ports)
+
+	buildDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(buildDir, "Dockerfile"), []byte("FROM python:3.11-slim\n"), 0644))
+	var logs bytes.Buffer
+	err = engine.BuildImage(ctx, buildDir, "weather", "", &logs)
+	require.True(t, errors.As(err, &engineErr))
+	assert.Equal(t, "pip install failed", engineErr.Message)
+	assert.Equal(t, "Step 1/2 : FROM python:3.11-slim\n", logs.String())
+
+	var archive bytes.Buffer
+	writer := tar.NewWriter(&archive)
+	manifest := []byte(`[{"Config":"config.json","RepoTags":["weather:latest"],"Layers":[]}]`)
+	require.NoError(t, writer.WriteHeader(&tar.Header{Name: "manifest.json", Mode: 0644, Size: int64(len(manifest))}))
+	writer.Write(manifest)
+	require.NoError(t, writer.Close())
+
+	tags, err := engine.LoadImage(ctx, &archive)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"weather:latest"}, tags)
+}
+
+func TestDockerAPIEngineAttach(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/containers/create", func(w http.ResponseWriter, r *http.Request) {
+		var config apiContainerConfig
+		json.NewDecoder(r.Body).Decode(&config)
+		assert.True(t, config.OpenStdin)
+		assert.True(t, config.HostConfig.AutoRemove)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"Id":"abc123"}`))
+	})
+	mux.HandleFunc("/containers/abc123/start", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("/containers/abc123/attach", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "tcp", r.Header.Get("Upgrade"))
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		require.NoError(t, err)
+		defer conn.Close()
+		buf.WriteString("HTTP/1.1 101 UPGRADED\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n")
+		buf.Flush()
+
+		// Echo each stdin line back on stdout and note it on stderr, until stdin closes
+		scanner := bufio.NewScanner(buf)
+		for scanner.Scan() {
+			conn.Write(muxFrame(1, scanner.Text()+"\n"))
+			conn.Write(muxFrame(2, "got line\n"))
+		}
+	})
+	engine := newFakeEngineAPI(t, mux)
+
+	var stderr bytes.Buffer
+	container, err := engine.AttachContainer(context.Background(), &ContainerSpec{Image: "weather", Remove: true}, &stderr)
+	require.NoError(t, err)
+
+	_, err = container.Stdin.Write([]byte("hello\n"))
+	require.NoError(t, err)
+	require.NoError(t, container.Stdin.Close())
+
+	output, err := io.ReadAll(container.Stdout)
+	require.NoError(t, err)
+	assert.Equal(t, "hello\n", string(output))
+	require.NoError(t, container.Wait())
+	assert.Equal(t, "got line\n", stderr.String())
+}
+
+func TestTarDirectory(t *testing.T) {
+	dir := t.TempDir()
+	writeTestTree(t, dir, map[string]string{
+		"Dockerfile":              "FROM python:3.11-slim\n",
+		".dockerignore":           "*.log\ndata\n!data/schema.json\nDockerfile\n",
+		"server.py":               "print('weather')\n",
+		"debug.log":               "trace\n",
+		"data/cities.csv":         "Paris\n",
+		"data/schema.json":        "{}\n",
+		".git/HEAD":               "ref: refs/heads/main\n",
+		"node_modules/x/index.js": "",
+	})
+
+	var buf bytes.Buffer
+	require.NoError(t, tarDirectory(dir, &buf))
+	var names []string
+	archive := tar.NewReader(&buf)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		if header.Typeflag != tar.TypeDir {
+			names = append(names, header.Name)
+		}
+	}
+	// Only .dockerignore decides, as it does for docker build
+	assert.ElementsMatch(t, []string{".dockerignore", "Dockerfile", "server.py", "data/schema.json", ".git/HEAD", "node_modules/x/index.js"}, names)
+}
+
+func TestParsePortSpec(t *testing.T) {
+	port, binding, err := parsePortSpec("8080:80")
+	require.NoError(t, err)
+	assert.Equal(t, "80/tcp", port)
+	assert.Equal(t, apiPortBinding{HostPort: "8080"}, binding)
+
+	port, binding, err = parsePortSpec("127.0.0.1::9000/udp")
+	require.NoError(t, err)
+	assert.Equal(t, "9000/udp", port)
+	assert.Equal(t, apiPortBinding{HostIP: "127.0.0.1"}, binding)
+
+	_, _, err = parsePortSpec("8080:http")
+	assert.Error(t, err)
+}
+
+func TestParsePodmanContainerList(t *testing.T) {
+	out := []byte(`[{"Id":"abc123","Names":["weather"],"Image":"localhost/weather:latest","State":"running","Status":"Up 2 minutes",
+		"Labels":{"mcphub.managed":"true","mcphub.server":"weather","mcphub.version":"1.2.0"},
+		"Ports":[{"host_ip":"127.0.0.1","container_port":8080,"host_port":49153,"range":1,"protocol":"tcp"}]}]`)
+
+	containers, err := parsePodmanContainerList(out)
+	require.NoError(t, err)
+	require.Len(t, containers, 1)
+	assert.Equal(t, "weather", containers[0].Name)
+	assert.Equal(t, "weather", containers[0].Server)
+	assert.Equal(t, "1.2.0", containers[0].Version)
+	assert.Equal(t, "127.0.0.1:49153->8080/tcp", containers[0].Ports)
+}
+
+func TestNewContainerEngine(t *testing.T) {
+	_, err := NewContainerEngine(context.Background(), "containerd")
+	assert.ErrorContains(t, err, "unknown container engine")
+}
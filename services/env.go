@@ -0,0 +1,129 @@
+package services
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"mcphub/models"
+)
+
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ValidateEnvDeclarations checks the env section of mcp.json
+func ValidateEnvDeclarations(decls []models.EnvVar) error {
+	seen := make(map[string]bool)
+	for _, decl := range decls {
+		if !envNamePattern.MatchString(decl.Name) {
+			return fmt.Errorf("invalid environment variable name %q", decl.Name)
+		}
+		if seen[decl.Name] {
+			return fmt.Errorf("environment variable %q declared more than once", decl.Name)
+		}
+		seen[decl.Name] = true
+	}
+	return nil
+}
+
+// ParseEnvAssignments parses KEY=VALUE pairs. A bare KEY takes its value from the current environment,
+// like docker run -e does.
+func ParseEnvAssignments(assignments []string) (map[string]string, error) {
+	values := make(map[string]string)
+	for _, assignment := range assignments {
+		key, value, ok := strings.Cut(assignment, "=")
+		if !envNamePattern.MatchString(key) {
+			return nil, fmt.Errorf("invalid environment variable %q. Use: KEY=VALUE", assignment)
+		}
+		if !ok {
+			value, ok = os.LookupEnv(key)
+			if !ok {
+				continue
+			}
+		}
+		values[key] = value
+	}
+	return values, nil
+}
+
+// ParseEnvFile reads KEY=VALUE lines, ignoring blank lines and # comments and stripping matching quotes
+func ParseEnvFile(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open env file: %w", err)
+	}
+	defer file.Close()
+
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || !envNamePattern.MatchString(key) {
+			return nil, fmt.Errorf("%s:%d: expected KEY=VALUE", path, lineNumber)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		values[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read env file: %w", err)
+	}
+
+	return values, nil
+}
+
+// ResolveEnv determines the value of every variable to pass to the container.
+// Declared variables are looked up in sources (highest priority first), then fall back to their default,
+// then to prompt (which may be nil). Undeclared variables found in sources are passed through unchanged.
+// An error lists every required variable that is still missing.
+func ResolveEnv(decls []models.EnvVar, sources []map[string]string, prompt func(models.EnvVar) (string, error)) (map[string]string, error) {
+	resolved := make(map[string]string)
+
+	// Lowest priority sources first so higher priority ones overwrite them
+	for i := len(sources) - 1; i >= 0; i-- {
+		for key, value := range sources[i] {
+			resolved[key] = value
+		}
+	}
+
+	var missing []string
+	for _, decl := range decls {
+		if _, ok := resolved[decl.Name]; ok {
+			continue
+		}
+		if decl.Default != "" {
+			resolved[decl.Name] = decl.Default
+			continue
+		}
+		if prompt != nil && decl.Required {
+			value, err := prompt(decl)
+			if err != nil {
+				return nil, err
+			}
+			if value != "" {
+				resolved[decl.Name] = value
+				continue
+			}
+		}
+		if decl.Required {
+			missing = append(missing, decl.Name)
+		}
+	}
+
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("missing required environment variables: %s", strings.Join(missing, ", "))
+	}
+	return resolved, nil
+}
@@ -0,0 +1,62 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"mcphub/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveEnv(t *testing.T) {
+	decls := []models.EnvVar{
+		{Name: "API_KEY", Required: true, Secret: true},
+		{Name: "REGION", Default: "eu"},
+		{Name: "DEBUG"},
+	}
+
+	t.Run("Sources override defaults by priority", func(t *testing.T) {
+		flags := map[string]string{"API_KEY": "from-flag"}
+		file := map[string]string{"API_KEY": "from-file", "REGION": "us", "EXTRA": "1"}
+
+		env, err := ResolveEnv(decls, []map[string]string{flags, file}, nil)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"API_KEY": "from-flag", "REGION": "us", "EXTRA": "1"}, env)
+	})
+
+	t.Run("Missing required values are prompted for", func(t *testing.T) {
+		var prompted []string
+		prompt := func(decl models.EnvVar) (string, error) {
+			prompted = append(prompted, decl.Name)
+			return "typed", nil
+		}
+
+		env, err := ResolveEnv(decls, nil, prompt)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"API_KEY"}, prompted)
+		assert.Equal(t, "typed", env["API_KEY"])
+		assert.Equal(t, "eu", env["REGION"])
+	})
+
+	t.Run("Refuses to start without required values", func(t *testing.T) {
+		_, err := ResolveEnv(decls, nil, nil)
+		assert.EqualError(t, err, "missing required environment variables: API_KEY")
+	})
+}
+
+func TestParseEnvFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".env")
+	content := "# comment\n\nAPI_KEY=\"abc=123\"\nexport REGION='eu'\nEMPTY=\n"
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+
+	values, err := ParseEnvFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"API_KEY": "abc=123", "REGION": "eu", "EMPTY": ""}, values)
+
+	require.NoError(t, os.WriteFile(path, []byte("not a pair\n"), 0600))
+	_, err = ParseEnvFile(path)
+	assert.Error(t, err)
+}
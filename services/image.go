@@ -0,0 +1,31 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"mcphub/models"
+)
+
+// ReadImageConfig returns the mcp.json embedded in a local image's labels.
+// Images built before the config label existed fall back to their name/version/description/author labels.
+func ReadImageConfig(ctx context.Context, engine ContainerEngine, imageName string) (*models.MCPConfig, error) {
+	labels, err := engine.ImageLabels(ctx, imageName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect image %s: %w", imageName, err)
+	}
+
+	if value, ok := labels[ConfigLabel]; ok {
+		return DecodeConfigLabel(value)
+	}
+
+	if labels["name"] == "" {
+		return nil, fmt.Errorf("image %s was not built by mcphub", imageName)
+	}
+	return &models.MCPConfig{
+		Name:        labels["name"],
+		Version:     labels["version"],
+		Description: labels["description"],
+		Author:      labels["author"],
+	}, nil
+}
@@ -0,0 +1,497 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"mcphub/models"
+)
+
+// MCPProtocolVersion is the protocol revision MCPHub requests during initialize
+const MCPProtocolVersion = "2025-06-18"
+
+// SupportedProtocolVersions lists the protocol revisions MCPHub understands, newest first
+var SupportedProtocolVersions = []string{"2025-06-18", "2025-03-26", "2024-11-05"}
+
+// shutdownTimeout bounds how long a server gets to exit once its session is closed
+const shutdownTimeout = 5 * time.Second
+
+// mcpTransport moves JSON-RPC messages between the client and a server
+type mcpTransport interface {
+	// send sends a request, which is abandoned once ctx is done, and returns a wait for its response
+	send(ctx context.Context, msg *models.JSONRPCMessage) (responseWait, error)
+	notify(ctx context.Context, msg *models.JSONRPCMessage) error
+	close() error
+}
+
+// responseWait waits until ctx is done for the response to a sent request. A response that has
+// already arrived is returned even when ctx is done.
+type responseWait func(ctx context.Context) (*models.JSONRPCMessage, error)
+
+// MCPClient speaks the Model Context Protocol to a single server
+type MCPClient struct {
+	transport mcpTransport
+	nextID    atomic.Int64
+}
+
+// NewStdioMCPClient creates a client that exchanges newline-delimited JSON-RPC over the given streams
+func NewStdioMCPClient(r io.Reader, w io.WriteCloser) *MCPClient {
+	return &MCPClient{transport: newStdioTransport(r, w, nil)}
+}
+
+// NewHTTPMCPClient creates a client for a server exposing the streamable HTTP transport at url
+func NewHTTPMCPClient(url string) *MCPClient {
+	return &MCPClient{transport: newHTTPTransport(url)}
+}
+
+// StartMCPServer launches the image with an attached stdin and returns a client speaking to it over stdio
+func StartMCPServer(ctx context.Context, engine ContainerEngine, image string) (*MCPClient, error) {
+	stderr := &tailBuffer{limit: 4096}
+	container, err := engine.AttachContainer(ctx, &ContainerSpec{Image: image, Remove: true}, stderr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start server: %w", err)
+	}
+
+	transport := newStdioTransport(container.Stdout, container.Stdin, stderr)
+	transport.process = container
+	return &MCPClient{transport: transport}, nil
+}
+
+// Call sends a request and decodes its result into result (which may be nil)
+func (c *MCPClient) Call(ctx context.Context, method string, params, result any) error {
+	id, wait, err := c.send(ctx, method, params)
+	if err != nil {
+		return err
+	}
+
+	resp, err := wait(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			// Tell the server to stop working on the abandoned request
+			c.Notify(context.Background(), "notifications/cancelled", map[string]any{
+				"requestId": id,
+				"reason":    ctx.Err().Error(),
+			})
+		}
+		return err
+	}
+	if resp.Error != nil {
+		return resp.Error
+	}
+	if result != nil && len(resp.Result) > 0 {
+		if err := json.Unmarshal(resp.Result, result); err != nil {
+			return fmt.Errorf("failed to decode %s result: %w", method, err)
+		}
+	}
+	return nil
+}
+
+// send sends a request without waiting for its response, returning the request's id
+func (c *MCPClient) send(ctx context.Context, method string, params any) (int64, responseWait, error) {
+	id := c.nextID.Add(1)
+	msg := &models.JSONRPCMessage{
+		JSONRPC: "2.0",
+		ID:      json.RawMessage(strconv.FormatInt(id, 10)),
+		Method:  method,
+	}
+	if params != nil {
+		raw, err := json.Marshal(params)
+		if err != nil {
+			return 0, nil, fmt.Errorf("failed to encode %s params: %w", method, err)
+		}
+		msg.Params = raw
+	}
+
+	wait, err := c.transport.send(ctx, msg)
+	return id, wait, err
+}
+
+// Notify sends a notification, which has no response
+func (c *MCPClient) Notify(ctx context.Context, method string, params any) error {
+	msg := &models.JSONRPCMessage{JSONRPC: "2.0", Method: method}
+	if params != nil {
+		raw, err := json.Marshal(params)
+		if err != nil {
+			return fmt.Errorf("failed to encode %s params: %w", method, err)
+		}
+		msg.Params = raw
+	}
+	return c.transport.notify(ctx, msg)
+}
+
+// Initialize performs the MCP handshake, requesting protocolVersion
+func (c *MCPClient) Initialize(ctx context.Context, protocolVersion string) (*models.InitializeResult, error) {
+	params := models.InitializeParams{
+		ProtocolVersion: protocolVersion,
+		Capabilities:    json.RawMessage("{}"),
+		ClientInfo:      models.Implementation{Name: "mcphub", Version: "1.0.0"},
+	}
+
+	var result models.InitializeResult
+	if err := c.Call(ctx, "initialize", params, &result); err != nil {
+		return nil, fmt.Errorf("initialize failed: %w", err)
+	}
+	if h, ok := c.transport.(*httpTransport); ok {
+		h.setProtocolVersion(result.ProtocolVersion)
+	}
+
+	if err := c.Notify(ctx, "notifications/initialized", nil); err != nil {
+		return nil, fmt.Errorf("failed to send initialized notification: %w", err)
+	}
+	return &result, nil
+}
+
+// Ping checks that the server is still responsive
+func (c *MCPClient) Ping(ctx context.Context) error {
+	return c.Call(ctx, "ping", nil, nil)
+}
+
+// ListTools returns every tool, following pagination cursors
+func (c *MCPClient) ListTools(ctx context.Context) ([]models.Tool, error) {
+	var tools []models.Tool
+	err := c.paginate(ctx, "tools/list", func(raw json.RawMessage) error {
+		var page struct {
+			Tools []models.Tool `json:"tools"`
+		}
+		if err := json.Unmarshal(raw, &page); err != nil {
+			return err
+		}
+		tools = append(tools, page.Tools...)
+		return nil
+	})
+	return tools, err
+}
+
+// ListResources returns every resource, following pagination cursors
+func (c *MCPClient) ListResources(ctx context.Context) ([]models.Resource, error) {
+	var resources []models.Resource
+	err := c.paginate(ctx, "resources/list", func(raw json.RawMessage) error {
+		var page struct {
+			Resources []models.Resource `json:"resources"`
+		}
+		if err := json.Unmarshal(raw, &page); err != nil {
+			return err
+		}
+		resources = append(resources, page.Resources...)
+		return nil
+	})
+	return resources, err
+}
+
+// ListPrompts returns every prompt, following pagination cursors
+func (c *MCPClient) ListPrompts(ctx context.Context) ([]models.Prompt, error) {
+	var prompts []models.Prompt
+	err := c.paginate(ctx, "prompts/list", func(raw json.RawMessage) error {
+		var page struct {
+			Prompts []models.Prompt `json:"prompts"`
+		}
+		if err := json.Unmarshal(raw, &page); err != nil {
+			return err
+		}
+		prompts = append(prompts, page.Prompts...)
+		return nil
+	})
+	return prompts, err
+}
+
+// CallTool invokes a tool; a result with IsError set is returned without an error
+func (c *MCPClient) CallTool(ctx context.Context, name string, arguments map[string]any) (*models.CallToolResult, error) {
+	if arguments == nil {
+		arguments = map[string]any{}
+	}
+
+	var result models.CallToolResult
+	params := map[string]any{"name": name, "arguments": arguments}
+	if err := c.Call(ctx, "tools/call", params, &result); err != nil {
+		return nil, fmt.Errorf("tools/call %s failed: %w", name, err)
+	}
+	return &result, nil
+}
+
+// paginate calls a list method repeatedly until the server stops returning a nextCursor
+func (c *MCPClient) paginate(ctx context.Context, method string, collect func(json.RawMessage) error) error {
+	seen := make(map[string]bool)
+	cursor := ""
+
+	for {
+		var params map[string]any
+		if cursor != "" {
+			params = map[string]any{"cursor": cursor}
+		}
+
+		var raw json.RawMessage
+		if err := c.Call(ctx, method, params, &raw); err != nil {
+			return fmt.Errorf("%s failed: %w", method, err)
+		}
+		if err := collect(raw); err != nil {
+			return fmt.Errorf("failed to decode %s result: %w", method, err)
+		}
+
+		var page struct {
+			NextCursor string `json:"nextCursor"`
+		}
+		json.Unmarshal(raw, &page)
+		if page.NextCursor == "" {
+			return nil
+		}
+		if seen[page.NextCursor] {
+			return fmt.Errorf("%s returned cursor %q twice", method, page.NextCursor)
+		}
+		seen[page.NextCursor] = true
+		cursor = page.NextCursor
+	}
+}
+
+// Close shuts down the transport and, for launched servers, waits for the process to exit
+func (c *MCPClient) Close() error {
+	return c.transport.close()
+}
+
+// InspectServer performs the handshake and collects everything the server advertises
+func InspectServer(ctx context.Context, client *MCPClient) (*models.ServerInspection, error) {
+	init, err := client.Initialize(ctx, MCPProtocolVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	inspection := &models.ServerInspection{
+		ProtocolVersion: init.ProtocolVersion,
+		ServerInfo:      init.ServerInfo,
+		Capabilities:    init.Capabilities,
+		Instructions:    init.Instructions,
+		Tools:           []models.Tool{},
+		Resources:       []models.Resource{},
+		Prompts:         []models.Prompt{},
+	}
+
+	// Only list what the server advertises, since other methods are allowed to fail
+	if init.Capabilities.Tools != nil {
+		if inspection.Tools, err = client.ListTools(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if init.Capabilities.Resources != nil {
+		if inspection.Resources, err = client.ListResources(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if init.Capabilities.Prompts != nil {
+		if inspection.Prompts, err = client.ListPrompts(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	return inspection, nil
+}
+
+// stdioTransport exchanges newline-delimited JSON-RPC messages over a pair of streams
+type stdioTransport struct {
+	writer  io.WriteCloser
+	writeMu sync.Mutex
+	stderr  *tailBuffer
+	process *AttachedContainer
+
+	mu      sync.Mutex
+	pending map[string]chan *models.JSONRPCMessage
+	readErr error
+	done    chan struct{}
+}
+
+func newStdioTransport(r io.Reader, w io.WriteCloser, stderr *tailBuffer) *stdioTransport {
+	t := &stdioTransport{
+		writer:  w,
+		stderr:  stderr,
+		pending: make(map[string]chan *models.JSONRPCMessage),
+		done:    make(chan struct{}),
+	}
+	go t.readLoop(r)
+	return t
+}
+
+func (t *stdioTransport) readLoop(r io.Reader) {
+	reader := bufio.NewReaderSize(r, 64*1024)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			t.dispatch(line)
+		}
+		if err != nil {
+			t.mu.Lock()
+			t.readErr = fmt.Errorf("server closed the connection")
+			if t.stderr != nil && t.stderr.Len() > 0 {
+				t.readErr = fmt.Errorf("server closed the connection\nServer output: %s", t.stderr.String())
+			}
+			t.mu.Unlock()
+			close(t.done)
+			return
+		}
+	}
+}
+
+// dispatch routes one incoming message to the waiting request, or answers server-initiated requests
+func (t *stdioTransport) dispatch(line []byte) {
+	var msg models.JSONRPCMessage
+	if err := json.Unmarshal(line, &msg); err != nil {
+		// Servers sometimes log to stdout; skip anything that isn't JSON-RPC
+		return
+	}
+
+	if msg.Method == "" && len(msg.ID) > 0 {
+		t.mu.Lock()
+		ch, ok := t.pending[string(msg.ID)]
+		delete(t.pending, string(msg.ID))
+		t.mu.Unlock()
+		if ok {
+			ch <- &msg
+		}
+		return
+	}
+
+	if msg.Method != "" && len(msg.ID) > 0 {
+		reply := &models.JSONRPCMessage{JSONRPC: "2.0", ID: msg.ID}
+		if msg.Method == "ping" {
+			reply.Result = json.RawMessage("{}")
+		} else {
+			reply.Error = &models.JSONRPCError{Code: models.JSONRPCMethodNotFound, Message: "method not supported by mcphub"}
+		}
+		t.write(reply)
+	}
+}
+
+func (t *stdioTransport) write(msg *models.JSONRPCMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+	_, err = t.writer.Write(data)
+	return err
+}
+
+func (t *stdioTransport) send(ctx context.Context, msg *models.JSONRPCMessage) (responseWait, error) {
+	ch := make(chan *models.JSONRPCMessage, 1)
+	key := string(msg.ID)
+
+	t.mu.Lock()
+	if t.readErr != nil {
+		err := t.readErr
+		t.mu.Unlock()
+		return nil, err
+	}
+	t.pending[key] = ch
+	t.mu.Unlock()
+
+	abandon := func() {
+		t.mu.Lock()
+		delete(t.pending, key)
+		t.mu.Unlock()
+	}
+	if err := t.write(msg); err != nil {
+		abandon()
+		return nil, fmt.Errorf("failed to send %s: %w", msg.Method, err)
+	}
+	stop := context.AfterFunc(ctx, abandon)
+
+	return func(ctx context.Context) (*models.JSONRPCMessage, error) {
+		select {
+		case resp := <-ch:
+			stop()
+			return resp, nil
+		default:
+		}
+
+		select {
+		case resp := <-ch:
+			stop()
+			return resp, nil
+		case <-t.done:
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			return nil, t.readErr
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%s: %w", msg.Method, ctx.Err())
+		}
+	}, nil
+}
+
+func (t *stdioTransport) notify(ctx context.Context, msg *models.JSONRPCMessage) error {
+	return t.write(msg)
+}
+
+func (t *stdioTransport) close() error {
+	if t.process == nil {
+		return t.writer.Close()
+	}
+
+	// Closing stdin is the stdio shutdown signal; give the server a moment before killing it
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return t.shutdown(ctx)
+}
+
+// shutdown closes stdin and waits until ctx is done for the server to exit, killing it otherwise.
+// A server that is not a launched process has exited once it closes its output.
+func (t *stdioTransport) shutdown(ctx context.Context) error {
+	t.writer.Close()
+
+	exited := t.done
+	if t.process != nil {
+		processExited := make(chan struct{})
+		go func() {
+			t.process.Wait()
+			close(processExited)
+		}()
+		exited = processExited
+	}
+
+	select {
+	case <-exited:
+		return nil
+	case <-ctx.Done():
+		if t.process != nil {
+			t.process.Kill()
+			<-exited
+		}
+		return fmt.Errorf("server did not exit within %s of stdin being closed", shutdownTimeout)
+	}
+}
+
+// tailBuffer keeps the last limit bytes written to it
+type tailBuffer struct {
+	mu    sync.Mutex
+	buf   []byte
+	limit int
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf = append(b.buf, p...)
+	if len(b.buf) > b.limit {
+		b.buf = b.buf[len(b.buf)-b.limit:]
+	}
+	return len(p), nil
+}
+
+func (b *tailBuffer) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.buf)
+}
+
+func (b *tailBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return string(bytes.TrimSpace(b.buf))
+}
@@ -0,0 +1,206 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"mcphub/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeMCPServer answers JSON-RPC requests with handle, or "method not found" when it returns nil
+type fakeMCPServer struct {
+	handle func(msg *models.JSONRPCMessage) (any, *models.JSONRPCError)
+}
+
+// connect starts the fake server on in-memory pipes and returns a stdio client talking to it
+func (s *fakeMCPServer) connect(t *testing.T) *MCPClient {
+	clientReader, serverWriter := io.Pipe()
+	serverReader, clientWriter := io.Pipe()
+
+	go func() {
+		defer serverWriter.Close()
+		scanner := bufio.NewScanner(serverReader)
+		for scanner.Scan() {
+			var msg models.JSONRPCMessage
+			if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil || len(msg.ID) == 0 {
+				continue
+			}
+
+			reply := models.JSONRPCMessage{JSONRPC: "2.0", ID: msg.ID}
+			result, rpcErr := s.handle(&msg)
+			switch {
+			case rpcErr != nil:
+				reply.Error = rpcErr
+			case result == nil:
+				reply.Error = &models.JSONRPCError{Code: models.JSONRPCMethodNotFound, Message: "method not found"}
+			default:
+				reply.Result, _ = json.Marshal(result)
+			}
+
+			data, _ := json.Marshal(reply)
+			serverWriter.Write(append(data, '\n'))
+		}
+	}()
+
+	client := NewStdioMCPClient(clientReader, clientWriter)
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// newToolServer returns a fake server exposing tools, two per tools/list page
+func newToolServer(tools []models.Tool) *fakeMCPServer {
+	return &fakeMCPServer{handle: func(msg *models.JSONRPCMessage) (any, *models.JSONRPCError) {
+		switch msg.Method {
+		case "initialize":
+			return map[string]any{
+				"protocolVersion": MCPProtocolVersion,
+				"capabilities":    map[string]any{"tools": map[string]any{}},
+				"serverInfo":      map[string]any{"name": "fake", "version": "0.1.0"},
+			}, nil
+		case "ping":
+			return map[string]any{}, nil
+		case "tools/call":
+			var params struct {
+				Name      string         `json:"name"`
+				Arguments map[string]any `json:"arguments"`
+			}
+			json.Unmarshal(msg.Params, &params)
+			if params.Name != "add" {
+				return map[string]any{"isError": true, "content": []any{map[string]any{"type": "text", "text": "unknown tool"}}}, nil
+			}
+			sum := params.Arguments["a"].(float64) + params.Arguments["b"].(float64)
+			return map[string]any{"content": []any{map[string]any{"type": "text", "text": fmt.Sprint(sum)}}}, nil
+		case "tools/list":
+			var params struct {
+				Cursor string `json:"cursor"`
+			}
+			json.Unmarshal(msg.Params, &params)
+			start := 0
+			if params.Cursor != "" {
+				if _, err := fmt.Sscanf(params.Cursor, "page-%d", &start); err != nil || start >= len(tools) {
+					return nil, &models.JSONRPCError{Code: models.JSONRPCInvalidParams, Message: "invalid cursor"}
+				}
+			}
+			end := start + 2
+			if end >= len(tools) {
+				return map[string]any{"tools": tools[start:]}, nil
+			}
+			return map[string]any{"tools": tools[start:end], "nextCursor": fmt.Sprintf("page-%d", end)}, nil
+		}
+		return nil, nil
+	}}
+}
+
+func TestMCPClient(t *testing.T) {
+	tools := []models.Tool{
+		{Name: "add", Description: "Add two numbers", InputSchema: json.RawMessage(`{"type":"object"}`)},
+		{Name: "sub", Description: "Subtract two numbers", InputSchema: json.RawMessage(`{"type":"object"}`)},
+		{Name: "mul", Description: "Multiply two numbers", InputSchema: json.RawMessage(`{"type":"object"}`)},
+	}
+
+	t.Run("Inspect follows pagination", func(t *testing.T) {
+		client := newToolServer(tools).connect(t)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		inspection, err := InspectServer(ctx, client)
+		require.NoError(t, err)
+
+		assert.Equal(t, "fake", inspection.ServerInfo.Name)
+		assert.Equal(t, MCPProtocolVersion, inspection.ProtocolVersion)
+		assert.Len(t, inspection.Tools, 3)
+		assert.Equal(t, "mul", inspection.Tools[2].Name)
+		assert.Empty(t, inspection.Resources)
+	})
+
+	t.Run("Unknown method returns JSON-RPC error", func(t *testing.T) {
+		client := newToolServer(tools).connect(t)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		err := client.Call(ctx, "does/not/exist", nil, nil)
+		var rpcErr *models.JSONRPCError
+		require.ErrorAs(t, err, &rpcErr)
+		assert.Equal(t, models.JSONRPCMethodNotFound, rpcErr.Code)
+	})
+
+	t.Run("CallTool returns content and isError", func(t *testing.T) {
+		client := newToolServer(tools).connect(t)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		result, err := client.CallTool(ctx, "add", map[string]any{"a": 2, "b": 3})
+		require.NoError(t, err)
+		assert.False(t, result.IsError)
+		assert.Equal(t, "5", result.Content[0].Text)
+
+		result, err = client.CallTool(ctx, "divide", nil)
+		require.NoError(t, err)
+		assert.True(t, result.IsError)
+	})
+
+	t.Run("HTTP transport reads event streams", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var msg models.JSONRPCMessage
+			json.NewDecoder(r.Body).Decode(&msg)
+			if len(msg.ID) == 0 {
+				w.WriteHeader(http.StatusAccepted)
+				return
+			}
+			w.Header().Set("Mcp-Session-Id", "session-1")
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprintf(w, "event: message\ndata: {\"jsonrpc\":\"2.0\",\"method\":\"notifications/message\"}\n\n")
+			fmt.Fprintf(w, "data: {\"jsonrpc\":\"2.0\",\"id\":%s,\"result\":{\"protocolVersion\":\"2025-03-26\",\"capabilities\":{},\"serverInfo\":{\"name\":\"http\",\"version\":\"1\"}}}\n\n", msg.ID)
+		}))
+		defer server.Close()
+
+		client := NewHTTPMCPClient(server.URL)
+		result, err := client.Initialize(context.Background(), MCPProtocolVersion)
+		require.NoError(t, err)
+		assert.Equal(t, "http", result.ServerInfo.Name)
+		assert.Equal(t, "2025-03-26", result.ProtocolVersion)
+	})
+}
+
+func TestParseToolArguments(t *testing.T) {
+	schema := json.RawMessage(`{"type":"object","properties":{"zip":{"type":"string"},"days":{"type":"integer"},"units":{"type":["string","null"]}}}`)
+
+	t.Run("Pairs follow schema types", func(t *testing.T) {
+		arguments, err := ParseToolArguments(schema, "", []string{"zip=007", "days=3", "units=metric", "extra=true"})
+		require.NoError(t, err)
+		assert.Equal(t, "007", arguments["zip"])
+		assert.Equal(t, float64(3), arguments["days"])
+		assert.Equal(t, "metric", arguments["units"])
+		assert.Equal(t, true, arguments["extra"])
+	})
+
+	t.Run("Pairs override JSON", func(t *testing.T) {
+		arguments, err := ParseToolArguments(schema, `{"zip":"10001","days":1}`, []string{"days=5"})
+		require.NoError(t, err)
+		assert.Equal(t, "10001", arguments["zip"])
+		assert.Equal(t, float64(5), arguments["days"])
+	})
+
+	t.Run("Invalid values are rejected", func(t *testing.T) {
+		_, err := ParseToolArguments(schema, "", []string{"days=soon"})
+		assert.Error(t, err)
+
+		_, err = ParseToolArguments(schema, "", []string{"novalue"})
+		assert.Error(t, err)
+
+		for _, jsonArgs := range []string{"null", "[1]", `"zip"`} {
+			_, err = ParseToolArguments(schema, jsonArgs, []string{"days=5"})
+			assert.ErrorContains(t, err, "expected a JSON object")
+		}
+	})
+}
@@ -0,0 +1,210 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"mcphub/models"
+)
+
+// httpTransport implements the MCP streamable HTTP transport
+type httpTransport struct {
+	url    string
+	client *http.Client
+
+	mu              sync.Mutex
+	sessionID       string
+	protocolVersion string
+}
+
+func newHTTPTransport(url string) *httpTransport {
+	return &httpTransport{url: url, client: &http.Client{}}
+}
+
+func (t *httpTransport) setProtocolVersion(version string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.protocolVersion = version
+}
+
+func (t *httpTransport) newRequest(ctx context.Context, method string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, t.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json, text/event-stream")
+
+	t.mu.Lock()
+	if t.sessionID != "" {
+		req.Header.Set("Mcp-Session-Id", t.sessionID)
+	}
+	if t.protocolVersion != "" {
+		req.Header.Set("MCP-Protocol-Version", t.protocolVersion)
+	}
+	t.mu.Unlock()
+	return req, nil
+}
+
+func (t *httpTransport) post(ctx context.Context, msg *models.JSONRPCMessage) (*http.Response, error) {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	req, err := t.newRequest(ctx, http.MethodPost, body)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send %s: %w", msg.Method, err)
+	}
+	if sessionID := resp.Header.Get("Mcp-Session-Id"); sessionID != "" {
+		t.mu.Lock()
+		t.sessionID = sessionID
+		t.mu.Unlock()
+	}
+	if resp.StatusCode >= 300 {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		resp.Body.Close()
+		return nil, &httpStatusError{Method: msg.Method, StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(data))}
+	}
+	return resp, nil
+}
+
+// httpStatusError is returned when the server answers a message with an HTTP error status
+type httpStatusError struct {
+	Method     string
+	StatusCode int
+	Body       string
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("%s returned HTTP %d: %s", e.Method, e.StatusCode, e.Body)
+}
+
+func (t *httpTransport) send(ctx context.Context, msg *models.JSONRPCMessage) (responseWait, error) {
+	resp, err := t.post(ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	// The response is read in the background so waiting for it can give up before it arrives
+	type reply struct {
+		msg *models.JSONRPCMessage
+		err error
+	}
+	replies := make(chan reply, 1)
+	go func() {
+		defer resp.Body.Close()
+		msg, err := readHTTPResponse(resp, msg)
+		replies <- reply{msg, err}
+	}()
+
+	return func(ctx context.Context) (*models.JSONRPCMessage, error) {
+		select {
+		case r := <-replies:
+			return r.msg, r.err
+		default:
+		}
+
+		select {
+		case r := <-replies:
+			return r.msg, r.err
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%s: %w", msg.Method, ctx.Err())
+		}
+	}, nil
+}
+
+// readHTTPResponse reads the response to msg from a JSON body or an event stream
+func readHTTPResponse(resp *http.Response, msg *models.JSONRPCMessage) (*models.JSONRPCMessage, error) {
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		return readSSEResponse(resp.Body, msg.ID)
+	}
+
+	var reply models.JSONRPCMessage
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		return nil, fmt.Errorf("failed to decode %s response: %w", msg.Method, err)
+	}
+	return &reply, nil
+}
+
+func (t *httpTransport) notify(ctx context.Context, msg *models.JSONRPCMessage) error {
+	resp, err := t.post(ctx, msg)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (t *httpTransport) close() error {
+	// Explicitly end the session; servers may answer 405 if they don't support it
+	_, err := t.deleteSession(context.Background())
+	return err
+}
+
+// deleteSession ends the session and returns the HTTP status the server answered with, or 0
+// when the server did not start a session
+func (t *httpTransport) deleteSession(ctx context.Context) (int, error) {
+	t.mu.Lock()
+	sessionID := t.sessionID
+	t.mu.Unlock()
+	if sessionID == "" {
+		return 0, nil
+	}
+
+	req, err := t.newRequest(ctx, http.MethodDelete, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// readSSEResponse reads server-sent events until the response matching id arrives
+func readSSEResponse(r io.Reader, id json.RawMessage) (*models.JSONRPCMessage, error) {
+	reader := bufio.NewReaderSize(r, 64*1024)
+	var data strings.Builder
+
+	// matches reports whether the buffered event is the response we are waiting for
+	matches := func() (*models.JSONRPCMessage, bool) {
+		var msg models.JSONRPCMessage
+		if json.Unmarshal([]byte(data.String()), &msg) != nil || msg.Method != "" || !bytes.Equal(msg.ID, id) {
+			return nil, false
+		}
+		return &msg, true
+	}
+
+	for {
+		line, err := reader.ReadString('\n')
+		line = strings.TrimRight(line, "\r\n")
+
+		if strings.HasPrefix(line, "data:") {
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+		if (line == "" || err != nil) && data.Len() > 0 {
+			if msg, ok := matches(); ok {
+				return msg, nil
+			}
+			data.Reset()
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("event stream ended before a response was received")
+		}
+	}
+}
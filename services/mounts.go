@@ -0,0 +1,158 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"mcphub/models"
+)
+
+var mountNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// ValidateMountDeclarations checks the mounts section of mcp.json
+func ValidateMountDeclarations(decls []models.Mount) error {
+	seen := make(map[string]bool)
+	for _, decl := range decls {
+		if !mountNamePattern.MatchString(decl.Name) {
+			return fmt.Errorf("invalid mount name %q (use lowercase letters, digits, - and _)", decl.Name)
+		}
+		if seen[decl.Name] {
+			return fmt.Errorf("mount %q declared more than once", decl.Name)
+		}
+		seen[decl.Name] = true
+
+		if !filepath.IsAbs(decl.Path) {
+			return fmt.Errorf("mount %q path %q must be absolute", decl.Name, decl.Path)
+		}
+		if decl.Access != "" && decl.Access != "ro" && decl.Access != "rw" {
+			return fmt.Errorf("mount %q access must be ro or rw", decl.Name)
+		}
+	}
+	return nil
+}
+
+// ResolveMounts turns name=/host/path[:rw] specs into volumes for the declared mounts.
+// Mounts are read-only unless the spec asks for rw and the declaration allows it.
+// Unless force is set, sensitive host paths are refused.
+func ResolveMounts(decls []models.Mount, specs []string, force bool) ([]models.VolumeMount, error) {
+	declared := make(map[string]models.Mount)
+	for _, decl := range decls {
+		declared[decl.Name] = decl
+	}
+
+	var volumes []models.VolumeMount
+	provided := make(map[string]bool)
+	for _, spec := range specs {
+		name, hostPath, ok := strings.Cut(spec, "=")
+		if !ok || hostPath == "" {
+			return nil, fmt.Errorf("invalid mount %q. Use: name=/host/path[:rw]", spec)
+		}
+		decl, ok := declared[name]
+		if !ok {
+			return nil, fmt.Errorf("server declares no mount named %q", name)
+		}
+
+		writable := false
+		switch {
+		case strings.HasSuffix(hostPath, ":rw"):
+			if decl.Access != "rw" {
+				return nil, fmt.Errorf("mount %q is declared read-only", name)
+			}
+			writable = true
+			hostPath = strings.TrimSuffix(hostPath, ":rw")
+		case strings.HasSuffix(hostPath, ":ro"):
+			hostPath = strings.TrimSuffix(hostPath, ":ro")
+		}
+
+		resolved, err := ResolveHostPath(hostPath)
+		if err != nil {
+			return nil, fmt.Errorf("mount %q: %w", name, err)
+		}
+		if !force {
+			if err := CheckHostPath(resolved); err != nil {
+				return nil, fmt.Errorf("mount %q: %w", name, err)
+			}
+		}
+
+		volumes = append(volumes, models.VolumeMount{Source: resolved, Target: decl.Path, Writable: writable})
+		provided[name] = true
+	}
+
+	var missing []string
+	for _, decl := range decls {
+		if decl.Required && !provided[decl.Name] {
+			missing = append(missing, decl.Name)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("missing required mounts: %s", strings.Join(missing, ", "))
+	}
+
+	return volumes, nil
+}
+
+// ResolveHostPath expands ~, makes the path absolute, follows symlinks and checks that it exists
+func ResolveHostPath(path string) (string, error) {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		path = filepath.Join(home, strings.TrimPrefix(path, "~"))
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	resolved, err := filepath.EvalSymlinks(abs)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("host path %s does not exist", abs)
+		}
+		return "", err
+	}
+	return resolved, nil
+}
+
+// sensitivePaths are host paths that must not be mounted, nor any directory containing them
+func sensitivePaths() []string {
+	paths := []string{
+		"/etc",
+		"/proc",
+		"/sys",
+		"/dev",
+		"/boot",
+		"/var/run/docker.sock",
+		"/run/docker.sock",
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		for _, dir := range []string{".ssh", ".gnupg", ".aws", ".kube", ".docker", ".config"} {
+			paths = append(paths, filepath.Join(home, dir))
+		}
+	}
+	return paths
+}
+
+// CheckHostPath refuses paths that are sensitive, inside a sensitive path or contain one (such as / or $HOME)
+func CheckHostPath(path string) error {
+	path = filepath.Clean(path)
+	for _, sensitive := range sensitivePaths() {
+		if pathWithin(path, sensitive) {
+			return fmt.Errorf("refusing to mount sensitive path %s (use --force-mount to override)", path)
+		}
+		if pathWithin(sensitive, path) {
+			return fmt.Errorf("refusing to mount %s because it contains %s (use --force-mount to override)", path, sensitive)
+		}
+	}
+	return nil
+}
+
+// pathWithin reports whether path is dir or inside it
+func pathWithin(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
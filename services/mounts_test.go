@@ -0,0 +1,70 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"mcphub/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveMounts(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	require.NoError(t, os.MkdirAll(filepath.Join(home, ".ssh"), 0700))
+	projects := filepath.Join(home, "projects")
+	require.NoError(t, os.MkdirAll(projects, 0755))
+
+	decls := []models.Mount{
+		{Name: "workspace", Path: "/workspace", Access: "rw", Required: true},
+		{Name: "docs", Path: "/docs"},
+	}
+
+	t.Run("Mounts are read-only unless rw is requested and allowed", func(t *testing.T) {
+		volumes, err := ResolveMounts(decls, []string{"workspace=" + projects, "docs=~/projects"}, false)
+		require.NoError(t, err)
+		assert.Equal(t, []models.VolumeMount{
+			{Source: projects, Target: "/workspace"},
+			{Source: projects, Target: "/docs"},
+		}, volumes)
+
+		volumes, err = ResolveMounts(decls, []string{"workspace=" + projects + ":rw"}, false)
+		require.NoError(t, err)
+		assert.True(t, volumes[0].Writable)
+
+		_, err = ResolveMounts(decls, []string{"workspace=" + projects, "docs=" + projects + ":rw"}, false)
+		assert.Error(t, err)
+	})
+
+	t.Run("Invalid mounts are rejected", func(t *testing.T) {
+		_, err := ResolveMounts(decls, nil, false)
+		assert.EqualError(t, err, "missing required mounts: workspace")
+
+		_, err = ResolveMounts(decls, []string{"cache=" + projects}, false)
+		assert.Error(t, err)
+
+		_, err = ResolveMounts(decls, []string{"workspace=" + filepath.Join(home, "missing")}, false)
+		assert.Error(t, err)
+	})
+
+	t.Run("Sensitive paths need force", func(t *testing.T) {
+		for _, path := range []string{"/", home, filepath.Join(home, ".ssh")} {
+			_, err := ResolveMounts(decls, []string{"workspace=" + path}, false)
+			assert.Error(t, err, path)
+		}
+
+		volumes, err := ResolveMounts(decls, []string{"workspace=" + home}, true)
+		require.NoError(t, err)
+		assert.Equal(t, home, volumes[0].Source)
+	})
+}
+
+func TestValidateMountDeclarations(t *testing.T) {
+	assert.NoError(t, ValidateMountDeclarations([]models.Mount{{Name: "data", Path: "/data", Access: "ro"}}))
+	assert.Error(t, ValidateMountDeclarations([]models.Mount{{Name: "data", Path: "data"}}))
+	assert.Error(t, ValidateMountDeclarations([]models.Mount{{Name: "data", Path: "/data", Access: "write"}}))
+	assert.Error(t, ValidateMountDeclarations([]models.Mount{{Name: "data", Path: "/a"}, {Name: "data", Path: "/b"}}))
+}
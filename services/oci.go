@@ -0,0 +1,655 @@
+package services
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// OCI and Docker media types understood when reading and writing images
+const (
+	MediaTypeOCIIndex       = "application/vnd.oci.image.index.v1+json"
+	MediaTypeOCIManifest    = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeOCIConfig      = "application/vnd.oci.image.config.v1+json"
+	MediaTypeOCILayer       = "application/vnd.oci.image.layer.v1.tar"
+	MediaTypeOCILayerGzip   = "application/vnd.oci.image.layer.v1.tar+gzip"
+	MediaTypeOCIEmpty       = "application/vnd.oci.empty.v1+json"
+	MediaTypeDockerList     = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeDockerManifest = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeDockerConfig   = "application/vnd.docker.container.image.v1+json"
+	MediaTypeDockerLayer    = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+)
+
+// Annotations naming an image inside an OCI layout
+const (
+	annotationRefName        = "org.opencontainers.image.ref.name"
+	annotationContainerdName = "io.containerd.image.name"
+)
+
+type ociDescriptor struct {
+	MediaType    string            `json:"mediaType"`
+	ArtifactType string            `json:"artifactType,omitempty"`
+	Digest       string            `json:"digest"`
+	Size         int64             `json:"size"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+	Platform     *ociPlatform      `json:"platform,omitempty"`
+}
+
+type ociPlatform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+type ociIndex struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType,omitempty"`
+	Manifests     []ociDescriptor `json:"manifests"`
+}
+
+type ociManifest struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType,omitempty"`
+	ArtifactType  string            `json:"artifactType,omitempty"`
+	Config        ociDescriptor     `json:"config"`
+	Layers        []ociDescriptor   `json:"layers"`
+	Subject       *ociDescriptor    `json:"subject,omitempty"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+type ociImageConfig struct {
+	Created      string             `json:"created,omitempty"`
+	Architecture string             `json:"architecture"`
+	OS           string             `json:"os"`
+	Variant      string             `json:"variant,omitempty"`
+	Config       ociContainerConfig `json:"config"`
+	RootFS       ociRootFS          `json:"rootfs"`
+	History      []ociHistory       `json:"history,omitempty"`
+}
+
+type ociContainerConfig struct {
+	User         string              `json:"User,omitempty"`
+	ExposedPorts map[string]struct{} `json:"ExposedPorts,omitempty"`
+	Env          []string            `json:"Env,omitempty"`
+	Entrypoint   []string            `json:"Entrypoint,omitempty"`
+	Cmd          []string            `json:"Cmd,omitempty"`
+	Volumes      map[string]struct{} `json:"Volumes,omitempty"`
+	WorkingDir   string              `json:"WorkingDir,omitempty"`
+	Labels       map[string]string   `json:"Labels,omitempty"`
+	StopSignal   string              `json:"StopSignal,omitempty"`
+}
+
+type ociRootFS struct {
+	Type    string   `json:"type"`
+	DiffIDs []string `json:"diff_ids"`
+}
+
+type ociHistory struct {
+	Created    string `json:"created,omitempty"`
+	CreatedBy  string `json:"created_by,omitempty"`
+	EmptyLayer bool   `json:"empty_layer,omitempty"`
+}
+
+// isIndexMediaType reports whether a descriptor points at a multi-platform index
+func isIndexMediaType(mediaType string) bool {
+	return mediaType == MediaTypeOCIIndex || mediaType == MediaTypeDockerList
+}
+
+// sha256Digest returns the digest of data in algorithm:hex form
+func sha256Digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// ociLayout is an OCI image layout directory
+type ociLayout struct {
+	dir string
+}
+
+// openOCILayout opens an OCI layout directory, or extracts a layout tarball into tempDir first
+func openOCILayout(path, tempDir string) (*ociLayout, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open OCI layout: %w", err)
+	}
+	if !info.IsDir() {
+		if err := extractTar(path, tempDir); err != nil {
+			return nil, fmt.Errorf("failed to extract OCI layout %s: %w", path, err)
+		}
+		path = tempDir
+	}
+	if _, err := os.Stat(filepath.Join(path, "index.json")); err != nil {
+		return nil, fmt.Errorf("%s is not an OCI image layout (no index.json)", path)
+	}
+	return &ociLayout{dir: path}, nil
+}
+
+// blobPath returns the file holding a blob
+func (l *ociLayout) blobPath(digest string) (string, error) {
+	algorithm, hash, ok := strings.Cut(digest, ":")
+	if !ok || algorithm == "" || hash == "" || strings.ContainsAny(hash, `/\.`) {
+		return "", fmt.Errorf("invalid digest %q", digest)
+	}
+	return filepath.Join(l.dir, "blobs", algorithm, hash), nil
+}
+
+func (l *ociLayout) readJSON(digest string, v any) error {
+	path, err := l.blobPath(digest)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("missing blob %s: %w", digest, err)
+	}
+	if sha256Digest(data) != digest {
+		return fmt.Errorf("blob %s does not match its digest", digest)
+	}
+	return json.Unmarshal(data, v)
+}
+
+// resolveManifest finds the image named ref (or the only image) and, for multi-platform indexes,
+// the manifest for platform
+func (l *ociLayout) resolveManifest(ref string, platform ociPlatform) (*ociManifest, error) {
+	data, err := os.ReadFile(filepath.Join(l.dir, "index.json"))
+	if err != nil {
+		return nil, err
+	}
+	var index ociIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("invalid index.json: %w", err)
+	}
+
+	descriptor, err := selectRef(index.Manifests, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	for isIndexMediaType(descriptor.MediaType) {
+		var child ociIndex
+		if err := l.readJSON(descriptor.Digest, &child); err != nil {
+			return nil, err
+		}
+		if descriptor, err = selectPlatform(child.Manifests, platform); err != nil {
+			return nil, err
+		}
+	}
+
+	var manifest ociManifest
+	if err := l.readJSON(descriptor.Digest, &manifest); err != nil {
+		return nil, err
+	}
+	return &manifest, nil
+}
+
+// selectRef picks the descriptor whose ref name is ref or its tag, or the only descriptor
+func selectRef(descriptors []ociDescriptor, ref string) (ociDescriptor, error) {
+	tag := ref
+	if i := strings.LastIndex(ref, ":"); i >= 0 && !strings.Contains(ref[i:], "/") {
+		tag = ref[i+1:]
+	}
+	for _, descriptor := range descriptors {
+		name := descriptor.Annotations[annotationRefName]
+		if name != "" && (name == ref || name == tag) {
+			return descriptor, nil
+		}
+	}
+	if len(descriptors) == 1 {
+		return descriptors[0], nil
+	}
+	return ociDescriptor{}, fmt.Errorf("OCI layout has %d images and none is named %s", len(descriptors), ref)
+}
+
+// selectPlatform picks the manifest for platform from a multi-platform index
+func selectPlatform(descriptors []ociDescriptor, platform ociPlatform) (ociDescriptor, error) {
+	for _, descriptor := range descriptors {
+		p := descriptor.Platform
+		if p != nil && p.OS == platform.OS && p.Architecture == platform.Architecture &&
+			(platform.Variant == "" || p.Variant == platform.Variant) {
+			return descriptor, nil
+		}
+	}
+	return ociDescriptor{}, fmt.Errorf("image has no manifest for %s", platform)
+}
+
+func (p ociPlatform) String() string {
+	s := p.OS + "/" + p.Architecture
+	if p.Variant != "" {
+		s += "/" + p.Variant
+	}
+	return s
+}
+
+// hostPlatform is the platform images are built for by default
+func hostPlatform() ociPlatform {
+	return ociPlatform{OS: "linux", Architecture: runtime.GOARCH}
+}
+
+// parsePlatform parses a platform written as os/arch[/variant], such as linux/arm64
+func parsePlatform(value string) (ociPlatform, error) {
+	parts := strings.Split(value, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return ociPlatform{}, fmt.Errorf("invalid platform %q. Use: os/arch[/variant], such as linux/amd64", value)
+	}
+	platform := ociPlatform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		platform.Variant = parts[2]
+	}
+	return platform, nil
+}
+
+// matches reports whether p can stand for other, ignoring a variant either one leaves out
+func (p ociPlatform) matches(other ociPlatform) bool {
+	return p.OS == other.OS && p.Architecture == other.Architecture &&
+		(p.Variant == "" || other.Variant == "" || p.Variant == other.Variant)
+}
+
+// ValidatePlatforms checks a list of platforms images are built for
+func ValidatePlatforms(platforms []string) error {
+	seen := make(map[string]bool)
+	for _, value := range platforms {
+		platform, err := parsePlatform(value)
+		if err != nil {
+			return err
+		}
+		if seen[platform.String()] {
+			return fmt.Errorf("platform %s is listed twice", platform)
+		}
+		seen[platform.String()] = true
+	}
+	return nil
+}
+
+// extractTar unpacks a tar file into dir, refusing entries that escape it
+func extractTar(path, dir string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	archive := tar.NewReader(file)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		target := filepath.Join(dir, filepath.FromSlash(header.Name))
+		if !pathWithin(target, dir) {
+			return fmt.Errorf("archive entry %s escapes the destination", header.Name)
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			out, err := os.Create(target)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(out, archive)
+			out.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// ociArchiveWriter streams an OCI image layout as a tar archive
+type ociArchiveWriter struct {
+	archive *tar.Writer
+	written map[string]bool
+}
+
+func newOCIArchiveWriter(w io.Writer) *ociArchiveWriter {
+	return &ociArchiveWriter{archive: tar.NewWriter(w), written: make(map[string]bool)}
+}
+
+func (w *ociArchiveWriter) writeFile(name string, data []byte) error {
+	return w.writeStream(name, int64(len(data)), bytes.NewReader(data))
+}
+
+func (w *ociArchiveWriter) writeStream(name string, size int64, r io.Reader) error {
+	if w.written[name] {
+		return nil
+	}
+	w.written[name] = true
+
+	header := &tar.Header{Name: name, Mode: 0644, Size: size, Typeflag: tar.TypeReg}
+	if err := w.archive.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := io.CopyN(w.archive, r, size)
+	return err
+}
+
+// writeBlob adds a blob under blobs/<algorithm>/<hex>
+func (w *ociArchiveWriter) writeBlob(digest string, size int64, r io.Reader) error {
+	return w.writeStream(blobName(digest), size, r)
+}
+
+// writeIndex adds the index.json naming the image tag and a docker-save manifest.json, so that
+// docker load and podman load both accept the archive
+func (w *ociArchiveWriter) writeIndex(descriptor ociDescriptor, manifest *ociManifest, tag string) error {
+	descriptor.Annotations = map[string]string{
+		annotationRefName:        tag,
+		annotationContainerdName: "docker.io/library/" + tag,
+	}
+	index, err := json.Marshal(ociIndex{SchemaVersion: 2, MediaType: MediaTypeOCIIndex, Manifests: []ociDescriptor{descriptor}})
+	if err != nil {
+		return err
+	}
+
+	layerNames := make([]string, len(manifest.Layers))
+	for i, layer := range manifest.Layers {
+		layerNames[i] = blobName(layer.Digest)
+	}
+	dockerManifest, err := json.Marshal([]map[string]any{{
+		"Config":   blobName(manifest.Config.Digest),
+		"RepoTags": []string{tag},
+		"Layers":   layerNames,
+	}})
+	if err != nil {
+		return err
+	}
+
+	if err := w.writeFile("index.json", index); err != nil {
+		return err
+	}
+	return w.writeFile("manifest.json", dockerManifest)
+}
+
+func (w *ociArchiveWriter) close() error {
+	return w.archive.Close()
+}
+
+// blobName is the path of a blob inside an OCI layout
+func blobName(digest string) string {
+	return "blobs/" + strings.Replace(digest, ":", "/", 1)
+}
+
+// ImagePush describes an image uploaded blob by blob
+type ImagePush struct {
+	Digest string
+	// Uploaded and Existing count the blobs sent and those the store already held
+	Uploaded int
+	Existing int
+}
+
+func (p *ImagePush) count(uploaded bool) {
+	if uploaded {
+		p.Uploaded++
+	} else {
+		p.Existing++
+	}
+}
+
+// writeImageArchive streams an image to w as an archive that docker load and podman load accept,
+// tagged tag, fetching each blob with open and checking it against its digest. It returns the
+// manifest digest.
+func writeImageArchive(w io.Writer, manifest *ociManifest, manifestData []byte, tag string, open func(digest string) (io.ReadCloser, error)) (string, error) {
+	archive := newOCIArchiveWriter(w)
+	if err := archive.writeFile("oci-layout", []byte(`{"imageLayoutVersion":"1.0.0"}`)); err != nil {
+		return "", err
+	}
+	for _, descriptor := range append([]ociDescriptor{manifest.Config}, manifest.Layers...) {
+		if err := copyVerifiedBlob(archive, descriptor, open); err != nil {
+			return "", err
+		}
+	}
+
+	digest := sha256Digest(manifestData)
+	if err := archive.writeFile(blobName(digest), manifestData); err != nil {
+		return "", err
+	}
+	mediaType := manifest.MediaType
+	if mediaType == "" {
+		mediaType = MediaTypeOCIManifest
+	}
+	descriptor := ociDescriptor{MediaType: mediaType, Digest: digest, Size: int64(len(manifestData))}
+	if err := archive.writeIndex(descriptor, manifest, tag); err != nil {
+		return "", err
+	}
+	return digest, archive.close()
+}
+
+// copyVerifiedBlob streams a blob into the archive, checking its digest as it goes
+func copyVerifiedBlob(archive *ociArchiveWriter, descriptor ociDescriptor, open func(digest string) (io.ReadCloser, error)) error {
+	body, err := open(descriptor.Digest)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	verifier, err := newDigestReader(body, descriptor.Digest)
+	if err != nil {
+		return err
+	}
+	if err := archive.writeBlob(descriptor.Digest, descriptor.Size, verifier); err != nil {
+		return fmt.Errorf("failed to download blob %s: %w", descriptor.Digest, err)
+	}
+	return verifier.verify()
+}
+
+// imageBlob is a blob of an image, held in memory or in a file
+type imageBlob struct {
+	descriptor ociDescriptor
+	path       string
+	data       []byte
+}
+
+func (b imageBlob) open() (io.ReadCloser, error) {
+	if b.data != nil {
+		return io.NopCloser(bytes.NewReader(b.data)), nil
+	}
+	return os.Open(b.path)
+}
+
+// archivedImage is an image read from a docker save or OCI layout archive
+type archivedImage struct {
+	config imageBlob
+	layers []imageBlob
+}
+
+// blobs lists the config and layers
+func (i *archivedImage) blobs() []imageBlob {
+	return append([]imageBlob{i.config}, i.layers...)
+}
+
+// platform reads the platform the image was built for from its config
+func (i *archivedImage) platform() (ociPlatform, error) {
+	body, err := i.config.open()
+	if err != nil {
+		return ociPlatform{}, err
+	}
+	defer body.Close()
+
+	var config ociImageConfig
+	if err := json.NewDecoder(body).Decode(&config); err != nil {
+		return ociPlatform{}, fmt.Errorf("invalid image config: %w", err)
+	}
+	if config.OS == "" || config.Architecture == "" {
+		return ociPlatform{}, fmt.Errorf("image config does not name its platform")
+	}
+	return ociPlatform{OS: config.OS, Architecture: config.Architecture, Variant: config.Variant}, nil
+}
+
+// manifest returns an OCI manifest of the image
+func (i *archivedImage) manifest(annotations map[string]string) ociManifest {
+	manifest := ociManifest{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeOCIManifest,
+		Config:        i.config.descriptor,
+		Layers:        []ociDescriptor{},
+		Annotations:   annotations,
+	}
+	for _, layer := range i.layers {
+		manifest.Layers = append(manifest.Layers, layer.descriptor)
+	}
+	return manifest
+}
+
+// readImageArchive extracts the archive of an image built for platform, or for the engine's own
+// when it is empty, into dir and describes its blobs with OCI media types
+func readImageArchive(archivePath, platform, dir string) (*archivedImage, error) {
+	target := hostPlatform()
+	if platform != "" {
+		var err error
+		if target, err = parsePlatform(platform); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := extractTar(archivePath, dir); err != nil {
+		return nil, fmt.Errorf("failed to extract image archive: %w", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "index.json")); err == nil {
+		return readLayoutImage(&ociLayout{dir: dir}, target)
+	}
+	return readDockerArchive(dir)
+}
+
+// readLayoutImage reads the image for platform from an OCI layout, which docker save writes with
+// an index of every platform the image was built for
+func readLayoutImage(layout *ociLayout, platform ociPlatform) (*archivedImage, error) {
+	manifest, err := layout.resolveManifest("", platform)
+	if err != nil {
+		return nil, err
+	}
+
+	var image archivedImage
+	for i, descriptor := range append([]ociDescriptor{manifest.Config}, manifest.Layers...) {
+		path, err := layout.blobPath(descriptor.Digest)
+		if err != nil {
+			return nil, err
+		}
+		blob := imageBlob{
+			descriptor: ociDescriptor{MediaType: ociMediaType(descriptor.MediaType), Digest: descriptor.Digest, Size: descriptor.Size},
+			path:       path,
+		}
+		if i == 0 {
+			image.config = blob
+		} else {
+			image.layers = append(image.layers, blob)
+		}
+	}
+	return &image, nil
+}
+
+// readDockerArchive reads the manifest.json of a docker save archive without an OCI index
+func readDockerArchive(dir string) (*archivedImage, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "manifest.json"))
+	if err != nil {
+		return nil, fmt.Errorf("image archive has neither index.json nor manifest.json")
+	}
+	var entries []struct {
+		Config string
+		Layers []string
+	}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("invalid manifest.json: %w", err)
+	}
+	if len(entries) != 1 {
+		return nil, fmt.Errorf("image archive holds %d images, expected 1", len(entries))
+	}
+
+	var image archivedImage
+	if image.config, err = archiveFileBlob(dir, entries[0].Config, MediaTypeOCIConfig); err != nil {
+		return nil, err
+	}
+	for _, name := range entries[0].Layers {
+		layer, err := archiveFileBlob(dir, name, "")
+		if err != nil {
+			return nil, err
+		}
+		image.layers = append(image.layers, layer)
+	}
+	return &image, nil
+}
+
+// archiveFileBlob describes a file of an extracted archive. Layers get the gzip media type when compressed.
+func archiveFileBlob(dir, name, mediaType string) (imageBlob, error) {
+	path := filepath.Join(dir, filepath.FromSlash(name))
+	if !pathWithin(path, dir) {
+		return imageBlob{}, fmt.Errorf("archive entry %s escapes the archive", name)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return imageBlob{}, fmt.Errorf("image archive is missing %s", name)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return imageBlob{}, err
+	}
+	if mediaType == "" {
+		mediaType = MediaTypeOCILayer
+		magic := make([]byte, 2)
+		if _, err := file.ReadAt(magic, 0); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+			mediaType = MediaTypeOCILayerGzip
+		}
+	}
+	descriptor := ociDescriptor{MediaType: mediaType, Digest: "sha256:" + hex.EncodeToString(hash.Sum(nil)), Size: size}
+	return imageBlob{descriptor: descriptor, path: path}, nil
+}
+
+// ociMediaType maps Docker schema 2 media types onto their OCI equivalents
+func ociMediaType(mediaType string) string {
+	switch mediaType {
+	case MediaTypeDockerManifest:
+		return MediaTypeOCIManifest
+	case MediaTypeDockerConfig:
+		return MediaTypeOCIConfig
+	case MediaTypeDockerLayer:
+		return MediaTypeOCILayerGzip
+	}
+	return mediaType
+}
+
+// digestReader hashes what is read through it so the content can be checked against its digest
+type digestReader struct {
+	r      io.Reader
+	hash   hash.Hash
+	digest string
+}
+
+func newDigestReader(r io.Reader, digest string) (*digestReader, error) {
+	if !strings.HasPrefix(digest, "sha256:") {
+		return nil, fmt.Errorf("unsupported digest %q", digest)
+	}
+	return &digestReader{r: r, hash: sha256.New(), digest: digest}, nil
+}
+
+func (d *digestReader) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	d.hash.Write(p[:n])
+	return n, err
+}
+
+// verify checks what has been read so far against the expected digest
+func (d *digestReader) verify() error {
+	if "sha256:"+hex.EncodeToString(d.hash.Sum(nil)) != d.digest {
+		return fmt.Errorf("blob %s does not match its digest", d.digest)
+	}
+	return nil
+}
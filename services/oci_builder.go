@@ -0,0 +1,307 @@
+package services
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"mcphub/models"
+)
+
+// OCIBuilder assembles images in Go, without a container engine: the base image's layers, one layer
+// holding the project at /app, and a config carrying the CMD and labels the Dockerfile would set.
+// RUN steps cannot execute, so dependencies must be vendored in the project or present in the base image.
+type OCIBuilder struct {
+	// BaseImage is an OCI layout directory or tarball holding the base image. When empty, the base
+	// image for the run command is read from BaseCacheDir.
+	BaseImage    string
+	BaseCacheDir string
+}
+
+// dependencyManifests are the files whose dependencies the Dockerfile installs with RUN steps
+var dependencyManifests = []string{"requirements.txt", "pyproject.toml", "Pipfile", "package.json", "yarn.lock", "go.mod"}
+
+// DefaultBaseImageCacheDir returns the directory holding cached base images as OCI layouts
+func DefaultBaseImageCacheDir() (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find user cache directory: %w", err)
+	}
+	return filepath.Join(cacheDir, "mcphub", "base-images"), nil
+}
+
+// BaseImageCachePath returns the OCI layout directory a base image reference is cached in
+func BaseImageCachePath(cacheDir, ref string) string {
+	return filepath.Join(cacheDir, strings.NewReplacer("/", "_", ":", "_").Replace(ref))
+}
+
+// UninstalledDependencies lists the dependency files in contextDir that the daemonless builder cannot install
+func UninstalledDependencies(contextDir string) []string {
+	var found []string
+	for _, name := range dependencyManifests {
+		if _, err := os.Stat(filepath.Join(contextDir, name)); err == nil {
+			found = append(found, name)
+		}
+	}
+	return found
+}
+
+// Build writes an OCI image layout tarball of the project in contextDir for platform (empty for the
+// host's), tagged imageName:latest, and returns the manifest digest. The archive also carries a
+// docker-save manifest.json so that docker load and podman load both accept it.
+func (b *OCIBuilder) Build(config *models.MCPConfig, contextDir, imageName, platform string, w io.Writer) (string, error) {
+	target := hostPlatform()
+	if platform != "" {
+		var err error
+		if target, err = parsePlatform(platform); err != nil {
+			return "", err
+		}
+	}
+
+	baseRef := NewDockerfileGenerator().getBaseImage(config.Run.Command)
+	layoutPath := b.BaseImage
+	if layoutPath == "" {
+		layoutPath = BaseImageCachePath(b.BaseCacheDir, baseRef)
+		if _, err := os.Stat(layoutPath); err != nil {
+			return "", fmt.Errorf("base image %s is not cached in %s (populate it with: skopeo copy docker://%s oci:%s:%s)",
+				baseRef, b.BaseCacheDir, baseRef, layoutPath, baseRef[strings.LastIndex(baseRef, ":")+1:])
+		}
+	}
+
+	tempDir, err := os.MkdirTemp("", "mcphub-oci-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tempDir)
+
+	layout, err := openOCILayout(layoutPath, filepath.Join(tempDir, "base"))
+	if err != nil {
+		return "", err
+	}
+	baseManifest, err := layout.resolveManifest(baseRef, target)
+	if err != nil {
+		return "", fmt.Errorf("base image %s: %w", baseRef, err)
+	}
+	var imageConfig ociImageConfig
+	if err := layout.readJSON(baseManifest.Config.Digest, &imageConfig); err != nil {
+		return "", fmt.Errorf("base image %s: %w", baseRef, err)
+	}
+	basePlatform := ociPlatform{OS: imageConfig.OS, Architecture: imageConfig.Architecture, Variant: imageConfig.Variant}
+	if imageConfig.Architecture != "" && !basePlatform.matches(target) {
+		return "", fmt.Errorf("base image %s is for %s, not %s", baseRef, basePlatform, target)
+	}
+	for _, layer := range baseManifest.Layers {
+		path, err := layout.blobPath(layer.Digest)
+		if err != nil {
+			return "", err
+		}
+		if _, err := os.Stat(path); err != nil {
+			return "", fmt.Errorf("base image %s is missing layer %s", baseRef, layer.Digest)
+		}
+	}
+
+	// Write the project layer to a file first: blobs are named by a digest known only once it is written
+	layerPath := filepath.Join(tempDir, "app-layer.tar.gz")
+	appLayer, diffID, err := writeProjectLayer(contextDir, "app", layerPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to create project layer: %w", err)
+	}
+
+	created := time.Now().UTC().Format(time.RFC3339)
+	applyImageConfig(&imageConfig, config, created)
+	imageConfig.RootFS.DiffIDs = append(imageConfig.RootFS.DiffIDs, diffID)
+	imageConfig.History = append(imageConfig.History, ociHistory{Created: created, CreatedBy: "mcphub: COPY . /app"})
+
+	configData, err := json.Marshal(imageConfig)
+	if err != nil {
+		return "", err
+	}
+	manifest := ociManifest{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeOCIManifest,
+		Config:        ociDescriptor{MediaType: MediaTypeOCIConfig, Digest: sha256Digest(configData), Size: int64(len(configData))},
+		Layers:        append(append([]ociDescriptor{}, baseManifest.Layers...), appLayer),
+	}
+	manifestData, err := json.Marshal(manifest)
+	if err != nil {
+		return "", err
+	}
+	manifestDigest := sha256Digest(manifestData)
+
+	archive := newOCIArchiveWriter(w)
+	if err := archive.writeFile("oci-layout", []byte(`{"imageLayoutVersion":"1.0.0"}`)); err != nil {
+		return "", err
+	}
+	for _, layer := range baseManifest.Layers {
+		if err := copyLayoutBlob(archive, layout, layer); err != nil {
+			return "", err
+		}
+	}
+	layerFile, err := os.Open(layerPath)
+	if err != nil {
+		return "", err
+	}
+	defer layerFile.Close()
+	if err := archive.writeBlob(appLayer.Digest, appLayer.Size, layerFile); err != nil {
+		return "", err
+	}
+	if err := archive.writeFile(blobName(manifest.Config.Digest), configData); err != nil {
+		return "", err
+	}
+	if err := archive.writeFile(blobName(manifestDigest), manifestData); err != nil {
+		return "", err
+	}
+	descriptor := ociDescriptor{MediaType: MediaTypeOCIManifest, Digest: manifestDigest, Size: int64(len(manifestData))}
+	if err := archive.writeIndex(descriptor, &manifest, imageName+":latest"); err != nil {
+		return "", err
+	}
+	if err := archive.close(); err != nil {
+		return "", err
+	}
+	return manifestDigest, nil
+}
+
+// applyImageConfig sets what the generated Dockerfile's WORKDIR, LABEL, EXPOSE and CMD would
+func applyImageConfig(image *ociImageConfig, config *models.MCPConfig, created string) {
+	image.Created = created
+	image.Config.WorkingDir = "/app"
+
+	labels := make(map[string]string)
+	for key, value := range image.Config.Labels {
+		labels[key] = value
+	}
+	labels["name"] = config.Name
+	labels["version"] = config.Version
+	labels["description"] = config.Description
+	if config.Author != "" {
+		labels["author"] = config.Author
+	}
+	labels[ConfigLabel] = EncodeConfigLabel(config)
+	image.Config.Labels = labels
+
+	if config.Run.Port > 0 {
+		ports := make(map[string]struct{})
+		for port := range image.Config.ExposedPorts {
+			ports[port] = struct{}{}
+		}
+		ports[fmt.Sprintf("%d/tcp", config.Run.Port)] = struct{}{}
+		image.Config.ExposedPorts = ports
+	}
+
+	image.Config.Cmd = append([]string{config.Run.Command}, config.Run.Args...)
+}
+
+// writeProjectLayer writes contextDir as a gzipped layer rooted at prefix and returns its descriptor
+// and diff ID (the digest of the uncompressed tar). Like the engine's COPY, it leaves out what
+// .dockerignore excludes. Timestamps and ownership are normalised so identical projects produce
+// identical layers.
+func writeProjectLayer(contextDir, prefix, path string) (ociDescriptor, string, error) {
+	ignore, err := readDockerignore(contextDir)
+	if err != nil {
+		return ociDescriptor{}, "", err
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return ociDescriptor{}, "", err
+	}
+	defer file.Close()
+
+	compressedHash := sha256.New()
+	counter := &countingWriter{w: io.MultiWriter(file, compressedHash)}
+	gz := gzip.NewWriter(counter)
+	diffHash := sha256.New()
+	archive := tar.NewWriter(io.MultiWriter(gz, diffHash))
+
+	epoch := time.Unix(0, 0)
+	err = archive.WriteHeader(&tar.Header{Name: prefix + "/", Typeflag: tar.TypeDir, Mode: 0755, ModTime: epoch})
+	if err != nil {
+		return ociDescriptor{}, "", err
+	}
+
+	err = walkBuildContext(contextDir, ignore, func(path, rel string, info os.FileInfo) error {
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = prefix + "/" + filepath.ToSlash(rel)
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		header.ModTime = epoch
+		header.AccessTime, header.ChangeTime = time.Time{}, time.Time{}
+		header.Uid, header.Gid = 0, 0
+		header.Uname, header.Gname = "", ""
+		header.Format = tar.FormatPAX
+		if err := archive.WriteHeader(header); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		src, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		_, err = io.Copy(archive, src)
+		return err
+	})
+	if err != nil {
+		return ociDescriptor{}, "", err
+	}
+	if err := archive.Close(); err != nil {
+		return ociDescriptor{}, "", err
+	}
+	if err := gz.Close(); err != nil {
+		return ociDescriptor{}, "", err
+	}
+
+	descriptor := ociDescriptor{
+		MediaType: MediaTypeOCILayerGzip,
+		Digest:    "sha256:" + hex.EncodeToString(compressedHash.Sum(nil)),
+		Size:      counter.n,
+	}
+	return descriptor, "sha256:" + hex.EncodeToString(diffHash.Sum(nil)), file.Close()
+}
+
+// copyLayoutBlob copies a blob of layout into the archive
+func copyLayoutBlob(archive *ociArchiveWriter, layout *ociLayout, descriptor ociDescriptor) error {
+	path, err := layout.blobPath(descriptor.Digest)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("missing blob %s: %w", descriptor.Digest, err)
+	}
+	defer file.Close()
+	return archive.writeBlob(descriptor.Digest, descriptor.Size, file)
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
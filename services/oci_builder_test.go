@@ -0,0 +1,190 @@
+package services
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"mcphub/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeTestBaseLayout writes a single-layer OCI layout for python:3.11-slim into dir
+// writeTestBaseLayout writes a python:3.11-slim base image for the host platform, or a
+// multi-platform one when platforms are given
+func writeTestBaseLayout(t *testing.T, dir string, platforms ...string) {
+	writeBlob := func(data []byte) string {
+		digest := sha256Digest(data)
+		path := filepath.Join(dir, blobName(digest))
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, data, 0644))
+		return digest
+	}
+	writeManifest := func(platform ociPlatform) ociDescriptor {
+		layer := []byte("base layer for " + platform.String())
+		layerDigest := writeBlob(layer)
+		config, _ := json.Marshal(ociImageConfig{
+			Architecture: platform.Architecture,
+			OS:           platform.OS,
+			Config:       ociContainerConfig{Env: []string{"PATH=/usr/local/bin:/usr/bin"}, Cmd: []string{"python3"}},
+			RootFS:       ociRootFS{Type: "layers", DiffIDs: []string{layerDigest}},
+		})
+		configDigest := writeBlob(config)
+		manifest, _ := json.Marshal(ociManifest{
+			SchemaVersion: 2,
+			MediaType:     MediaTypeOCIManifest,
+			Config:        ociDescriptor{MediaType: MediaTypeOCIConfig, Digest: configDigest, Size: int64(len(config))},
+			Layers:        []ociDescriptor{{MediaType: MediaTypeOCILayerGzip, Digest: layerDigest, Size: int64(len(layer))}},
+		})
+		return ociDescriptor{MediaType: MediaTypeOCIManifest, Digest: writeBlob(manifest), Size: int64(len(manifest)), Platform: &platform}
+	}
+
+	descriptor := writeManifest(hostPlatform())
+	descriptor.Platform = nil
+	if len(platforms) > 0 {
+		var manifests []ociDescriptor
+		for _, value := range platforms {
+			platform, err := parsePlatform(value)
+			require.NoError(t, err)
+			manifests = append(manifests, writeManifest(platform))
+		}
+		index, _ := json.Marshal(ociIndex{SchemaVersion: 2, MediaType: MediaTypeOCIIndex, Manifests: manifests})
+		descriptor = ociDescriptor{MediaType: MediaTypeOCIIndex, Digest: writeBlob(index), Size: int64(len(index))}
+	}
+	descriptor.Annotations = map[string]string{annotationRefName: "3.11-slim"}
+	index, _ := json.Marshal(ociIndex{SchemaVersion: 2, Manifests: []ociDescriptor{descriptor}})
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "index.json"), index, 0644))
+}
+
+// buildTestArchive builds a server with the daemonless builder and returns the path of its image archive
+func buildTestArchive(t *testing.T, cacheDir string, config *models.MCPConfig, source string) string {
+	project := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(project, "server.py"), []byte(source), 0644))
+
+	archivePath := filepath.Join(t.TempDir(), "image.tar")
+	file, err := os.Create(archivePath)
+	require.NoError(t, err)
+	defer file.Close()
+	_, err = (&OCIBuilder{BaseCacheDir: cacheDir}).Build(config, project, strings.ToLower(config.Name), "", file)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+	return archivePath
+}
+
+func readTar(t *testing.T, r io.Reader) map[string][]byte {
+	files := make(map[string][]byte)
+	archive := tar.NewReader(r)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return files
+		}
+		require.NoError(t, err)
+		data, err := io.ReadAll(archive)
+		require.NoError(t, err)
+		files[header.Name] = data
+	}
+}
+
+func TestOCIBuilder(t *testing.T) {
+	cacheDir := t.TempDir()
+	writeTestBaseLayout(t, BaseImageCachePath(cacheDir, "python:3.11-slim"))
+
+	project := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(project, "server.py"), []byte("print('hi')\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(project, "requirements.txt"), []byte("mcp\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(project, "debug.log"), []byte("trace\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(project, ".dockerignore"), []byte("*.log"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(project, "Dockerfile"), []byte("FROM python:3.11-slim\n"), 0644))
+	require.NoError(t, ignoreGeneratedDockerfile(project))
+
+	config := &models.MCPConfig{Name: "Weather", Version: "1.0.0", Author: "alice", Run: models.RunConfig{Command: "python", Args: []string{"server.py"}, Port: 8080}}
+	builder := &OCIBuilder{BaseCacheDir: cacheDir}
+
+	var out bytes.Buffer
+	digest, err := builder.Build(config, project, "weather", "", &out)
+	require.NoError(t, err)
+	files := readTar(t, bytes.NewReader(out.Bytes()))
+
+	var index ociIndex
+	require.NoError(t, json.Unmarshal(files["index.json"], &index))
+	require.Len(t, index.Manifests, 1)
+	assert.Equal(t, digest, index.Manifests[0].Digest)
+	assert.Equal(t, "weather:latest", index.Manifests[0].Annotations[annotationRefName])
+	assert.Contains(t, files, "oci-layout")
+
+	manifestData := files[blobName(digest)]
+	assert.Equal(t, digest, sha256Digest(manifestData))
+	var manifest ociManifest
+	require.NoError(t, json.Unmarshal(manifestData, &manifest))
+	require.Len(t, manifest.Layers, 2)
+	for _, layer := range manifest.Layers {
+		assert.Equal(t, layer.Digest, sha256Digest(files[blobName(layer.Digest)]))
+	}
+
+	var image ociImageConfig
+	require.NoError(t, json.Unmarshal(files[blobName(manifest.Config.Digest)], &image))
+	assert.Equal(t, []string{"python", "server.py"}, image.Config.Cmd)
+	assert.Equal(t, "/app", image.Config.WorkingDir)
+	assert.Equal(t, []string{"PATH=/usr/local/bin:/usr/bin"}, image.Config.Env)
+	assert.Contains(t, image.Config.ExposedPorts, "8080/tcp")
+	assert.Equal(t, "alice", image.Config.Labels["author"])
+	decoded, err := DecodeConfigLabel(image.Config.Labels[ConfigLabel])
+	require.NoError(t, err)
+	assert.Equal(t, "Weather", decoded.Name)
+	require.Len(t, image.RootFS.DiffIDs, 2)
+
+	// The project layer holds the files under /app and its diff ID is the digest of the uncompressed tar
+	gz, err := gzip.NewReader(bytes.NewReader(files[blobName(manifest.Layers[1].Digest)]))
+	require.NoError(t, err)
+	layerTar, err := io.ReadAll(gz)
+	require.NoError(t, err)
+	assert.Equal(t, image.RootFS.DiffIDs[1], sha256Digest(layerTar))
+	layerFiles := readTar(t, bytes.NewReader(layerTar))
+	assert.Equal(t, "print('hi')\n", string(layerFiles["app/server.py"]))
+	// Like the engine's COPY, the layer leaves out what .dockerignore excludes, and the generated Dockerfile
+	assert.NotContains(t, layerFiles, "app/debug.log")
+	assert.NotContains(t, layerFiles, "app/Dockerfile")
+	assert.Contains(t, layerFiles, "app/requirements.txt")
+
+	// docker load and podman load find the tag in manifest.json
+	assert.Equal(t, []string{"weather:latest"}, archiveRepoTags(bytes.NewReader(out.Bytes())))
+	assert.Equal(t, []string{"requirements.txt"}, UninstalledDependencies(project))
+
+	// Identical projects produce identical layers
+	var again bytes.Buffer
+	_, err = builder.Build(config, project, "weather", "", &again)
+	require.NoError(t, err)
+	_, found := readTar(t, &again)[blobName(manifest.Layers[1].Digest)]
+	assert.True(t, found)
+
+	_, err = (&OCIBuilder{BaseCacheDir: t.TempDir()}).Build(config, project, "weather", "", io.Discard)
+	assert.ErrorContains(t, err, "is not cached")
+}
+
+func TestReadLayoutImage(t *testing.T) {
+	dir := t.TempDir()
+	writeTestBaseLayout(t, dir, "linux/amd64", "linux/arm64")
+
+	// Each platform's image is read from a multi-platform layout, whatever the host's platform
+	for _, value := range []string{"linux/amd64", "linux/arm64"} {
+		platform, err := parsePlatform(value)
+		require.NoError(t, err)
+		image, err := readLayoutImage(&ociLayout{dir: dir}, platform)
+		require.NoError(t, err)
+		imagePlatform, err := image.platform()
+		require.NoError(t, err)
+		assert.Equal(t, value, imagePlatform.String())
+	}
+
+	_, err := readLayoutImage(&ociLayout{dir: dir}, ociPlatform{OS: "linux", Architecture: "s390x"})
+	assert.ErrorContains(t, err, "no manifest for linux/s390x")
+}
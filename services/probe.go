@@ -0,0 +1,100 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"mcphub/models"
+)
+
+// probeTimeout bounds how long a freshly built server gets to start and answer the handshake
+const probeTimeout = 60 * time.Second
+
+// ServerLauncher opens MCP connections to a built image
+type ServerLauncher struct {
+	engine      ContainerEngine
+	image       string
+	url         string
+	containerID string
+}
+
+// LaunchServer prepares connections to imageName. HTTP servers are started once in the background
+// and reached over streamable HTTP at /mcp; stdio servers get a fresh container per connection.
+func LaunchServer(ctx context.Context, engine ContainerEngine, config *models.MCPConfig, imageName string) (*ServerLauncher, error) {
+	launcher := &ServerLauncher{engine: engine, image: imageName}
+	if !config.Run.UsesHTTP() {
+		return launcher, nil
+	}
+
+	spec := &ContainerSpec{Image: imageName, Ports: []string{fmt.Sprintf("127.0.0.1::%d", config.Run.Port)}}
+	containerID, err := engine.RunContainer(ctx, spec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start container: %w", err)
+	}
+	launcher.containerID = containerID
+
+	hostAddr, err := engine.ContainerPort(ctx, containerID, config.Run.Port)
+	if err != nil {
+		launcher.Stop()
+		return nil, fmt.Errorf("failed to find published port: %w", err)
+	}
+	launcher.url = fmt.Sprintf("http://%s/mcp", hostAddr)
+
+	// Poll until the server answers the handshake
+	for {
+		client := NewHTTPMCPClient(launcher.url)
+		_, err := client.Initialize(ctx, MCPProtocolVersion)
+		client.Close()
+		if err == nil {
+			return launcher, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			var logs bytes.Buffer
+			engine.ContainerLogs(context.Background(), launcher.containerID, false, "20", &logs, &logs)
+			launcher.Stop()
+			return nil, fmt.Errorf("server did not answer at %s: %w\nServer output: %s", launcher.url, err, strings.TrimSpace(logs.String()))
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// Connect opens a new MCP connection to the server
+func (l *ServerLauncher) Connect() (*MCPClient, error) {
+	if l.url != "" {
+		return NewHTTPMCPClient(l.url), nil
+	}
+	return StartMCPServer(context.Background(), l.engine, l.image)
+}
+
+// Stop removes the background container, if one was started
+func (l *ServerLauncher) Stop() {
+	if l.containerID != "" {
+		l.engine.RemoveContainer(context.Background(), l.containerID, true)
+		l.containerID = ""
+	}
+}
+
+// ProbeServer starts the built image and records what it offers over MCP
+func ProbeServer(ctx context.Context, engine ContainerEngine, config *models.MCPConfig, imageName string) (*models.ServerInspection, error) {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	launcher, err := LaunchServer(ctx, engine, config, imageName)
+	if err != nil {
+		return nil, err
+	}
+	defer launcher.Stop()
+
+	client, err := launcher.Connect()
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	return InspectServer(ctx, client)
+}
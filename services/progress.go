@@ -0,0 +1,123 @@
+package services
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Progress draws a progress bar for a transfer with bytes done, rate and ETA. On a terminal the bar
+// is redrawn in place; otherwise a line is printed every few seconds. A nil Progress draws nothing.
+type Progress struct {
+	w           io.Writer
+	label       string
+	total       int64
+	interactive bool
+	start       time.Time
+
+	mu       sync.Mutex
+	done     int64
+	lastDraw time.Time
+}
+
+// NewProgress starts a progress bar for total bytes
+func NewProgress(w io.Writer, label string, total int64) *Progress {
+	interactive := false
+	if file, ok := w.(*os.File); ok {
+		if info, err := file.Stat(); err == nil {
+			interactive = info.Mode()&os.ModeCharDevice != 0
+		}
+	}
+	return &Progress{w: w, label: label, total: total, interactive: interactive, start: time.Now()}
+}
+
+// Add records n more bytes transferred. It is safe for concurrent use.
+func (p *Progress) Add(n int64) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.done += n
+	interval := 5 * time.Second
+	if p.interactive {
+		interval = 100 * time.Millisecond
+	}
+	if now := time.Now(); now.Sub(p.lastDraw) >= interval {
+		p.lastDraw = now
+		p.draw(false)
+	}
+}
+
+// Finish draws the final state of the bar
+func (p *Progress) Finish() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.draw(true)
+}
+
+func (p *Progress) draw(final bool) {
+	line := p.line(time.Since(p.start))
+	switch {
+	case p.interactive && final:
+		fmt.Fprintf(p.w, "\r%s\n", line)
+	case p.interactive:
+		fmt.Fprintf(p.w, "\r%s", line)
+	default:
+		fmt.Fprintln(p.w, line)
+	}
+}
+
+// line renders the bar, e.g. "Uploading [=====>    ] 45.2 MB / 100.0 MB  12.3 MB/s  ETA 5s"
+func (p *Progress) line(elapsed time.Duration) string {
+	const width = 24
+	fraction := 1.0
+	if p.total > 0 {
+		fraction = min(float64(p.done)/float64(p.total), 1)
+	}
+	filled := int(fraction * width)
+	bar := strings.Repeat("=", filled)
+	if filled < width {
+		bar += ">" + strings.Repeat(" ", width-filled-1)
+	}
+
+	rate := 0.0
+	if elapsed > 0 {
+		rate = float64(p.done) / elapsed.Seconds()
+	}
+	eta := "--"
+	if p.done >= p.total {
+		eta = "done in " + elapsed.Round(time.Second).String()
+	} else if rate > 0 {
+		eta = "ETA " + time.Duration(float64(p.total-p.done)/rate*float64(time.Second)).Round(time.Second).String()
+	}
+
+	return fmt.Sprintf("%s [%s] %s / %s  %s/s  %s", p.label, bar, FormatBytes(p.done), FormatBytes(p.total), FormatBytes(int64(rate)), eta)
+}
+
+// progressWriter adds the bytes written through it to a progress bar
+type progressWriter struct {
+	w        io.Writer
+	progress *Progress
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.progress.Add(int64(n))
+	return n, err
+}
+
+// progressReader adds the bytes read from r to a progress bar
+func progressReader(r io.ReadCloser, progress *Progress) io.ReadCloser {
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.TeeReader(r, &progressWriter{w: io.Discard, progress: progress}), r}
+}
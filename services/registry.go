@@ -0,0 +1,733 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"mcphub/models"
+)
+
+// MediaTypeServerMetadata is the artifact type of the referrer holding a pushed server's metadata
+const MediaTypeServerMetadata = "application/vnd.mcphub.server.metadata.v1+json"
+
+// manifestAccept lists the manifest media types pull understands
+var manifestAccept = strings.Join([]string{MediaTypeOCIManifest, MediaTypeOCIIndex, MediaTypeDockerManifest, MediaTypeDockerList}, ", ")
+
+var (
+	repositoryInvalidChars = regexp.MustCompile(`[^a-z0-9._-]+`)
+	validTag               = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$`)
+)
+
+// RegistryError is a failed registry request
+type RegistryError struct {
+	Op string
+	// Status is the HTTP status, or 0 when the registry could not be reached
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *RegistryError) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("registry %s failed: %s: %s", e.Op, e.Code, e.Message)
+	}
+	return fmt.Sprintf("registry %s failed: %s", e.Op, e.Message)
+}
+
+// isNotFound reports whether err is a registry 404
+func isNotFound(err error) bool {
+	var registryErr *RegistryError
+	return errors.As(err, &registryErr) && registryErr.Status == http.StatusNotFound
+}
+
+// RegistryClient pushes and pulls images through an OCI distribution registry
+type RegistryClient struct {
+	host     string
+	baseURL  string
+	client   *http.Client
+	username string
+	password string
+
+	mu    sync.Mutex
+	token string
+	basic bool
+
+	// Platform selects the image pulled from multi-platform images, as os/arch[/variant]; empty
+	// means the host's
+	Platform string
+}
+
+// NewRegistryClient connects to the registry at host[:port]. HTTPS is used unless the host is
+// prefixed with http:// or is a loopback address. Credentials are read from MCPHUB_REGISTRY_USERNAME
+// and MCPHUB_REGISTRY_PASSWORD.
+func NewRegistryClient(host string) (*RegistryClient, error) {
+	scheme := "https"
+	switch {
+	case strings.HasPrefix(host, "http://"):
+		scheme, host = "http", strings.TrimPrefix(host, "http://")
+	case strings.HasPrefix(host, "https://"):
+		host = strings.TrimPrefix(host, "https://")
+	default:
+		hostname := host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			hostname = h
+		}
+		if ip := net.ParseIP(hostname); hostname == "localhost" || (ip != nil && ip.IsLoopback()) {
+			scheme = "http"
+		}
+	}
+	host = strings.TrimSuffix(host, "/")
+	if host == "" || strings.Contains(host, "/") {
+		return nil, fmt.Errorf("invalid registry %q. Use: host[:port]", host)
+	}
+
+	return &RegistryClient{
+		host:     host,
+		baseURL:  scheme + "://" + host,
+		client:   &http.Client{},
+		username: os.Getenv("MCPHUB_REGISTRY_USERNAME"),
+		password: os.Getenv("MCPHUB_REGISTRY_PASSWORD"),
+	}, nil
+}
+
+// Host returns the registry's host[:port]
+func (r *RegistryClient) Host() string {
+	return r.host
+}
+
+// RegistryRepository returns the repository a server is pushed to: author/name, lower-cased and
+// with characters the distribution spec does not allow replaced
+func RegistryRepository(author, name string) string {
+	clean := func(s string) string {
+		return strings.Trim(repositoryInvalidChars.ReplaceAllString(strings.ToLower(s), "-"), "._-")
+	}
+	if author = clean(author); author == "" {
+		return clean(name)
+	}
+	return author + "/" + clean(name)
+}
+
+// RegistryTags returns the tags an image is pushed under: its version, when that is a valid tag, and latest
+func RegistryTags(version string) []string {
+	if validTag.MatchString(version) && version != "latest" {
+		return []string{version, "latest"}
+	}
+	return []string{"latest"}
+}
+
+// PushImage uploads the image in a docker save or OCI layout archive to repository under tags,
+// annotated with its mcp.json, and attaches metadata as a referrer artifact. Blobs the registry
+// already holds are not uploaded again. Images built for the platforms mcp.json declares, even a
+// single one, are pushed as an image index listing each platform's manifest.
+func (r *RegistryClient) PushImage(ctx context.Context, repository string, tags []string, images []models.PlatformImage, metadata *models.ServerMetadata) (*ImagePush, error) {
+	dir, err := os.MkdirTemp("", "mcphub-registry-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	push := &ImagePush{}
+	annotations := map[string]string{
+		ConfigLabel:                        EncodeConfigLabel(&metadata.Config),
+		"org.opencontainers.image.title":   metadata.Config.Name,
+		"org.opencontainers.image.version": metadata.Config.Version,
+		"org.opencontainers.image.created": metadata.PushedAt.Format(time.RFC3339),
+	}
+	mediaType := MediaTypeOCIManifest
+	var data []byte
+	var manifests []ociDescriptor
+	index := len(metadata.Config.Platforms) > 0 || len(images) > 1
+	for i, archive := range images {
+		image, err := readImageArchive(archive.TarFilePath, archive.Platform, filepath.Join(dir, strconv.Itoa(i)))
+		if err != nil {
+			return nil, err
+		}
+		for _, blob := range image.blobs() {
+			uploaded, err := r.pushBlob(ctx, repository, blob)
+			if err != nil {
+				return nil, err
+			}
+			push.count(uploaded)
+		}
+		if data, err = json.Marshal(image.manifest(annotations)); err != nil {
+			return nil, err
+		}
+		if !index {
+			break
+		}
+
+		platform, err := image.platform()
+		if err != nil {
+			return nil, err
+		}
+		if slices.ContainsFunc(manifests, func(d ociDescriptor) bool { return d.Platform.matches(platform) }) {
+			return nil, fmt.Errorf("more than one image is for platform %s", platform)
+		}
+		digest := sha256Digest(data)
+		if _, err := r.putManifest(ctx, repository, digest, MediaTypeOCIManifest, data); err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, ociDescriptor{MediaType: MediaTypeOCIManifest, Digest: digest, Size: int64(len(data)), Platform: &platform})
+	}
+	if len(manifests) > 0 {
+		mediaType = MediaTypeOCIIndex
+		if data, err = json.Marshal(ociIndex{SchemaVersion: 2, MediaType: MediaTypeOCIIndex, Manifests: manifests}); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, tag := range tags {
+		if _, err := r.putManifest(ctx, repository, tag, mediaType, data); err != nil {
+			return nil, err
+		}
+	}
+	push.Digest = sha256Digest(data)
+
+	subject := ociDescriptor{MediaType: mediaType, Digest: push.Digest, Size: int64(len(data))}
+	if err := r.pushMetadata(ctx, repository, subject, metadata); err != nil {
+		return nil, fmt.Errorf("failed to attach metadata: %w", err)
+	}
+	return push, nil
+}
+
+// pushMetadata attaches metadata to subject as an artifact manifest
+func (r *RegistryClient) pushMetadata(ctx context.Context, repository string, subject ociDescriptor, metadata *models.ServerMetadata) error {
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	empty := []byte("{}")
+	config := imageBlob{descriptor: ociDescriptor{MediaType: MediaTypeOCIEmpty, Digest: sha256Digest(empty), Size: int64(len(empty))}, data: empty}
+	layer := imageBlob{descriptor: ociDescriptor{MediaType: MediaTypeServerMetadata, Digest: sha256Digest(data), Size: int64(len(data))}, data: data}
+	for _, blob := range []imageBlob{config, layer} {
+		if _, err := r.pushBlob(ctx, repository, blob); err != nil {
+			return err
+		}
+	}
+
+	artifact := ociManifest{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeOCIManifest,
+		ArtifactType:  MediaTypeServerMetadata,
+		Config:        config.descriptor,
+		Layers:        []ociDescriptor{layer.descriptor},
+		Subject:       &subject,
+		Annotations:   map[string]string{"org.opencontainers.image.created": metadata.PushedAt.Format(time.RFC3339)},
+	}
+	manifestData, err := json.Marshal(artifact)
+	if err != nil {
+		return err
+	}
+	digest := sha256Digest(manifestData)
+	subjectIndexed, err := r.putManifest(ctx, repository, digest, MediaTypeOCIManifest, manifestData)
+	if err != nil || subjectIndexed {
+		return err
+	}
+
+	// Registries without the referrers API list referrers in an index tagged after the subject digest
+	return r.addReferrerTag(ctx, repository, subject.Digest, ociDescriptor{
+		MediaType:    MediaTypeOCIManifest,
+		ArtifactType: MediaTypeServerMetadata,
+		Digest:       digest,
+		Size:         int64(len(manifestData)),
+		Annotations:  artifact.Annotations,
+	})
+}
+
+// referrersTag is the tag of the referrers index of digest on registries without the referrers API
+func referrersTag(digest string) string {
+	return strings.Replace(digest, ":", "-", 1)
+}
+
+func (r *RegistryClient) addReferrerTag(ctx context.Context, repository, subject string, referrer ociDescriptor) error {
+	index := ociIndex{SchemaVersion: 2, MediaType: MediaTypeOCIIndex}
+	data, _, err := r.getManifest(ctx, repository, referrersTag(subject), MediaTypeOCIIndex)
+	if err == nil {
+		if err := json.Unmarshal(data, &index); err != nil {
+			return fmt.Errorf("invalid referrers index: %w", err)
+		}
+	} else if !isNotFound(err) {
+		return err
+	}
+
+	for _, existing := range index.Manifests {
+		if existing.Digest == referrer.Digest {
+			return nil
+		}
+	}
+	index.Manifests = append(index.Manifests, referrer)
+	if data, err = json.Marshal(index); err != nil {
+		return err
+	}
+	_, err = r.putManifest(ctx, repository, referrersTag(subject), MediaTypeOCIIndex, data)
+	return err
+}
+
+// referrers lists the artifacts of artifactType attached to the manifest digest
+func (r *RegistryClient) referrers(ctx context.Context, repository, digest, artifactType string) ([]ociDescriptor, error) {
+	var index ociIndex
+	resp, err := r.do(ctx, &registryRequest{
+		op:     "referrers",
+		method: http.MethodGet,
+		url:    r.url(repository, "referrers", digest) + "?artifactType=" + url.QueryEscape(artifactType),
+		header: http.Header{"Accept": {MediaTypeOCIIndex}},
+	})
+	switch {
+	case err == nil:
+		defer resp.Body.Close()
+		if err := json.NewDecoder(resp.Body).Decode(&index); err != nil {
+			return nil, fmt.Errorf("invalid referrers response: %w", err)
+		}
+	case isNotFound(err):
+		data, _, err := r.getManifest(ctx, repository, referrersTag(digest), MediaTypeOCIIndex)
+		if isNotFound(err) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &index); err != nil {
+			return nil, fmt.Errorf("invalid referrers index: %w", err)
+		}
+	default:
+		return nil, err
+	}
+
+	// Registries may ignore the artifactType filter
+	var matches []ociDescriptor
+	for _, descriptor := range index.Manifests {
+		if descriptor.ArtifactType == artifactType {
+			matches = append(matches, descriptor)
+		}
+	}
+	return matches, nil
+}
+
+// GetMetadata returns the metadata attached to an image, falling back to the mcp.json annotation
+// for images pushed without it
+func (r *RegistryClient) GetMetadata(ctx context.Context, repository, reference string) (*models.ServerMetadata, error) {
+	manifest, _, digest, err := r.resolveImage(ctx, repository, reference)
+	if err != nil {
+		return nil, err
+	}
+
+	referrers, err := r.referrers(ctx, repository, digest, MediaTypeServerMetadata)
+	if err != nil {
+		return nil, err
+	}
+	if len(referrers) > 0 {
+		data, _, err := r.getManifest(ctx, repository, referrers[len(referrers)-1].Digest, MediaTypeOCIManifest)
+		if err != nil {
+			return nil, err
+		}
+		var artifact ociManifest
+		if err := json.Unmarshal(data, &artifact); err != nil {
+			return nil, fmt.Errorf("invalid metadata artifact: %w", err)
+		}
+		if len(artifact.Layers) > 0 {
+			data, err := r.readBlob(ctx, repository, artifact.Layers[0])
+			if err != nil {
+				return nil, err
+			}
+			var metadata models.ServerMetadata
+			if err := json.Unmarshal(data, &metadata); err != nil {
+				return nil, fmt.Errorf("invalid metadata: %w", err)
+			}
+			return &metadata, nil
+		}
+	}
+
+	if value := manifest.Annotations[ConfigLabel]; value != "" {
+		config, err := DecodeConfigLabel(value)
+		if err != nil {
+			return nil, err
+		}
+		return &models.ServerMetadata{Config: *config}, nil
+	}
+	return nil, fmt.Errorf("%s:%s has no MCPHub metadata", repository, reference)
+}
+
+// PullImage writes the image repository:reference to w as an archive that docker load and podman
+// load accept, tagged tag, and returns its manifest digest. Every blob is checked against its digest.
+func (r *RegistryClient) PullImage(ctx context.Context, repository, reference, tag string, w io.Writer) (string, error) {
+	manifest, manifestData, _, err := r.resolveImage(ctx, repository, reference)
+	if err != nil {
+		return "", err
+	}
+	return writeImageArchive(w, manifest, manifestData, tag, func(digest string) (io.ReadCloser, error) {
+		return r.openBlob(ctx, repository, digest)
+	})
+}
+
+// PullToCache stores the image repository:reference in the cache, downloading only the blobs it
+// does not hold yet. The image is loaded as tag.
+func (r *RegistryClient) PullToCache(ctx context.Context, cache *ArtifactCache, repository, reference, tag string) (*CachedImage, error) {
+	_, manifestData, _, err := r.resolveImage(ctx, repository, reference)
+	if err != nil {
+		return nil, err
+	}
+	ref := fmt.Sprintf("%s/%s:%s", r.host, repository, reference)
+	return cache.storeImage(ref, tag, manifestData, func(descriptor ociDescriptor, path string) error {
+		body, err := r.openBlob(ctx, repository, descriptor.Digest)
+		if err != nil {
+			return err
+		}
+		defer body.Close()
+
+		file, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		if _, err := io.Copy(file, body); err != nil {
+			return fmt.Errorf("failed to download blob %s: %w", descriptor.Digest, err)
+		}
+		return file.Close()
+	})
+}
+
+// resolveImage fetches the manifest reference names, choosing the one for Platform from an index
+func (r *RegistryClient) resolveImage(ctx context.Context, repository, reference string) (*ociManifest, []byte, string, error) {
+	data, mediaType, err := r.getManifest(ctx, repository, reference, manifestAccept)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if isIndexMediaType(mediaType) {
+		var index ociIndex
+		if err := json.Unmarshal(data, &index); err != nil {
+			return nil, nil, "", fmt.Errorf("invalid image index: %w", err)
+		}
+		platform := hostPlatform()
+		if r.Platform != "" {
+			if platform, err = parsePlatform(r.Platform); err != nil {
+				return nil, nil, "", err
+			}
+		}
+		descriptor, err := selectPlatform(index.Manifests, platform)
+		if err != nil {
+			return nil, nil, "", err
+		}
+		if data, _, err = r.getManifest(ctx, repository, descriptor.Digest, manifestAccept); err != nil {
+			return nil, nil, "", err
+		}
+	}
+
+	var manifest ociManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, nil, "", fmt.Errorf("invalid image manifest: %w", err)
+	}
+	return &manifest, data, sha256Digest(data), nil
+}
+
+// getManifest fetches a manifest and its media type, verifying it when reference is a digest
+func (r *RegistryClient) getManifest(ctx context.Context, repository, reference, accept string) ([]byte, string, error) {
+	resp, err := r.do(ctx, &registryRequest{
+		op:     "manifest fetch",
+		method: http.MethodGet,
+		url:    r.url(repository, "manifests", reference),
+		header: http.Header{"Accept": {accept}},
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if err != nil {
+		return nil, "", &RegistryError{Op: "manifest fetch", Message: err.Error()}
+	}
+	if strings.Contains(reference, ":") && sha256Digest(data) != reference {
+		return nil, "", fmt.Errorf("manifest %s does not match its digest", reference)
+	}
+
+	mediaType, _, _ := strings.Cut(resp.Header.Get("Content-Type"), ";")
+	var body struct {
+		MediaType string `json:"mediaType"`
+	}
+	if json.Unmarshal(data, &body) == nil && body.MediaType != "" {
+		mediaType = body.MediaType
+	}
+	return data, mediaType, nil
+}
+
+// putManifest stores a manifest under reference and reports whether the registry indexed its subject
+func (r *RegistryClient) putManifest(ctx context.Context, repository, reference, mediaType string, data []byte) (bool, error) {
+	resp, err := r.do(ctx, &registryRequest{
+		op:     "manifest upload",
+		method: http.MethodPut,
+		url:    r.url(repository, "manifests", reference),
+		header: http.Header{"Content-Type": {mediaType}},
+		body:   bytesBody(data),
+		size:   int64(len(data)),
+	})
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	return resp.Header.Get("OCI-Subject") != "", nil
+}
+
+// pushBlob uploads a blob unless the registry already has it, and reports whether it was uploaded
+func (r *RegistryClient) pushBlob(ctx context.Context, repository string, blob imageBlob) (bool, error) {
+	resp, err := r.do(ctx, &registryRequest{op: "blob check", method: http.MethodHead, url: r.url(repository, "blobs", blob.descriptor.Digest)})
+	if err == nil {
+		resp.Body.Close()
+		return false, nil
+	}
+	if !isNotFound(err) {
+		return false, err
+	}
+
+	resp, err = r.do(ctx, &registryRequest{op: "blob upload", method: http.MethodPost, url: r.baseURL + "/v2/" + repository + "/blobs/uploads/"})
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	location, err := resp.Location()
+	if err != nil {
+		return false, &RegistryError{Op: "blob upload", Status: resp.StatusCode, Message: "no upload location returned"}
+	}
+	query := location.Query()
+	query.Set("digest", blob.descriptor.Digest)
+	location.RawQuery = query.Encode()
+
+	resp, err = r.do(ctx, &registryRequest{
+		op:     "blob upload",
+		method: http.MethodPut,
+		url:    location.String(),
+		header: http.Header{"Content-Type": {"application/octet-stream"}},
+		body:   blob.open,
+		size:   blob.descriptor.Size,
+	})
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	return true, nil
+}
+
+// openBlob streams a blob; callers check its digest
+func (r *RegistryClient) openBlob(ctx context.Context, repository, digest string) (io.ReadCloser, error) {
+	resp, err := r.do(ctx, &registryRequest{op: "blob fetch", method: http.MethodGet, url: r.url(repository, "blobs", digest)})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// readBlob fetches a small blob and checks its digest
+func (r *RegistryClient) readBlob(ctx context.Context, repository string, descriptor ociDescriptor) ([]byte, error) {
+	body, err := r.openBlob(ctx, repository, descriptor.Digest)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(body, 16<<20))
+	if err != nil {
+		return nil, &RegistryError{Op: "blob fetch", Message: err.Error()}
+	}
+	if sha256Digest(data) != descriptor.Digest {
+		return nil, fmt.Errorf("blob %s does not match its digest", descriptor.Digest)
+	}
+	return data, nil
+}
+
+func (r *RegistryClient) url(repository, kind, reference string) string {
+	return fmt.Sprintf("%s/v2/%s/%s/%s", r.baseURL, repository, kind, reference)
+}
+
+// registryRequest is a request do can resend after authenticating
+type registryRequest struct {
+	op     string
+	method string
+	url    string
+	header http.Header
+	// body opens the request body afresh for each attempt
+	body func() (io.ReadCloser, error)
+	size int64
+}
+
+func bytesBody(data []byte) func() (io.ReadCloser, error) {
+	return func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+}
+
+// do sends a request, answering one authentication challenge, and turns error statuses into a RegistryError
+func (r *RegistryClient) do(ctx context.Context, request *registryRequest) (*http.Response, error) {
+	for authenticated := false; ; authenticated = true {
+		req, err := http.NewRequestWithContext(ctx, request.method, request.url, nil)
+		if err != nil {
+			return nil, err
+		}
+		for key, values := range request.header {
+			req.Header[key] = values
+		}
+		if request.body != nil {
+			body, err := request.body()
+			if err != nil {
+				return nil, err
+			}
+			req.Body, req.ContentLength = body, request.size
+		}
+		r.authorize(req)
+
+		resp, err := r.client.Do(req)
+		if err != nil {
+			return nil, &RegistryError{Op: request.op, Message: err.Error()}
+		}
+		if resp.StatusCode == http.StatusUnauthorized && !authenticated {
+			challenge := resp.Header.Get("WWW-Authenticate")
+			resp.Body.Close()
+			if err := r.authenticate(ctx, challenge); err != nil {
+				return nil, &RegistryError{Op: request.op, Status: http.StatusUnauthorized, Message: err.Error()}
+			}
+			continue
+		}
+		if resp.StatusCode >= 400 {
+			defer resp.Body.Close()
+			return nil, registryResponseError(request.op, resp)
+		}
+		return resp, nil
+	}
+}
+
+// registryResponseError reads the {"errors": [...]} body of a failed request
+func registryResponseError(op string, resp *http.Response) error {
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	var body struct {
+		Errors []struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	registryErr := &RegistryError{Op: op, Status: resp.StatusCode, Message: strings.TrimSpace(string(data))}
+	if json.Unmarshal(data, &body) == nil && len(body.Errors) > 0 {
+		registryErr.Code, registryErr.Message = body.Errors[0].Code, body.Errors[0].Message
+	}
+	if registryErr.Message == "" {
+		registryErr.Message = resp.Status
+	}
+	return registryErr
+}
+
+func (r *RegistryClient) authorize(req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.token != "" {
+		req.Header.Set("Authorization", "Bearer "+r.token)
+	} else if r.basic {
+		req.SetBasicAuth(r.username, r.password)
+	}
+}
+
+// authenticate answers a WWW-Authenticate challenge: Basic uses the configured credentials,
+// Bearer exchanges them (or nothing, for anonymous access) for a token
+func (r *RegistryClient) authenticate(ctx context.Context, challenge string) error {
+	scheme, params := parseChallenge(challenge)
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if r.username == "" {
+			return fmt.Errorf("registry requires credentials; set MCPHUB_REGISTRY_USERNAME and MCPHUB_REGISTRY_PASSWORD")
+		}
+		r.mu.Lock()
+		r.basic = true
+		r.mu.Unlock()
+		return nil
+	case "bearer":
+	default:
+		return fmt.Errorf("unsupported authentication challenge %q", challenge)
+	}
+
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return fmt.Errorf("invalid token realm %q", params["realm"])
+	}
+	query := realm.Query()
+	for _, key := range []string{"service", "scope"} {
+		if params[key] != "" {
+			query.Set(key, params[key])
+		}
+	}
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return err
+	}
+	if r.username != "" {
+		req.SetBasicAuth(r.username, r.password)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("token request failed: %s", resp.Status)
+	}
+
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return fmt.Errorf("invalid token response: %w", err)
+	}
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+	if token.Token == "" {
+		return fmt.Errorf("token response carried no token")
+	}
+
+	r.mu.Lock()
+	r.token = token.Token
+	r.mu.Unlock()
+	return nil
+}
+
+// parseChallenge splits a WWW-Authenticate header into its scheme and parameters
+func parseChallenge(header string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(header), " ")
+	params := make(map[string]string)
+	for rest = strings.TrimSpace(rest); rest != ""; {
+		key, value, ok := strings.Cut(rest, "=")
+		if !ok {
+			break
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		if strings.HasPrefix(value, `"`) {
+			// Quoted values may contain commas, as scopes do
+			end := 1
+			for end < len(value) && value[end] != '"' {
+				if value[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			params[key] = strings.ReplaceAll(value[1:min(end, len(value))], `\`, "")
+			rest = value[min(end+1, len(value)):]
+		} else {
+			params[key], rest, _ = strings.Cut(value, ",")
+		}
+		rest = strings.TrimLeft(strings.TrimSpace(rest), ", ")
+	}
+	return scheme, params
+}
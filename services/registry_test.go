@@ -0,0 +1,231 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"mcphub/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRegistry is an in-memory stand-in for a distribution registry that requires a bearer token
+type fakeRegistry struct {
+	mu        sync.Mutex
+	blobs     map[string][]byte
+	manifests map[string][]byte
+	types     map[string]string
+	referrers map[string][]ociDescriptor
+	uploads   int
+
+	// referrersAPI makes the registry index subjects itself, as registries implementing the referrers API do
+	referrersAPI bool
+}
+
+func newFakeRegistry(t *testing.T, referrersAPI bool) (*fakeRegistry, *httptest.Server) {
+	registry := &fakeRegistry{
+		blobs:        make(map[string][]byte),
+		manifests:    make(map[string][]byte),
+		types:        make(map[string]string),
+		referrers:    make(map[string][]ociDescriptor),
+		referrersAPI: referrersAPI,
+	}
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			username, password, _ := r.BasicAuth()
+			assert.Equal(t, "alice", username)
+			assert.Equal(t, "s3cret", password)
+			assert.Contains(t, r.URL.Query().Get("scope"), "pull,push")
+			w.Write([]byte(`{"token":"valid-token"}`))
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer valid-token" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="fake",scope="repository:alice/weather:pull,push"`, server.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		registry.serve(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return registry, server
+}
+
+func (f *fakeRegistry) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, "/v2/")
+	notFound := func(code string) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, `{"errors":[{"code":%q,"message":"not found"}]}`, code)
+	}
+
+	switch {
+	case strings.Contains(path, "/blobs/uploads/"):
+		repository, _, _ := strings.Cut(path, "/blobs/uploads/")
+		if r.Method == http.MethodPost {
+			w.Header().Set("Location", "/v2/"+repository+"/blobs/uploads/upload-1?state=abc")
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		digest := r.URL.Query().Get("digest")
+		if sha256Digest(data) != digest || r.URL.Query().Get("state") != "abc" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"errors":[{"code":"DIGEST_INVALID","message":"digest mismatch"}]}`))
+			return
+		}
+		f.blobs[digest] = data
+		f.uploads++
+		w.WriteHeader(http.StatusCreated)
+	case strings.Contains(path, "/blobs/"):
+		_, digest, _ := strings.Cut(path, "/blobs/")
+		data, ok := f.blobs[digest]
+		if !ok {
+			notFound("BLOB_UNKNOWN")
+			return
+		}
+		w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+		if r.Method == http.MethodGet {
+			w.Write(data)
+		}
+	case strings.Contains(path, "/manifests/"):
+		repository, reference, _ := strings.Cut(path, "/manifests/")
+		if r.Method == http.MethodPut {
+			data, _ := io.ReadAll(r.Body)
+			digest := sha256Digest(data)
+			for _, ref := range []string{reference, digest} {
+				f.manifests[repository+":"+ref] = data
+				f.types[repository+":"+ref] = r.Header.Get("Content-Type")
+			}
+			var manifest ociManifest
+			json.Unmarshal(data, &manifest)
+			if manifest.Subject != nil && f.referrersAPI {
+				f.referrers[manifest.Subject.Digest] = append(f.referrers[manifest.Subject.Digest], ociDescriptor{
+					MediaType: MediaTypeOCIManifest, ArtifactType: manifest.ArtifactType, Digest: digest, Size: int64(len(data)),
+				})
+				w.Header().Set("OCI-Subject", manifest.Subject.Digest)
+			}
+			w.Header().Set("Docker-Content-Digest", digest)
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		data, ok := f.manifests[repository+":"+reference]
+		if !ok {
+			notFound("MANIFEST_UNKNOWN")
+			return
+		}
+		w.Header().Set("Content-Type", f.types[repository+":"+reference])
+		w.Write(data)
+	case strings.Contains(path, "/referrers/") && f.referrersAPI:
+		_, digest, _ := strings.Cut(path, "/referrers/")
+		json.NewEncoder(w).Encode(ociIndex{SchemaVersion: 2, MediaType: MediaTypeOCIIndex, Manifests: f.referrers[digest]})
+	default:
+		notFound("NAME_UNKNOWN")
+	}
+}
+
+func TestRegistryPushPull(t *testing.T) {
+	t.Setenv("MCPHUB_REGISTRY_USERNAME", "alice")
+	t.Setenv("MCPHUB_REGISTRY_PASSWORD", "s3cret")
+
+	cacheDir := t.TempDir()
+	writeTestBaseLayout(t, BaseImageCachePath(cacheDir, "python:3.11-slim"))
+	config := &models.MCPConfig{Name: "Weather", Version: "1.0.0", Author: "alice", Run: models.RunConfig{Command: "python", Args: []string{"server.py"}}}
+	archivePath := buildTestArchive(t, cacheDir, config, "print('hi')\n")
+
+	metadata := &models.ServerMetadata{
+		Config:     *config,
+		Inspection: &models.ServerInspection{Tools: []models.Tool{{Name: "forecast"}}},
+		PushedAt:   time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+
+	for _, referrersAPI := range []bool{true, false} {
+		t.Run(fmt.Sprintf("referrersAPI=%v", referrersAPI), func(t *testing.T) {
+			registry, server := newFakeRegistry(t, referrersAPI)
+			client, err := NewRegistryClient(server.URL)
+			require.NoError(t, err)
+			ctx := context.Background()
+			repository := RegistryRepository(config.Author, config.Name)
+
+			push, err := client.PushImage(ctx, repository, RegistryTags(config.Version), []models.PlatformImage{{TarFilePath: archivePath}}, metadata)
+			require.NoError(t, err)
+			assert.Equal(t, 3, push.Uploaded)
+			assert.Equal(t, 0, push.Existing)
+
+			// A second push finds every blob already stored
+			uploads := registry.uploads
+			again, err := client.PushImage(ctx, repository, []string{"latest"}, []models.PlatformImage{{TarFilePath: archivePath}}, metadata)
+			require.NoError(t, err)
+			assert.Equal(t, 0, again.Uploaded)
+			assert.Equal(t, 3, again.Existing)
+			assert.Equal(t, uploads, registry.uploads)
+			assert.Equal(t, push.Digest, again.Digest)
+
+			var manifest ociManifest
+			require.NoError(t, json.Unmarshal(registry.manifests[repository+":1.0.0"], &manifest))
+			annotated, err := DecodeConfigLabel(manifest.Annotations[ConfigLabel])
+			require.NoError(t, err)
+			assert.Equal(t, "Weather", annotated.Name)
+			_, tagged := registry.manifests[repository+":"+referrersTag(push.Digest)]
+			assert.Equal(t, !referrersAPI, tagged)
+
+			got, err := client.GetMetadata(ctx, repository, "1.0.0")
+			require.NoError(t, err)
+			assert.Equal(t, "forecast", got.Inspection.Tools[0].Name)
+
+			var out bytes.Buffer
+			digest, err := client.PullImage(ctx, repository, "latest", "weather:latest", &out)
+			require.NoError(t, err)
+			assert.Equal(t, push.Digest, digest)
+			assert.Equal(t, []string{"weather:latest"}, archiveRepoTags(bytes.NewReader(out.Bytes())))
+			files := readTar(t, bytes.NewReader(out.Bytes()))
+			for _, layer := range manifest.Layers {
+				assert.Equal(t, registry.blobs[layer.Digest], files[blobName(layer.Digest)])
+			}
+
+			cache := newTestCache(t)
+			image, err := client.PullToCache(ctx, cache, repository, "latest", "weather:latest")
+			require.NoError(t, err)
+			assert.Equal(t, push.Digest, image.Digest)
+			assert.Equal(t, 3, image.Downloaded)
+
+			// Corrupted blobs are refused
+			registry.blobs[manifest.Layers[1].Digest] = bytes.Repeat([]byte("x"), int(manifest.Layers[1].Size))
+			_, err = client.PullImage(ctx, repository, "latest", "weather:latest", io.Discard)
+			assert.ErrorContains(t, err, "does not match its digest")
+			_, err = client.PullToCache(ctx, newTestCache(t), repository, "latest", "weather:latest")
+			assert.ErrorContains(t, err, "does not match its digest")
+		})
+	}
+}
+
+func TestRegistryNames(t *testing.T) {
+	assert.Equal(t, "alice-smith/weather_v2", RegistryRepository("Alice Smith", "Weather_V2"))
+	assert.Equal(t, "weather", RegistryRepository("", "Weather"))
+	assert.Equal(t, []string{"1.2.0", "latest"}, RegistryTags("1.2.0"))
+	assert.Equal(t, []string{"latest"}, RegistryTags("1.2.0+build/5"))
+
+	scheme, params := parseChallenge(`Bearer realm="https://auth.example.com/token",service="registry",scope="repository:a/b:pull,push"`)
+	assert.Equal(t, "Bearer", scheme)
+	assert.Equal(t, "https://auth.example.com/token", params["realm"])
+	assert.Equal(t, "repository:a/b:pull,push", params["scope"])
+
+	client, err := NewRegistryClient("localhost:5000")
+	require.NoError(t, err)
+	assert.Equal(t, "http://localhost:5000", client.baseURL)
+	client, err = NewRegistryClient("ghcr.io")
+	require.NoError(t, err)
+	assert.Equal(t, "https://ghcr.io", client.baseURL)
+}
@@ -1,25 +1,45 @@
 package services
 
 import (
+	"bytes"
 	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 
+	"mcphub/models"
+
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 )
 
 type S3Service struct {
 	client *s3.Client
 	bucket string
+
+	// PartSize is the multipart upload part size; blobs larger than one part are uploaded in parts
+	PartSize int64
+	// Concurrency is the number of parts uploaded or downloaded at once
+	Concurrency int
+	// Progress, when set, receives a progress bar while images upload or download
+	Progress io.Writer
+	// Platform selects the image pulled from multi-platform pushes, as os/arch[/variant]; empty
+	// means the host's
+	Platform string
 }
 
-func NewS3Service() (*S3Service, error) {
-	cfg, err := config.LoadDefaultConfig(context.TODO())
+func NewS3Service(ctx context.Context) (*S3Service, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("unable to load SDK config: %v", err)
 	}
@@ -31,87 +51,470 @@ func NewS3Service() (*S3Service, error) {
 	}, nil
 }
 
-// PushMCP uploads a tar file to S3
-func (s *S3Service) PushMCP(author, imageName, tarPath string) error {
-	objectKey := fmt.Sprintf("%s/%s.tar", author, imageName)
+// Objects in the bucket:
+//
+//	blobs/sha256/<hex>        image layers and configs, stored once and shared by every server
+//	<author>/<name>.manifest  OCI image manifest listing the blobs of a server's image, or for
+//	                          multi-platform images an OCI index of manifests stored as blobs
+//	<author>/<name>.json      server metadata, searched without pulling
+//	<author>/<name>.tar       whole image archives pushed by earlier versions, still pulled
 
-	file, err := os.Open(tarPath)
+// PushMCP splits image tars into content-addressed blobs, uploads those the bucket does not already
+// hold (in parts, resuming interrupted uploads), then uploads the image manifest. Images built for
+// the platforms mcp.json declares, even a single one, have their manifests stored as blobs and
+// listed by platform in an image index, which pull picks the image for its engine's platform from.
+func (s *S3Service) PushMCP(ctx context.Context, author, imageName string, platforms []string, images ...models.PlatformImage) (*ImagePush, error) {
+	dir, err := os.MkdirTemp("", "mcphub-s3-*")
 	if err != nil {
-		return fmt.Errorf("error opening tar file: %v", err)
+		return nil, err
 	}
-	defer file.Close()
+	defer os.RemoveAll(dir)
+
+	push := &ImagePush{}
+	annotations := map[string]string{annotationRefName: strings.ToLower(imageName) + ":latest"}
+	var data []byte
+	var manifests []ociDescriptor
+	index := len(platforms) > 0 || len(images) > 1
+	for i, archive := range images {
+		image, err := readImageArchive(archive.TarFilePath, archive.Platform, filepath.Join(dir, strconv.Itoa(i)))
+		if err != nil {
+			return nil, err
+		}
+		if err := s.pushBlobs(ctx, image, push); err != nil {
+			return nil, err
+		}
+		if data, err = json.Marshal(image.manifest(annotations)); err != nil {
+			return nil, err
+		}
+		if !index {
+			break
+		}
 
-	_, err = s.client.PutObject(context.TODO(), &s3.PutObjectInput{
+		platform, err := image.platform()
+		if err != nil {
+			return nil, err
+		}
+		if slices.ContainsFunc(manifests, func(d ociDescriptor) bool { return d.Platform.matches(platform) }) {
+			return nil, fmt.Errorf("more than one image is for platform %s", platform)
+		}
+		digest := sha256Digest(data)
+		_, err = s.client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket:      aws.String(s.bucket),
+			Key:         aws.String(blobName(digest)),
+			Body:        bytes.NewReader(data),
+			ContentType: aws.String(MediaTypeOCIManifest),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error uploading manifest for %s to S3: %v", platform, err)
+		}
+		manifests = append(manifests, ociDescriptor{MediaType: MediaTypeOCIManifest, Digest: digest, Size: int64(len(data)), Platform: &platform})
+	}
+
+	contentType := MediaTypeOCIManifest
+	if len(manifests) > 0 {
+		contentType = MediaTypeOCIIndex
+		if data, err = json.Marshal(ociIndex{SchemaVersion: 2, MediaType: MediaTypeOCIIndex, Manifests: manifests}); err != nil {
+			return nil, err
+		}
+	}
+	_, err = s.client.PutObject(ctx, &s3.PutObjectInput{
 		Bucket:      aws.String(s.bucket),
-		Key:         aws.String(objectKey),
-		Body:        file,
-		ContentType: aws.String("application/gzip"),
+		Key:         aws.String(fmt.Sprintf("%s/%s.manifest", author, imageName)),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String(contentType),
 	})
+	if err != nil {
+		return nil, fmt.Errorf("error uploading manifest to S3: %v", err)
+	}
+	push.Digest = sha256Digest(data)
 
+	// The manifest supersedes any whole-image archive pushed before
+	_, err = s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(fmt.Sprintf("%s/%s.tar", author, imageName)),
+	})
 	if err != nil {
-		return fmt.Errorf("error uploading to S3: %v", err)
+		return nil, fmt.Errorf("error removing superseded image tar from S3: %v", err)
 	}
 
-	// Clean up the local tar file after successful upload
-	if err := os.Remove(tarPath); err != nil {
-		return fmt.Errorf("error removing local tar file: %v", err)
+	return push, nil
+}
+
+// pushBlobs uploads the blobs of an image the bucket does not already hold, counting them in push
+func (s *S3Service) pushBlobs(ctx context.Context, image *archivedImage, push *ImagePush) error {
+	var missing []imageBlob
+	var missingBytes int64
+	for _, blob := range image.blobs() {
+		exists, err := s.blobExists(ctx, blob.descriptor.Digest)
+		if err != nil {
+			return err
+		}
+		push.count(!exists)
+		if !exists {
+			missing = append(missing, blob)
+			missingBytes += blob.descriptor.Size
+		}
 	}
 
+	progress := s.newProgress("⬆️  Uploading", missingBytes)
+	for _, blob := range missing {
+		if err := s.uploadBlob(ctx, blobName(blob.descriptor.Digest), blob, progress); err != nil {
+			return err
+		}
+	}
+	progress.Finish()
 	return nil
 }
 
-// PullMCP downloads a tar file from S3
-func (s *S3Service) PullMCP(author, imageName string) error {
-	objectKey := fmt.Sprintf("%s/%s.tar", author, imageName)
+// blobExists reports whether the bucket already holds a blob
+func (s *S3Service) blobExists(ctx context.Context, digest string) (bool, error) {
+	_, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(blobName(digest)),
+	})
+	if err == nil {
+		return true, nil
+	}
+	var notFound *types.NotFound
+	if errors.As(err, &notFound) {
+		return false, nil
+	}
+	return false, fmt.Errorf("error checking blob %s in S3: %v", digest, err)
+}
+
+// PullMCP downloads a server's image into the cache: the blobs of its manifest the cache does not
+// hold yet, or the whole archive for images pushed before blobs were shared, unless the cache holds
+// the same version. Blobs are downloaded in ranged parts, so a pull that is interrupted resumes
+// where it stopped.
+func (s *S3Service) PullMCP(ctx context.Context, cache *ArtifactCache, author, imageName string) (*CachedImage, error) {
+	manifest, manifestData, tag, err := s.getManifest(ctx, author, imageName)
+	if err != nil {
+		return nil, err
+	}
+	if manifest == nil {
+		return s.pullArchive(ctx, cache, author, imageName)
+	}
+
+	var total int64
+	for _, descriptor := range append([]ociDescriptor{manifest.Config}, manifest.Layers...) {
+		if !cache.hasBlob(descriptor) {
+			total += descriptor.Size
+		}
+	}
+	progress := s.newProgress("⬇️  Downloading", total)
+	image, err := cache.storeImage(s3Ref(author, imageName), tag, manifestData, func(descriptor ociDescriptor, path string) error {
+		download := objectDownload{key: blobName(descriptor.Digest), path: path, size: descriptor.Size}
+		return s.downloadObject(ctx, download, progress)
+	})
+	if err != nil {
+		return nil, err
+	}
+	progress.Finish()
+	return image, nil
+}
 
-	result, err := s.client.GetObject(context.TODO(), &s3.GetObjectInput{
+// s3Ref is the name images pulled from S3 are cached under
+func s3Ref(author, imageName string) string {
+	return fmt.Sprintf("s3://%s/%s", author, imageName)
+}
+
+// getManifest downloads the image manifest of a server and the tag its archive carries. It returns
+// a nil manifest for images pushed as whole archives.
+func (s *S3Service) getManifest(ctx context.Context, author, imageName string) (*ociManifest, []byte, string, error) {
+	result, err := s.client.GetObject(ctx, &s3.GetObjectInput{
 		Bucket: aws.String(s.bucket),
-		Key:    aws.String(objectKey),
+		Key:    aws.String(fmt.Sprintf("%s/%s.manifest", author, imageName)),
 	})
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return nil, nil, "", nil
+	}
 	if err != nil {
-		return fmt.Errorf("error downloading from S3: %v", err)
+		return nil, nil, "", fmt.Errorf("error downloading manifest from S3: %v", err)
 	}
-	defer result.Body.Close()
+	data, err := io.ReadAll(result.Body)
+	result.Body.Close()
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("error downloading manifest from S3: %v", err)
+	}
+
+	if data, err = s.selectManifest(ctx, data); err != nil {
+		return nil, nil, "", fmt.Errorf("image %s/%s: %v", author, imageName, err)
+	}
+
+	var manifest ociManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, nil, "", fmt.Errorf("invalid image manifest %s/%s: %v", author, imageName, err)
+	}
+	for _, descriptor := range append([]ociDescriptor{manifest.Config}, manifest.Layers...) {
+		if !digestPattern.MatchString(descriptor.Digest) {
+			return nil, nil, "", fmt.Errorf("invalid image manifest %s/%s: unsupported digest %q", author, imageName, descriptor.Digest)
+		}
+	}
+	tag := manifest.Annotations[annotationRefName]
+	if tag == "" {
+		tag = strings.ToLower(imageName) + ":latest"
+	}
+	return &manifest, data, tag, nil
+}
 
-	// Create downloaded directory if it doesn't exist
-	downloadedDir := "downloaded"
-	if err := os.MkdirAll(downloadedDir, 0755); err != nil {
-		return fmt.Errorf("error creating downloaded directory: %v", err)
+// selectManifest returns data itself when it is an image manifest, or the manifest for s.Platform
+// when it is the index of a multi-platform push
+func (s *S3Service) selectManifest(ctx context.Context, data []byte) ([]byte, error) {
+	var index ociIndex
+	if err := json.Unmarshal(data, &index); err != nil || !isIndexMediaType(index.MediaType) {
+		return data, nil
 	}
 
-	// Create the output file
-	outputPath := filepath.Join(downloadedDir, fmt.Sprintf("%s.tar", imageName))
-	file, err := os.Create(outputPath)
+	platform := hostPlatform()
+	if s.Platform != "" {
+		var err error
+		if platform, err = parsePlatform(s.Platform); err != nil {
+			return nil, err
+		}
+	}
+	descriptor, err := selectPlatform(index.Manifests, platform)
 	if err != nil {
-		return fmt.Errorf("error creating output file: %v", err)
+		return nil, err
+	}
+	if !digestPattern.MatchString(descriptor.Digest) {
+		return nil, fmt.Errorf("unsupported digest %q", descriptor.Digest)
 	}
-	defer file.Close()
 
-	// Copy the S3 object body to the file
-	if _, err := io.Copy(file, result.Body); err != nil {
-		return fmt.Errorf("error writing to file: %v", err)
+	result, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(blobName(descriptor.Digest)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error downloading manifest for %s from S3: %v", platform, err)
+	}
+	defer result.Body.Close()
+	if data, err = io.ReadAll(result.Body); err != nil {
+		return nil, fmt.Errorf("error downloading manifest for %s from S3: %v", platform, err)
 	}
+	if sha256Digest(data) != descriptor.Digest {
+		return nil, fmt.Errorf("manifest for %s does not match its digest", platform)
+	}
+	return data, nil
+}
 
+// StreamMCP writes a server's image archive to w as it downloads, without touching the disk, so
+// it can be piped straight into an engine's image load. Blobs are checked against their digest on
+// the fly; the archive's index is written last, so an archive cut short by a bad blob never loads.
+func (s *S3Service) StreamMCP(ctx context.Context, author, imageName string, w io.Writer) error {
+	manifest, manifestData, tag, err := s.getManifest(ctx, author, imageName)
+	if err != nil {
+		return err
+	}
+	if manifest == nil {
+		return s.streamArchive(ctx, author, imageName, w)
+	}
+
+	var total int64
+	for _, descriptor := range append([]ociDescriptor{manifest.Config}, manifest.Layers...) {
+		total += descriptor.Size
+	}
+	progress := s.newProgress("⬇️  Streaming", total)
+	_, err = writeImageArchive(w, manifest, manifestData, tag, func(digest string) (io.ReadCloser, error) {
+		blob, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+			Bucket: aws.String(s.bucket),
+			Key:    aws.String(blobName(digest)),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error downloading blob %s from S3: %v", digest, err)
+		}
+		return progressReader(blob.Body, progress), nil
+	})
+	if err != nil {
+		return err
+	}
+	progress.Finish()
 	return nil
 }
 
-// ListMCPs lists all MCPs in the S3 bucket
-func (s *S3Service) ListMCPs() ([]string, error) {
-	result, err := s.client.ListObjectsV2(context.TODO(), &s3.ListObjectsV2Input{
+// streamArchive writes a whole image tar pushed before blobs were shared to w. Objects uploaded in
+// one request have the MD5 of their content as ETag, which is checked once the body is read.
+func (s *S3Service) streamArchive(ctx context.Context, author, imageName string, w io.Writer) error {
+	result, err := s.client.GetObject(ctx, &s3.GetObjectInput{
 		Bucket: aws.String(s.bucket),
+		Key:    aws.String(fmt.Sprintf("%s/%s.tar", author, imageName)),
 	})
 	if err != nil {
-		return nil, fmt.Errorf("error listing objects: %v", err)
+		return fmt.Errorf("error downloading from S3: %v", err)
+	}
+	defer result.Body.Close()
+
+	progress := s.newProgress("⬇️  Streaming", aws.ToInt64(result.ContentLength))
+	hash := md5.New()
+	if _, err := io.Copy(io.MultiWriter(w, hash), progressReader(result.Body, progress)); err != nil {
+		return fmt.Errorf("error downloading from S3: %v", err)
+	}
+	etag := strings.Trim(aws.ToString(result.ETag), `"`)
+	if !strings.Contains(etag, "-") && etag != "" && etag != hex.EncodeToString(hash.Sum(nil)) {
+		return fmt.Errorf("image archive %s/%s does not match its ETag", author, imageName)
+	}
+	progress.Finish()
+	return nil
+}
+
+// pullArchive downloads a whole image tar pushed before blobs were shared into the cache
+func (s *S3Service) pullArchive(ctx context.Context, cache *ArtifactCache, author, imageName string) (*CachedImage, error) {
+	objectKey := fmt.Sprintf("%s/%s.tar", author, imageName)
+	ref := s3Ref(author, imageName)
+
+	size, etag, err := s.objectInfo(ctx, objectKey)
+	if err != nil {
+		return nil, fmt.Errorf("error downloading from S3: %v", err)
+	}
+	unlock, err := cache.lock(false)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	if image, ok := cache.cachedArchive(ref, etag); ok {
+		return image, nil
 	}
 
+	progress := s.newProgress("⬇️  Downloading", size)
+	download := objectDownload{key: objectKey, path: cache.downloadPath(ref), size: size, etag: etag}
+	if err := s.downloadObject(ctx, download, progress); err != nil {
+		return nil, err
+	}
+	progress.Finish()
+	return cache.storeArchive(ref, etag, download.path)
+}
+
+// newProgress starts a progress bar on s.Progress, or returns nil when progress is not shown
+func (s *S3Service) newProgress(label string, total int64) *Progress {
+	if s.Progress == nil || total == 0 {
+		return nil
+	}
+	return NewProgress(s.Progress, label, total)
+}
+
+// ListMCPs lists all MCPs in the S3 bucket
+func (s *S3Service) ListMCPs(ctx context.Context) ([]string, error) {
 	var mcps []string
-	for _, obj := range result.Contents {
-		key := *obj.Key
-		if strings.HasSuffix(key, ".tar") {
-			// Remove .tar extension and add to list
-			mcps = append(mcps, strings.TrimSuffix(key, ".tar"))
+	seen := make(map[string]bool)
+
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error listing objects: %v", err)
+		}
+
+		for _, obj := range page.Contents {
+			key := *obj.Key
+			// Blobs are shared content, not servers
+			if strings.HasPrefix(key, "blobs/") {
+				continue
+			}
+			for _, suffix := range []string{".manifest", ".tar"} {
+				// Remove the extension and add to list
+				if name := strings.TrimSuffix(key, suffix); name != key && !seen[name] {
+					seen[name] = true
+					mcps = append(mcps, name)
+				}
+			}
 		}
 	}
 
 	return mcps, nil
-} 
\ No newline at end of file
+}
+
+// PushMetadata uploads the server's metadata document next to its image tar
+func (s *S3Service) PushMetadata(ctx context.Context, author, imageName string, metadata *models.ServerMetadata) error {
+	objectKey := fmt.Sprintf("%s/%s.json", author, imageName)
+
+	data, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding metadata: %v", err)
+	}
+
+	_, err = s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(objectKey),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/json"),
+	})
+	if err != nil {
+		return fmt.Errorf("error uploading metadata to S3: %v", err)
+	}
+
+	return nil
+}
+
+// GetMetadata downloads the metadata document of a pushed server
+func (s *S3Service) GetMetadata(ctx context.Context, author, imageName string) (*models.ServerMetadata, error) {
+	return s.getMetadataObject(ctx, fmt.Sprintf("%s/%s.json", author, imageName))
+}
+
+func (s *S3Service) getMetadataObject(ctx context.Context, objectKey string) (*models.ServerMetadata, error) {
+	result, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(objectKey),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error downloading metadata from S3: %v", err)
+	}
+	defer result.Body.Close()
+
+	var metadata models.ServerMetadata
+	if err := json.NewDecoder(result.Body).Decode(&metadata); err != nil {
+		return nil, fmt.Errorf("error decoding metadata %s: %v", objectKey, err)
+	}
+
+	return &metadata, nil
+}
+
+// SearchMCPs returns the metadata of every server whose name, description, keywords or tool names contain query
+func (s *S3Service) SearchMCPs(ctx context.Context, query string) ([]models.ServerMetadata, error) {
+	query = strings.ToLower(query)
+	var matches []models.ServerMetadata
+
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error listing objects: %v", err)
+		}
+
+		for _, obj := range page.Contents {
+			if !strings.HasSuffix(*obj.Key, ".json") {
+				continue
+			}
+			metadata, err := s.getMetadataObject(ctx, *obj.Key)
+			if err != nil {
+				return nil, err
+			}
+			if metadataMatches(metadata, query) {
+				matches = append(matches, *metadata)
+			}
+		}
+	}
+
+	return matches, nil
+}
+
+// metadataMatches reports whether a lower-cased query appears in any searchable field
+func metadataMatches(metadata *models.ServerMetadata, query string) bool {
+	fields := []string{metadata.Config.Name, metadata.Config.Description}
+	fields = append(fields, metadata.Config.Keywords...)
+	if metadata.Inspection != nil {
+		for _, tool := range metadata.Inspection.Tools {
+			fields = append(fields, tool.Name, tool.Description)
+		}
+	}
+
+	for _, field := range fields {
+		if strings.Contains(strings.ToLower(field), query) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,245 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+)
+
+// downloadAttempts is the number of times a part is requested before a download gives up
+const downloadAttempts = 5
+
+// downloadRetryDelay is the wait before the first retry of a part; it doubles with each retry
+var downloadRetryDelay = 500 * time.Millisecond
+
+var digestPattern = regexp.MustCompile(`^sha256:[0-9a-f]{64}$`)
+
+// objectDownload is an object to download and the file to download it to
+type objectDownload struct {
+	key  string
+	path string
+	size int64
+	// etag, when set, makes the download fail rather than mix parts of two versions of the object
+	etag string
+	// digest, when set, is checked before the download is moved into place
+	digest string
+}
+
+// downloadState records the parts of an object already written to a partial download
+type downloadState struct {
+	ETag     string  `json:"etag"`
+	Size     int64   `json:"size"`
+	PartSize int64   `json:"partSize"`
+	Done     []int64 `json:"done"`
+}
+
+// objectInfo returns the size and ETag of an object
+func (s *S3Service) objectInfo(ctx context.Context, key string) (int64, string, error) {
+	head, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return 0, "", err
+	}
+	return aws.ToInt64(head.ContentLength), aws.ToString(head.ETag), nil
+}
+
+// downloadObject downloads an object in ranged parts, several at a time, into <path>.partial and
+// renames it into place once complete. The parts written so far are recorded in
+// <path>.partial.json, so that an interrupted download resumes instead of starting over.
+func (s *S3Service) downloadObject(ctx context.Context, d objectDownload, progress *Progress) error {
+	partial := d.path + ".partial"
+	statePath := partial + ".json"
+	partSize := s.partSize(d.size)
+
+	state := downloadState{ETag: d.etag, Size: d.size, PartSize: partSize}
+	file, err := os.OpenFile(partial, os.O_RDWR, 0)
+	if err == nil && !resumeDownload(file, statePath, &state) {
+		file.Close()
+		err = os.ErrNotExist
+	}
+	if err != nil {
+		state.Done = nil
+		if file, err = os.Create(partial); err != nil {
+			return fmt.Errorf("error creating download file: %v", err)
+		}
+		if err := file.Truncate(d.size); err != nil {
+			file.Close()
+			return fmt.Errorf("error creating download file: %v", err)
+		}
+	}
+	defer file.Close()
+
+	done := make(map[int64]bool)
+	for _, index := range state.Done {
+		done[index] = true
+	}
+	var pending []int64
+	for index := int64(0); index*partSize < d.size; index++ {
+		if done[index] {
+			progress.Add(min(partSize, d.size-index*partSize))
+		} else {
+			pending = append(pending, index)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	concurrency := s.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultUploadConcurrency
+	}
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		firstErr error
+		indexes  = make(chan int64)
+	)
+	for i := 0; i < min(concurrency, len(pending)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				offset := index * partSize
+				err := s.downloadPart(ctx, d, file, offset, min(partSize, d.size-offset), progress)
+
+				mu.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = fmt.Errorf("error downloading %s: %v", d.key, err)
+					cancel()
+				} else if err == nil {
+					state.Done = append(state.Done, index)
+					saveDownloadState(statePath, &state)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, index := range pending {
+		select {
+		case indexes <- index:
+		case <-ctx.Done():
+		}
+	}
+	close(indexes)
+	wg.Wait()
+	if firstErr != nil {
+		return fmt.Errorf("%w (run pull again to resume the download)", firstErr)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if d.digest != "" {
+		hash := sha256.New()
+		if _, err := io.Copy(hash, io.NewSectionReader(file, 0, d.size)); err != nil {
+			return err
+		}
+		if "sha256:"+hex.EncodeToString(hash.Sum(nil)) != d.digest {
+			os.Remove(partial)
+			os.Remove(statePath)
+			return fmt.Errorf("blob %s does not match its digest", d.digest)
+		}
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(partial, d.path); err != nil {
+		return fmt.Errorf("error moving download into place: %v", err)
+	}
+	os.Remove(statePath)
+	return nil
+}
+
+// resumeDownload loads the state of a partial download, reporting whether it can be resumed
+func resumeDownload(file *os.File, statePath string, state *downloadState) bool {
+	data, err := os.ReadFile(statePath)
+	if err != nil {
+		return false
+	}
+	var saved downloadState
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return false
+	}
+	if saved.ETag != state.ETag || saved.Size != state.Size || saved.PartSize != state.PartSize {
+		return false
+	}
+	info, err := file.Stat()
+	if err != nil || info.Size() != state.Size {
+		return false
+	}
+	state.Done = saved.Done
+	return true
+}
+
+func saveDownloadState(path string, state *downloadState) {
+	if data, err := json.Marshal(state); err == nil {
+		os.WriteFile(path, data, 0644)
+	}
+}
+
+// downloadPart writes length bytes of an object at offset into file, retrying with backoff from
+// where the previous attempt stopped
+func (s *S3Service) downloadPart(ctx context.Context, d objectDownload, file *os.File, offset, length int64, progress *Progress) error {
+	var err error
+	for attempt := 0; attempt < downloadAttempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(downloadRetryDelay << (attempt - 1)):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		var n int64
+		n, err = s.getRange(ctx, d, file, offset, length, progress)
+		offset += n
+		length -= n
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+		// The SDK already retries the errors S3 reports; only broken transfers are retried here
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) {
+			return err
+		}
+	}
+	return err
+}
+
+// getRange copies a byte range of an object into file, returning how much it wrote
+func (s *S3Service) getRange(ctx context.Context, d objectDownload, file *os.File, offset, length int64, progress *Progress) (int64, error) {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(d.key),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)),
+	}
+	if d.etag != "" {
+		input.IfMatch = aws.String(d.etag)
+	}
+	out, err := s.client.GetObject(ctx, input)
+	if err != nil {
+		return 0, err
+	}
+	defer out.Body.Close()
+
+	n, err := io.Copy(&progressWriter{w: io.NewOffsetWriter(file, offset), progress: progress}, io.LimitReader(out.Body, length))
+	if err == nil && n < length {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
@@ -0,0 +1,418 @@
+package services
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"mcphub/models"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeS3 is an in-memory stand-in for a single S3 bucket, addressed path-style
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	// uploads holds the parts of in-progress multipart uploads by upload ID
+	uploads    map[string]map[int][]byte
+	uploadKeys map[string]string
+	partPuts   int
+	// failPart makes uploads of this part number fail
+	failPart int
+	// the next cuts ranged downloads starting at or after cutFrom are cut off halfway
+	cutFrom   int64
+	cuts      int
+	rangeGets int
+	// onPart is called for every part uploaded
+	onPart func()
+	// listPageSize limits the keys of each listing page, 1000 when zero
+	listPageSize int
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, *S3Service) {
+	fake := &fakeS3{objects: make(map[string][]byte), uploads: make(map[string]map[int][]byte), uploadKeys: make(map[string]string)}
+	server := httptest.NewServer(http.HandlerFunc(fake.serve))
+	t.Cleanup(server.Close)
+
+	client := s3.New(s3.Options{
+		Region:           "us-east-1",
+		BaseEndpoint:     aws.String(server.URL),
+		UsePathStyle:     true,
+		Credentials:      aws.AnonymousCredentials{},
+		RetryMaxAttempts: 1,
+	})
+	return fake, &S3Service{client: client, bucket: "mcp-servers"}
+}
+
+// list answers ListObjectsV2 with the keys in order, using the last key of a page as its continuation token
+func (f *fakeS3) list(w http.ResponseWriter, query url.Values) {
+	keys := make([]string, 0, len(f.objects))
+	for key := range f.objects {
+		if key > query.Get("continuation-token") {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+
+	pageSize := cmp.Or(f.listPageSize, 1000)
+	truncated := len(keys) > pageSize
+	if truncated {
+		keys = keys[:pageSize]
+	}
+
+	fmt.Fprintf(w, `<ListBucketResult><IsTruncated>%t</IsTruncated><KeyCount>%d</KeyCount>`, truncated, len(keys))
+	if truncated {
+		fmt.Fprintf(w, `<NextContinuationToken>%s</NextContinuationToken>`, keys[len(keys)-1])
+	}
+	for _, key := range keys {
+		fmt.Fprintf(w, `<Contents><Key>%s</Key><Size>%d</Size></Contents>`, key, len(f.objects[key]))
+	}
+	io.WriteString(w, `</ListBucketResult>`)
+}
+
+func (f *fakeS3) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key := strings.TrimPrefix(r.URL.Path, "/mcp-servers/")
+	query := r.URL.Query()
+	uploadID := query.Get("uploadId")
+	switch {
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		uploadID = fmt.Sprintf("upload-%d", len(f.uploadKeys)+1)
+		f.uploads[uploadID] = make(map[int][]byte)
+		f.uploadKeys[uploadID] = key
+		fmt.Fprintf(w, `<InitiateMultipartUploadResult><Key>%s</Key><UploadId>%s</UploadId></InitiateMultipartUploadResult>`, key, uploadID)
+	case r.Method == http.MethodGet && query.Has("uploads"):
+		io.WriteString(w, `<ListMultipartUploadsResult>`)
+		for id, uploadKey := range f.uploadKeys {
+			fmt.Fprintf(w, `<Upload><Key>%s</Key><UploadId>%s</UploadId><Initiated>2026-01-01T00:00:00.000Z</Initiated></Upload>`, uploadKey, id)
+		}
+		io.WriteString(w, `</ListMultipartUploadsResult>`)
+	case r.Method == http.MethodGet && query.Get("list-type") == "2":
+		f.list(w, query)
+	case r.Method == http.MethodGet && uploadID != "":
+		io.WriteString(w, `<ListPartsResult><IsTruncated>false</IsTruncated>`)
+		for number, data := range f.uploads[uploadID] {
+			fmt.Fprintf(w, `<Part><PartNumber>%d</PartNumber><ETag>"%x"</ETag><Size>%d</Size></Part>`, number, md5.Sum(data), len(data))
+		}
+		io.WriteString(w, `</ListPartsResult>`)
+	case r.Method == http.MethodPut && uploadID != "":
+		number, _ := strconv.Atoi(query.Get("partNumber"))
+		if number == f.failPart {
+			w.WriteHeader(http.StatusInternalServerError)
+			io.WriteString(w, `<Error><Code>InternalError</Code><Message>connection reset</Message></Error>`)
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		f.uploads[uploadID][number] = data
+		f.partPuts++
+		if f.onPart != nil {
+			f.onPart()
+		}
+		w.Header().Set("ETag", fmt.Sprintf(`"%x"`, md5.Sum(data)))
+	case r.Method == http.MethodPost && uploadID != "":
+		var complete struct {
+			Parts []struct{ PartNumber int } `xml:"Part"`
+		}
+		xml.NewDecoder(r.Body).Decode(&complete)
+		var object []byte
+		for _, part := range complete.Parts {
+			object = append(object, f.uploads[uploadID][part.PartNumber]...)
+		}
+		f.objects[key] = object
+		delete(f.uploads, uploadID)
+		delete(f.uploadKeys, uploadID)
+		fmt.Fprintf(w, `<CompleteMultipartUploadResult><Key>%s</Key><ETag>"done"</ETag></CompleteMultipartUploadResult>`, key)
+	case r.Method == http.MethodPut:
+		data, _ := io.ReadAll(r.Body)
+		f.objects[key] = data
+	case r.Method == http.MethodDelete && uploadID != "":
+		delete(f.uploads, uploadID)
+		delete(f.uploadKeys, uploadID)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		data, ok := f.objects[key]
+		if !ok {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			if r.Method == http.MethodGet {
+				io.WriteString(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message></Error>`)
+			}
+			return
+		}
+		w.Header().Set("ETag", fmt.Sprintf(`"%x"`, md5.Sum(data)))
+		var start, end int
+		if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end); err == nil {
+			f.rangeGets++
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+			w.Header().Set("Content-Length", strconv.Itoa(end-start+1))
+			w.WriteHeader(http.StatusPartialContent)
+			if f.cuts > 0 && int64(start) >= f.cutFrom {
+				f.cuts--
+				end = start + (end-start)/2
+			}
+			w.Write(data[start : end+1])
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		if r.Method == http.MethodGet {
+			w.Write(data)
+		}
+	}
+}
+
+func newTestCache(t *testing.T) *ArtifactCache {
+	cache, err := NewArtifactCache(t.TempDir())
+	require.NoError(t, err)
+	return cache
+}
+
+func TestS3PushSharesBlobs(t *testing.T) {
+	ctx := context.Background()
+	cacheDir := t.TempDir()
+	writeTestBaseLayout(t, BaseImageCachePath(cacheDir, "python:3.11-slim"))
+	fake, service := newFakeS3(t)
+
+	weather := &models.MCPConfig{Name: "Weather", Author: "alice", Run: models.RunConfig{Command: "python", Args: []string{"server.py"}}}
+	fake.objects["alice/Weather.tar"] = []byte("archive from an earlier push")
+	push, err := service.PushMCP(ctx, "alice", "Weather", nil, models.PlatformImage{TarFilePath: buildTestArchive(t, cacheDir, weather, "print('weather')\n")})
+	require.NoError(t, err)
+	assert.Equal(t, 3, push.Uploaded)
+	assert.Equal(t, 0, push.Existing)
+	assert.Contains(t, fake.objects, "alice/Weather.manifest")
+	assert.NotContains(t, fake.objects, "alice/Weather.tar")
+
+	// A second server on the same base image only uploads its own layer and config
+	news := &models.MCPConfig{Name: "News", Author: "bob", Run: models.RunConfig{Command: "python", Args: []string{"server.py"}}}
+	push, err = service.PushMCP(ctx, "bob", "News", nil, models.PlatformImage{TarFilePath: buildTestArchive(t, cacheDir, news, "print('news')\n")})
+	require.NoError(t, err)
+	assert.Equal(t, 2, push.Uploaded)
+	assert.Equal(t, 1, push.Existing)
+
+	cache := newTestCache(t)
+	image, err := service.PullMCP(ctx, cache, "alice", "Weather")
+	require.NoError(t, err)
+	assert.Equal(t, 3, image.Downloaded)
+	var pulled bytes.Buffer
+	require.NoError(t, cache.WriteArchive(image, &pulled))
+	assert.Equal(t, []string{"weather:latest"}, archiveRepoTags(bytes.NewReader(pulled.Bytes())))
+
+	// Blobs cached by an earlier pull are not downloaded again
+	image, err = service.PullMCP(ctx, cache, "bob", "News")
+	require.NoError(t, err)
+	assert.Equal(t, 2, image.Downloaded)
+	assert.Equal(t, 1, image.Cached)
+
+	// Streamed pulls write the same archive without touching the disk
+	var streamed bytes.Buffer
+	require.NoError(t, service.StreamMCP(ctx, "alice", "Weather", &streamed))
+	assert.Equal(t, pulled.Bytes(), streamed.Bytes())
+
+	// Images pushed as whole archives are still pulled, and cached until the object changes
+	fake.objects["carol/Legacy.tar"] = []byte("legacy archive")
+	for _, downloaded := range []int{1, 0} {
+		image, err = service.PullMCP(ctx, cache, "carol", "Legacy")
+		require.NoError(t, err)
+		assert.Equal(t, downloaded, image.Downloaded)
+	}
+	pulled.Reset()
+	require.NoError(t, cache.WriteArchive(image, &pulled))
+	assert.Equal(t, "legacy archive", pulled.String())
+	streamed.Reset()
+	require.NoError(t, service.StreamMCP(ctx, "carol", "Legacy", &streamed))
+	assert.Equal(t, "legacy archive", streamed.String())
+
+	// Corrupted blobs are refused
+	var manifest ociManifest
+	require.NoError(t, json.Unmarshal(fake.objects["alice/Weather.manifest"], &manifest))
+	fake.objects[blobName(manifest.Layers[1].Digest)] = make([]byte, manifest.Layers[1].Size)
+	_, err = service.PullMCP(ctx, newTestCache(t), "alice", "Weather")
+	assert.ErrorContains(t, err, "does not match its digest")
+	assert.ErrorContains(t, service.StreamMCP(ctx, "alice", "Weather", io.Discard), "does not match its digest")
+}
+
+func TestS3ListMCPs(t *testing.T) {
+	fake, service := newFakeS3(t)
+	fake.listPageSize = 2
+	for _, key := range []string{
+		"alice/Weather.manifest", "alice/Weather.json", "blobs/sha256/aaa.manifest", "blobs/sha256/bbb",
+		"bob/News.manifest", "carol/Legacy.tar", "carol/Legacy.json",
+	} {
+		fake.objects[key] = []byte("{}")
+	}
+
+	mcps, err := service.ListMCPs(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"alice/Weather", "bob/News", "carol/Legacy"}, mcps)
+}
+
+func TestS3MultiPlatformPush(t *testing.T) {
+	ctx := context.Background()
+	cacheDir := t.TempDir()
+	writeTestBaseLayout(t, BaseImageCachePath(cacheDir, "python:3.11-slim"), "linux/amd64", "linux/arm64")
+	processor := NewZipProcessor(nil)
+	processor.OCIBuilder = &OCIBuilder{BaseCacheDir: cacheDir}
+	processor.WorkDir = t.TempDir()
+	processor.Platforms = []string{"linux/amd64", "linux/arm64"}
+
+	server := testZip(t, map[string]string{
+		"mcp.json":  `{"name":"Weather","run":{"command":"python","args":["server.py"]}}`,
+		"server.py": "print('weather')\n",
+	})
+	result, err := processor.ProcessZip(ctx, server, "weather.zip")
+	require.NoError(t, err)
+	defer processor.Cleanup(result)
+	require.Len(t, result.Images, 2)
+	assert.Equal(t, []string{"linux/amd64", "linux/arm64"}, result.Config.Platforms)
+
+	fake, service := newFakeS3(t)
+	_, err = service.PushMCP(ctx, "alice", "Weather", result.Config.Platforms, result.Images...)
+	require.NoError(t, err)
+	var index ociIndex
+	require.NoError(t, json.Unmarshal(fake.objects["alice/Weather.manifest"], &index))
+	assert.Equal(t, MediaTypeOCIIndex, index.MediaType)
+	require.Len(t, index.Manifests, 2)
+
+	// Each engine gets the image for its own platform
+	for _, descriptor := range index.Manifests {
+		service.Platform = descriptor.Platform.String()
+		image, err := service.PullMCP(ctx, newTestCache(t), "alice", "Weather")
+		require.NoError(t, err)
+		assert.Equal(t, descriptor.Digest, image.Digest)
+	}
+	service.Platform = "linux/s390x"
+	_, err = service.PullMCP(ctx, newTestCache(t), "alice", "Weather")
+	assert.ErrorContains(t, err, "no manifest for linux/s390x")
+
+	// An image for a single declared platform is listed with it, so other engines don't pull it
+	_, err = service.PushMCP(ctx, "alice", "Weather", []string{"linux/arm64"}, result.Images[1])
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(fake.objects["alice/Weather.manifest"], &index))
+	require.Len(t, index.Manifests, 1)
+	assert.Equal(t, "linux/arm64", index.Manifests[0].Platform.String())
+	service.Platform = "linux/amd64"
+	_, err = service.PullMCP(ctx, newTestCache(t), "alice", "Weather")
+	assert.ErrorContains(t, err, "no manifest for linux/amd64")
+
+	// Two images for one platform cannot share an index
+	_, err = service.PushMCP(ctx, "alice", "Weather", result.Config.Platforms, result.Images[0], result.Images[0])
+	assert.ErrorContains(t, err, "more than one image is for platform linux/amd64")
+}
+
+func TestS3MultipartUploadResumes(t *testing.T) {
+	fake, service := newFakeS3(t)
+	service.PartSize = MinPartSize
+	service.Concurrency = 2
+
+	data := bytes.Repeat([]byte("0123456789abcdef"), (2*MinPartSize+MinPartSize/2)/16)
+	path := filepath.Join(t.TempDir(), "layer")
+	require.NoError(t, os.WriteFile(path, data, 0644))
+	blob := imageBlob{descriptor: ociDescriptor{MediaType: MediaTypeOCILayer, Digest: sha256Digest(data), Size: int64(len(data))}, path: path}
+	key := blobName(blob.descriptor.Digest)
+
+	// The upload is interrupted at part 3, leaving parts 1 and 2 in S3
+	fake.failPart = 3
+	service.Concurrency = 1
+	err := service.uploadBlob(context.Background(), key, blob, nil)
+	assert.ErrorContains(t, err, "run push again to resume")
+	assert.NotContains(t, fake.objects, key)
+	assert.Equal(t, 2, fake.partPuts)
+
+	// The next upload only sends the missing part
+	fake.failPart = 0
+	service.Concurrency = 2
+	var out bytes.Buffer
+	progress := NewProgress(&out, "Uploading", blob.descriptor.Size)
+	require.NoError(t, service.uploadBlob(context.Background(), key, blob, progress))
+	progress.Finish()
+	assert.Equal(t, 3, fake.partPuts)
+	assert.Equal(t, data, fake.objects[key])
+	assert.Empty(t, fake.uploads)
+	assert.Contains(t, out.String(), "12.5 MB / 12.5 MB")
+}
+
+func TestS3CancelledUploadIsAborted(t *testing.T) {
+	fake, service := newFakeS3(t)
+	service.PartSize = MinPartSize
+	service.Concurrency = 1
+
+	data := bytes.Repeat([]byte("x"), 2*MinPartSize+1)
+	path := filepath.Join(t.TempDir(), "layer")
+	require.NoError(t, os.WriteFile(path, data, 0644))
+	blob := imageBlob{descriptor: ociDescriptor{MediaType: MediaTypeOCILayer, Digest: sha256Digest(data), Size: int64(len(data))}, path: path}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fake.onPart = cancel
+	err := service.uploadBlob(ctx, blobName(blob.descriptor.Digest), blob, nil)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 1, fake.partPuts)
+	assert.Empty(t, fake.uploads)
+}
+
+func TestS3PartSize(t *testing.T) {
+	service := &S3Service{}
+	assert.Equal(t, int64(DefaultPartSize), service.partSize(100<<20))
+	service.PartSize = 1 << 20
+	assert.Equal(t, int64(MinPartSize), service.partSize(100<<20))
+	// Objects are never split into more than 10000 parts
+	assert.Equal(t, int64(100<<30)/maxUploadParts+1, service.partSize(100<<30))
+}
+
+func TestS3DownloadResumes(t *testing.T) {
+	ctx := context.Background()
+	downloadRetryDelay = time.Millisecond
+	t.Cleanup(func() { downloadRetryDelay = 500 * time.Millisecond })
+	fake, service := newFakeS3(t)
+	service.PartSize = MinPartSize
+	service.Concurrency = 1
+	cache := newTestCache(t)
+
+	data := bytes.Repeat([]byte("0123456789abcdef"), (2*MinPartSize+MinPartSize/2)/16)
+	fake.objects["carol/Legacy.tar"] = data
+
+	// The third part keeps breaking off, so the pull gives up after retrying it
+	fake.cutFrom, fake.cuts = 2*MinPartSize, 100
+	_, err := service.PullMCP(ctx, cache, "carol", "Legacy")
+	assert.ErrorContains(t, err, "run pull again to resume")
+	assert.Equal(t, 2+downloadAttempts, fake.rangeGets)
+
+	// The next pull only fetches the third part, retrying it once
+	fake.cuts, fake.rangeGets = 1, 0
+	var out bytes.Buffer
+	service.Progress = &out
+	image, err := service.PullMCP(ctx, cache, "carol", "Legacy")
+	require.NoError(t, err)
+	assert.Equal(t, 2, fake.rangeGets)
+	var pulled bytes.Buffer
+	require.NoError(t, cache.WriteArchive(image, &pulled))
+	assert.Equal(t, data, pulled.Bytes())
+	assert.Contains(t, out.String(), "12.5 MB / 12.5 MB")
+	downloads, err := os.ReadDir(filepath.Join(cache.Dir(), "downloads"))
+	require.NoError(t, err)
+	assert.Empty(t, downloads)
+}
@@ -0,0 +1,251 @@
+package services
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const (
+	// DefaultPartSize is the multipart upload part size used when none is configured
+	DefaultPartSize = 16 << 20
+	// MinPartSize is the smallest part S3 accepts, except for the last one
+	MinPartSize = 5 << 20
+	// DefaultUploadConcurrency is the number of parts uploaded at once when none is configured
+	DefaultUploadConcurrency = 4
+
+	maxUploadParts = 10000
+)
+
+// partSize returns the part size for an object of size bytes, growing the configured size when
+// the object would otherwise need more parts than S3 allows
+func (s *S3Service) partSize(size int64) int64 {
+	partSize := s.PartSize
+	if partSize <= 0 {
+		partSize = DefaultPartSize
+	}
+	partSize = max(partSize, MinPartSize)
+	return max(partSize, (size+maxUploadParts-1)/maxUploadParts)
+}
+
+// uploadBlob uploads a blob in one request, or as a multipart upload when it is larger than a part
+func (s *S3Service) uploadBlob(ctx context.Context, key string, blob imageBlob, progress *Progress) error {
+	if blob.path != "" && blob.descriptor.Size > s.partSize(blob.descriptor.Size) {
+		return s.multipartUpload(ctx, key, blob, progress)
+	}
+
+	body, err := blob.open()
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	_, err = s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:        aws.String(s.bucket),
+		Key:           aws.String(key),
+		Body:          body,
+		ContentLength: aws.Int64(blob.descriptor.Size),
+		ContentType:   aws.String(blob.descriptor.MediaType),
+	})
+	if err != nil {
+		return fmt.Errorf("error uploading blob %s to S3: %v", blob.descriptor.Digest, err)
+	}
+	progress.Add(blob.descriptor.Size)
+	return nil
+}
+
+// multipartUpload uploads a blob in parts, several at a time. An upload broken off by an error is
+// left in place so that the next push of the blob resumes it, keeping the parts already uploaded;
+// a cancelled one is aborted.
+func (s *S3Service) multipartUpload(ctx context.Context, key string, blob imageBlob, progress *Progress) error {
+	file, err := os.Open(blob.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	size := blob.descriptor.Size
+	partSize := s.partSize(size)
+	partCount := int32((size + partSize - 1) / partSize)
+	partRange := func(number int32) (int64, int64) {
+		offset := int64(number-1) * partSize
+		return offset, min(partSize, size-offset)
+	}
+
+	uploadID, completed := s.resumableUpload(ctx, key, file, partRange, partCount)
+	if uploadID == "" {
+		created, err := s.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+			Bucket:      aws.String(s.bucket),
+			Key:         aws.String(key),
+			ContentType: aws.String(blob.descriptor.MediaType),
+		})
+		if err != nil {
+			return fmt.Errorf("error starting upload of blob %s: %v", blob.descriptor.Digest, err)
+		}
+		uploadID = aws.ToString(created.UploadId)
+	}
+
+	parts := make([]types.CompletedPart, 0, partCount)
+	var pending []int32
+	for number := int32(1); number <= partCount; number++ {
+		if part, ok := completed[number]; ok {
+			parts = append(parts, part)
+			_, length := partRange(number)
+			progress.Add(length)
+		} else {
+			pending = append(pending, number)
+		}
+	}
+
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	concurrency := s.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultUploadConcurrency
+	}
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		firstErr error
+		numbers  = make(chan int32)
+	)
+	for i := 0; i < min(concurrency, len(pending)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for number := range numbers {
+				offset, length := partRange(number)
+				out, err := s.client.UploadPart(ctx, &s3.UploadPartInput{
+					Bucket:        aws.String(s.bucket),
+					Key:           aws.String(key),
+					UploadId:      aws.String(uploadID),
+					PartNumber:    aws.Int32(number),
+					Body:          io.NewSectionReader(file, offset, length),
+					ContentLength: aws.Int64(length),
+				})
+
+				mu.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = fmt.Errorf("error uploading part %d of blob %s: %v", number, blob.descriptor.Digest, err)
+					cancel()
+				} else if err == nil {
+					parts = append(parts, types.CompletedPart{ETag: out.ETag, PartNumber: aws.Int32(number)})
+				}
+				mu.Unlock()
+				if err == nil {
+					progress.Add(length)
+				}
+			}
+		}()
+	}
+	for _, number := range pending {
+		select {
+		case numbers <- number:
+		case <-ctx.Done():
+		}
+	}
+	close(numbers)
+	wg.Wait()
+	if err := parent.Err(); err != nil {
+		// A cancelled push leaves nothing behind; only uploads broken off by errors are resumed
+		s.abortUpload(key, uploadID)
+		return err
+	}
+	if firstErr != nil {
+		return fmt.Errorf("%w (run push again to resume the upload)", firstErr)
+	}
+
+	sort.Slice(parts, func(i, j int) bool { return *parts[i].PartNumber < *parts[j].PartNumber })
+	_, err = s.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(s.bucket),
+		Key:             aws.String(key),
+		UploadId:        aws.String(uploadID),
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		return fmt.Errorf("error completing upload of blob %s: %v", blob.descriptor.Digest, err)
+	}
+	return nil
+}
+
+// abortUpload discards the parts of a multipart upload. It runs after the push's context is
+// cancelled, so it gets a context of its own.
+func (s *S3Service) abortUpload(key, uploadID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	s.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(s.bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	})
+}
+
+// resumableUpload finds an interrupted multipart upload of key and the parts of it that match the
+// file. Blob keys are content-addressed, so any upload of the key carries the same content; parts
+// are still checked against their ETag (the MD5 of the part) in case the part size changed.
+func (s *S3Service) resumableUpload(ctx context.Context, key string, file io.ReaderAt, partRange func(int32) (int64, int64), partCount int32) (string, map[int32]types.CompletedPart) {
+	uploads, err := s.client.ListMultipartUploads(ctx, &s3.ListMultipartUploadsInput{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(key),
+	})
+	if err != nil {
+		return "", nil
+	}
+
+	var upload *types.MultipartUpload
+	for i, candidate := range uploads.Uploads {
+		if aws.ToString(candidate.Key) != key {
+			continue
+		}
+		if upload == nil || aws.ToTime(candidate.Initiated).After(aws.ToTime(upload.Initiated)) {
+			upload = &uploads.Uploads[i]
+		}
+	}
+	if upload == nil {
+		return "", nil
+	}
+
+	completed := make(map[int32]types.CompletedPart)
+	paginator := s3.NewListPartsPaginator(s.client, &s3.ListPartsInput{
+		Bucket:   aws.String(s.bucket),
+		Key:      aws.String(key),
+		UploadId: upload.UploadId,
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return "", nil
+		}
+		for _, part := range page.Parts {
+			number := aws.ToInt32(part.PartNumber)
+			if number < 1 || number > partCount {
+				continue
+			}
+			offset, length := partRange(number)
+			if aws.ToInt64(part.Size) != length {
+				continue
+			}
+			hash := md5.New()
+			if _, err := io.Copy(hash, io.NewSectionReader(file, offset, length)); err != nil {
+				continue
+			}
+			if strings.Trim(aws.ToString(part.ETag), `"`) == hex.EncodeToString(hash.Sum(nil)) {
+				completed[number] = types.CompletedPart{ETag: part.ETag, PartNumber: aws.Int32(number)}
+			}
+		}
+	}
+	return aws.ToString(upload.UploadId), completed
+}
@@ -0,0 +1,309 @@
+package services
+
+import (
+	"cmp"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"mcphub/models"
+)
+
+// SandboxProfiles lists the named profiles, from most to least restrictive
+var SandboxProfiles = []string{"strict", "default", "permissive"}
+
+// SandboxNetworks lists the supported network modes. The allowlist network only reaches the allowed
+// hosts, through an egress proxy (see StartEgress).
+var SandboxNetworks = []string{"none", "bridge", "allowlist"}
+
+// SandboxOptions is a fully resolved sandbox for one container
+type SandboxOptions struct {
+	Profile         string
+	Memory          string
+	CPUs            string
+	PidsLimit       int
+	Network         string
+	AllowHosts      []string
+	Tmpfs           []string
+	Volumes         []models.VolumeMount
+	ReadOnlyRootfs  bool
+	DropCaps        bool
+	NoNewPrivileges bool
+}
+
+var sandboxProfiles = map[string]SandboxOptions{
+	"strict": {
+		Memory:          "512m",
+		CPUs:            "1",
+		PidsLimit:       128,
+		Network:         "none",
+		Tmpfs:           []string{"/tmp"},
+		ReadOnlyRootfs:  true,
+		DropCaps:        true,
+		NoNewPrivileges: true,
+	},
+	"default": {
+		Memory:          "2g",
+		PidsLimit:       512,
+		Network:         "bridge",
+		NoNewPrivileges: true,
+	},
+	"permissive": {
+		Network: "bridge",
+	},
+}
+
+var memoryPattern = regexp.MustCompile(`^[0-9]+[bkmgBKMG]?$`)
+
+var allowHostPattern = regexp.MustCompile(`^(\*\.)?[a-z0-9]([a-z0-9-]*[a-z0-9])?(\.[a-z0-9]([a-z0-9-]*[a-z0-9])?)*$`)
+
+// ResolveSandbox layers the named profile, then the sandbox section of mcp.json, then command-line overrides.
+// Either config may be nil. The image's mcp.json is not trusted to widen its own sandbox: it cannot
+// declare volumes, which would let it pick host paths, nor ask for a profile, network, limits or
+// tmpfs mounts looser than the profile's unless the overrides ask for them too.
+func ResolveSandbox(declared, overrides *models.SandboxConfig) (*SandboxOptions, error) {
+	if err := checkDeclaredSandbox(declared, overrides); err != nil {
+		return nil, err
+	}
+	layers := []*models.SandboxConfig{declared, overrides}
+
+	profile := "default"
+	for _, layer := range layers {
+		if layer != nil && layer.Profile != "" {
+			profile = layer.Profile
+		}
+	}
+	base, ok := sandboxProfiles[profile]
+	if !ok {
+		return nil, fmt.Errorf("unknown sandbox profile %q. Use: %s", profile, strings.Join(SandboxProfiles, ", "))
+	}
+
+	opts := base
+	opts.Profile = profile
+	opts.Tmpfs = slices.Clone(base.Tmpfs)
+
+	for _, layer := range layers {
+		if layer == nil {
+			continue
+		}
+		if layer.Memory != "" {
+			opts.Memory = layer.Memory
+		}
+		if layer.CPUs != "" {
+			opts.CPUs = layer.CPUs
+		}
+		if layer.PidsLimit != 0 {
+			opts.PidsLimit = layer.PidsLimit
+		}
+		if layer.Network != "" {
+			opts.Network = layer.Network
+		}
+		// Hosts the user allows replace the ones the image asks for
+		if len(layer.AllowHosts) > 0 {
+			opts.AllowHosts = slices.Clone(layer.AllowHosts)
+		}
+		opts.Tmpfs = appendUnique(opts.Tmpfs, layer.Tmpfs...)
+		opts.Volumes = append(opts.Volumes, layer.Volumes...)
+	}
+
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	return &opts, nil
+}
+
+// checkDeclaredSandbox refuses the parts of an image's declared sandbox that would grant it more
+// than the default profile does without the user asking for it
+func checkDeclaredSandbox(declared, overrides *models.SandboxConfig) error {
+	if declared == nil {
+		return nil
+	}
+	if len(declared.Volumes) > 0 {
+		return fmt.Errorf("mcp.json may not declare sandbox volumes; declare mounts so that the user chooses the host paths")
+	}
+	if overrides == nil {
+		overrides = &models.SandboxConfig{}
+	}
+	if overrides.Profile == "" && slices.Index(SandboxProfiles, declared.Profile) > slices.Index(SandboxProfiles, "default") {
+		return fmt.Errorf("mcp.json asks for the %s sandbox profile, which must be chosen with --profile", declared.Profile)
+	}
+	name := cmp.Or(overrides.Profile, declared.Profile, "default")
+	profile := sandboxProfiles[name]
+	if overrides.Network == "" && declared.Network != "" && declared.Network != "none" {
+		if profile.Network == "none" {
+			return fmt.Errorf("mcp.json asks for the %s network, which must be chosen with --network", declared.Network)
+		}
+	}
+
+	if overrides.Memory == "" && profile.Memory != "" && declared.Memory != "" {
+		limit, _ := ParseSize(profile.Memory)
+		if memory, err := ParseSize(declared.Memory); err == nil && memory > limit {
+			return fmt.Errorf("mcp.json asks for %s of memory, more than the %s profile's %s; give it with --memory", declared.Memory, name, profile.Memory)
+		}
+	}
+	if overrides.CPUs == "" && profile.CPUs != "" && declared.CPUs != "" {
+		limit, _ := strconv.ParseFloat(profile.CPUs, 64)
+		if cpus, err := strconv.ParseFloat(declared.CPUs, 64); err == nil && cpus > limit {
+			return fmt.Errorf("mcp.json asks for %s CPUs, more than the %s profile's %s; give it with --cpus", declared.CPUs, name, profile.CPUs)
+		}
+	}
+	if overrides.PidsLimit == 0 && profile.PidsLimit > 0 && declared.PidsLimit > profile.PidsLimit {
+		return fmt.Errorf("mcp.json asks for %d processes, more than the %s profile's %d; give it with --pids-limit", declared.PidsLimit, name, profile.PidsLimit)
+	}
+	// A tmpfs is writable, so on a read-only rootfs each one must be in the profile or on the command line
+	if profile.ReadOnlyRootfs {
+		allowed := append(slices.Clone(profile.Tmpfs), overrides.Tmpfs...)
+		for _, tmpfs := range declared.Tmpfs {
+			path, _, _ := strings.Cut(tmpfs, ":")
+			if !slices.ContainsFunc(allowed, func(other string) bool { return strings.Split(other, ":")[0] == path }) {
+				return fmt.Errorf("mcp.json asks for a tmpfs at %s, which must be given with --tmpfs", path)
+			}
+		}
+	}
+	return nil
+}
+
+func (o *SandboxOptions) validate() error {
+	if o.Memory != "" && !memoryPattern.MatchString(o.Memory) {
+		return fmt.Errorf("invalid memory limit %q (e.g. 512m, 2g)", o.Memory)
+	}
+	if o.CPUs != "" {
+		cpus, err := strconv.ParseFloat(o.CPUs, 64)
+		if err != nil || cpus <= 0 {
+			return fmt.Errorf("invalid CPU limit %q (e.g. 0.5, 2)", o.CPUs)
+		}
+	}
+	if o.PidsLimit < 0 {
+		return fmt.Errorf("invalid PIDs limit %d", o.PidsLimit)
+	}
+	if !slices.Contains(SandboxNetworks, o.Network) {
+		return fmt.Errorf("unknown network mode %q. Use: %s", o.Network, strings.Join(SandboxNetworks, ", "))
+	}
+	if o.Network == "allowlist" && len(o.AllowHosts) == 0 {
+		return fmt.Errorf("the allowlist network needs at least one allowed host")
+	}
+	if o.Network != "allowlist" && len(o.AllowHosts) > 0 {
+		return fmt.Errorf("allowed hosts require the allowlist network mode")
+	}
+	for _, host := range o.AllowHosts {
+		if !allowHostPattern.MatchString(host) {
+			return fmt.Errorf("invalid allowed host %q (e.g. api.example.com, *.example.com)", host)
+		}
+	}
+	for _, tmpfs := range o.Tmpfs {
+		path, _, _ := strings.Cut(tmpfs, ":")
+		if !filepath.IsAbs(path) {
+			return fmt.Errorf("tmpfs path %q must be absolute", path)
+		}
+	}
+	for _, volume := range o.Volumes {
+		if !filepath.IsAbs(volume.Source) || !filepath.IsAbs(volume.Target) {
+			return fmt.Errorf("volume %s:%s must use absolute paths", volume.Source, volume.Target)
+		}
+	}
+	return nil
+}
+
+// ParseVolumeSpec parses /host/path:/container/path[:ro|rw]; volumes are read-only unless rw is given
+func ParseVolumeSpec(spec string) (models.VolumeMount, error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return models.VolumeMount{}, fmt.Errorf("invalid volume %q. Use: /host/path:/container/path[:rw]", spec)
+	}
+
+	volume := models.VolumeMount{Source: parts[0], Target: parts[1]}
+	if len(parts) == 3 {
+		switch parts[2] {
+		case "rw":
+			volume.Writable = true
+		case "ro":
+		default:
+			return models.VolumeMount{}, fmt.Errorf("invalid volume mode %q. Use: ro or rw", parts[2])
+		}
+	}
+
+	source, err := filepath.Abs(volume.Source)
+	if err != nil {
+		return models.VolumeMount{}, err
+	}
+	volume.Source = source
+	return volume, nil
+}
+
+// SandboxDockerArgs translates the sandbox into docker run flags
+func SandboxDockerArgs(opts *SandboxOptions) []string {
+	var args []string
+
+	if opts.Memory != "" {
+		args = append(args, "--memory", opts.Memory)
+	}
+	if opts.CPUs != "" {
+		args = append(args, "--cpus", opts.CPUs)
+	}
+	if opts.PidsLimit > 0 {
+		args = append(args, "--pids-limit", strconv.Itoa(opts.PidsLimit))
+	}
+
+	// The allowlist network is a network of the container's own, which its spec names
+	if opts.Network != "allowlist" {
+		args = append(args, "--network", opts.Network)
+	}
+
+	if opts.ReadOnlyRootfs {
+		args = append(args, "--read-only")
+	}
+	if opts.DropCaps {
+		args = append(args, "--cap-drop", "ALL")
+	}
+	if opts.NoNewPrivileges {
+		args = append(args, "--security-opt", "no-new-privileges")
+	}
+	for _, tmpfs := range opts.Tmpfs {
+		args = append(args, "--tmpfs", tmpfs)
+	}
+	for _, volume := range opts.Volumes {
+		mode := "ro"
+		if volume.Writable {
+			mode = "rw"
+		}
+		args = append(args, "-v", fmt.Sprintf("%s:%s:%s", volume.Source, volume.Target, mode))
+	}
+
+	return args
+}
+
+// Summary describes the sandbox in one line for command output
+func (o *SandboxOptions) Summary() string {
+	parts := []string{"network " + o.Network}
+	if len(o.AllowHosts) > 0 {
+		parts[0] += " to " + strings.Join(o.AllowHosts, " ")
+	}
+	if o.Memory != "" {
+		parts = append(parts, "memory "+o.Memory)
+	}
+	if o.CPUs != "" {
+		parts = append(parts, "cpus "+o.CPUs)
+	}
+	if o.PidsLimit > 0 {
+		parts = append(parts, fmt.Sprintf("pids %d", o.PidsLimit))
+	}
+	if o.ReadOnlyRootfs {
+		parts = append(parts, "read-only rootfs")
+	}
+	if len(o.Volumes) > 0 {
+		parts = append(parts, fmt.Sprintf("%d volumes", len(o.Volumes)))
+	}
+	return fmt.Sprintf("%s (%s)", o.Profile, strings.Join(parts, ", "))
+}
+
+func appendUnique(list []string, items ...string) []string {
+	for _, item := range items {
+		if !slices.Contains(list, item) {
+			list = append(list, item)
+		}
+	}
+	return list
+}
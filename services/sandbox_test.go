@@ -0,0 +1,124 @@
+package services
+
+import (
+	"testing"
+
+	"mcphub/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveSandbox(t *testing.T) {
+	t.Run("Defaults to the default profile", func(t *testing.T) {
+		opts, err := ResolveSandbox(nil, nil)
+		require.NoError(t, err)
+		assert.Equal(t, "default", opts.Profile)
+		assert.Equal(t, "bridge", opts.Network)
+		assert.True(t, opts.NoNewPrivileges)
+	})
+
+	t.Run("Flags override mcp.json which overrides the profile", func(t *testing.T) {
+		declared := &models.SandboxConfig{Profile: "strict", Memory: "256m", Tmpfs: []string{"/cache"}}
+		overrides := &models.SandboxConfig{CPUs: "0.5", Tmpfs: []string{"/cache"}}
+
+		opts, err := ResolveSandbox(declared, overrides)
+		require.NoError(t, err)
+		assert.Equal(t, "256m", opts.Memory)
+		assert.Equal(t, "0.5", opts.CPUs)
+		assert.Equal(t, 128, opts.PidsLimit)
+		assert.Equal(t, []string{"/tmp", "/cache"}, opts.Tmpfs)
+
+		args := SandboxDockerArgs(opts)
+		assert.Equal(t, []string{
+			"--memory", "256m", "--cpus", "0.5", "--pids-limit", "128",
+			"--network", "none",
+			"--read-only", "--cap-drop", "ALL", "--security-opt", "no-new-privileges",
+			"--tmpfs", "/tmp", "--tmpfs", "/cache",
+		}, args)
+	})
+
+	t.Run("The image cannot widen its own sandbox", func(t *testing.T) {
+		volumes := &models.SandboxConfig{Volumes: []models.VolumeMount{{Source: "/", Target: "/host", Writable: true}}}
+		_, err := ResolveSandbox(volumes, nil)
+		assert.ErrorContains(t, err, "may not declare sandbox volumes")
+
+		_, err = ResolveSandbox(&models.SandboxConfig{Profile: "permissive"}, nil)
+		assert.ErrorContains(t, err, "--profile")
+		_, err = ResolveSandbox(&models.SandboxConfig{Profile: "strict", Network: "bridge"}, nil)
+		assert.ErrorContains(t, err, "--network")
+
+		// Nor loosen the profile's limits
+		_, err = ResolveSandbox(&models.SandboxConfig{Memory: "64g"}, nil)
+		assert.ErrorContains(t, err, "--memory")
+		_, err = ResolveSandbox(&models.SandboxConfig{Profile: "strict", CPUs: "8"}, nil)
+		assert.ErrorContains(t, err, "--cpus")
+		_, err = ResolveSandbox(&models.SandboxConfig{PidsLimit: 100000}, nil)
+		assert.ErrorContains(t, err, "--pids-limit")
+		_, err = ResolveSandbox(&models.SandboxConfig{Profile: "strict", Tmpfs: []string{"/cache:size=8g"}}, nil)
+		assert.ErrorContains(t, err, "--tmpfs")
+		// Limits tighter than the profile's, or on a profile without them, are kept
+		opts, err := ResolveSandbox(&models.SandboxConfig{Memory: "1g", PidsLimit: 64, Tmpfs: []string{"/tmp"}}, nil)
+		require.NoError(t, err)
+		assert.Equal(t, "1g", opts.Memory)
+		assert.Equal(t, 64, opts.PidsLimit)
+		_, err = ResolveSandbox(&models.SandboxConfig{Profile: "strict", Tmpfs: []string{"/tmp:size=64m"}}, nil)
+		require.NoError(t, err)
+		_, err = ResolveSandbox(&models.SandboxConfig{Profile: "permissive", Memory: "64g"}, &models.SandboxConfig{Profile: "permissive"})
+		require.NoError(t, err)
+
+		// The user may choose them
+		_, err = ResolveSandbox(&models.SandboxConfig{Memory: "64g", PidsLimit: 100000}, &models.SandboxConfig{Memory: "8g", PidsLimit: 1024})
+		require.NoError(t, err)
+		opts, err = ResolveSandbox(&models.SandboxConfig{Profile: "permissive"}, &models.SandboxConfig{Profile: "permissive"})
+		require.NoError(t, err)
+		assert.Equal(t, "permissive", opts.Profile)
+		opts, err = ResolveSandbox(&models.SandboxConfig{Profile: "strict"}, &models.SandboxConfig{Network: "bridge"})
+		require.NoError(t, err)
+		assert.Equal(t, "bridge", opts.Network)
+	})
+
+	t.Run("The allowlist network names its hosts", func(t *testing.T) {
+		declared := &models.SandboxConfig{Network: "allowlist", AllowHosts: []string{"api.example.com"}}
+		opts, err := ResolveSandbox(declared, nil)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"api.example.com"}, opts.AllowHosts)
+		assert.NotContains(t, SandboxDockerArgs(opts), "--network")
+
+		// Hosts the user allows replace the image's
+		opts, err = ResolveSandbox(declared, &models.SandboxConfig{AllowHosts: []string{"*.example.org"}})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"*.example.org"}, opts.AllowHosts)
+
+		_, err = ResolveSandbox(nil, &models.SandboxConfig{AllowHosts: []string{"api.example.com"}})
+		assert.ErrorContains(t, err, "allowlist")
+		_, err = ResolveSandbox(nil, &models.SandboxConfig{Network: "allowlist", AllowHosts: []string{"http://api.example.com"}})
+		assert.ErrorContains(t, err, "invalid allowed host")
+		_, err = ResolveSandbox(&models.SandboxConfig{Profile: "strict", Network: "allowlist", AllowHosts: []string{"api.example.com"}}, nil)
+		assert.ErrorContains(t, err, "--network")
+	})
+
+	t.Run("Invalid settings are rejected", func(t *testing.T) {
+		_, err := ResolveSandbox(&models.SandboxConfig{Profile: "yolo"}, nil)
+		assert.Error(t, err)
+		_, err = ResolveSandbox(nil, &models.SandboxConfig{Memory: "lots"})
+		assert.Error(t, err)
+		_, err = ResolveSandbox(nil, &models.SandboxConfig{Network: "allowlist"})
+		assert.Error(t, err)
+	})
+}
+
+func TestParseVolumeSpec(t *testing.T) {
+	volume, err := ParseVolumeSpec("/data:/srv/data")
+	require.NoError(t, err)
+	assert.Equal(t, models.VolumeMount{Source: "/data", Target: "/srv/data"}, volume)
+
+	volume, err = ParseVolumeSpec("/data:/srv/data:rw")
+	require.NoError(t, err)
+	assert.True(t, volume.Writable)
+
+	_, err = ParseVolumeSpec("/data")
+	assert.Error(t, err)
+	_, err = ParseVolumeSpec("/data:/srv:rx")
+	assert.Error(t, err)
+}
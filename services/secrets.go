@@ -0,0 +1,242 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	secretsFileVersion = 1
+	// scryptCost is the scrypt N parameter passphrases are stretched with
+	scryptCost = 1 << 17
+)
+
+// ErrWrongSecretsKey is returned when the store cannot be decrypted with the given passphrase or key file
+var ErrWrongSecretsKey = errors.New("wrong passphrase or key file for secrets store")
+
+// SecretsKey unlocks the secrets store with either a passphrase or the contents of a key file
+type SecretsKey struct {
+	Passphrase []byte
+	KeyFile    []byte
+}
+
+// secretsFile is the on-disk envelope; everything but the KDF parameters is encrypted. Changing
+// the parameters changes the derived key, so the box no longer opens.
+type secretsFile struct {
+	Version    int    `json:"version"`
+	KDF        string `json:"kdf"`
+	Cost       int    `json:"cost,omitempty"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// SecretsStore holds per-server secrets sealed with NaCl secretbox
+type SecretsStore struct {
+	path    string
+	key     SecretsKey
+	secrets map[string]map[string]string
+}
+
+// DefaultSecretsPath returns the secrets file under the user config directory
+func DefaultSecretsPath() (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find user config directory: %w", err)
+	}
+	return filepath.Join(configDir, "mcphub", "secrets.enc"), nil
+}
+
+// SecretsScope normalises an author/name reference into the key secrets are stored under
+func SecretsScope(ref string) string {
+	return strings.ToLower(ref)
+}
+
+// OpenSecretsStore decrypts the store at path, or returns an empty store if it does not exist yet
+func OpenSecretsStore(path string, key SecretsKey) (*SecretsStore, error) {
+	if len(key.Passphrase) == 0 && len(key.KeyFile) == 0 {
+		return nil, fmt.Errorf("a passphrase or key file is required to open the secrets store")
+	}
+
+	store := &SecretsStore{path: path, key: key, secrets: make(map[string]map[string]string)}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secrets store: %w", err)
+	}
+
+	var envelope secretsFile
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return nil, fmt.Errorf("secrets store is corrupted: %w", err)
+	}
+	if envelope.Version != secretsFileVersion {
+		return nil, fmt.Errorf("unsupported secrets store version %d", envelope.Version)
+	}
+
+	secretKey, err := deriveSecretsKey(key, envelope.KDF, envelope.Salt, envelope.Cost)
+	if err != nil {
+		return nil, err
+	}
+	var nonce [24]byte
+	if copy(nonce[:], envelope.Nonce) != len(nonce) {
+		return nil, fmt.Errorf("secrets store is corrupted: invalid nonce")
+	}
+	plaintext, ok := secretbox.Open(nil, envelope.Ciphertext, &nonce, secretKey)
+	if !ok {
+		return nil, ErrWrongSecretsKey
+	}
+
+	if err := json.Unmarshal(plaintext, &store.secrets); err != nil {
+		return nil, fmt.Errorf("secrets store is corrupted: %w", err)
+	}
+	return store, nil
+}
+
+// Get returns a secret of a server
+func (s *SecretsStore) Get(scope, name string) (string, bool) {
+	value, ok := s.secrets[SecretsScope(scope)][name]
+	return value, ok
+}
+
+// Values returns a copy of every secret stored for a server
+func (s *SecretsStore) Values(scope string) map[string]string {
+	values := make(map[string]string)
+	for name, value := range s.secrets[SecretsScope(scope)] {
+		values[name] = value
+	}
+	return values
+}
+
+// Set stores a secret of a server; call Save to persist it
+func (s *SecretsStore) Set(scope, name, value string) error {
+	if !envNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid secret name %q", name)
+	}
+	scope = SecretsScope(scope)
+	if s.secrets[scope] == nil {
+		s.secrets[scope] = make(map[string]string)
+	}
+	s.secrets[scope][name] = value
+	return nil
+}
+
+// Remove deletes a secret of a server, reporting whether it existed; call Save to persist it
+func (s *SecretsStore) Remove(scope, name string) bool {
+	scope = SecretsScope(scope)
+	if _, ok := s.secrets[scope][name]; !ok {
+		return false
+	}
+	delete(s.secrets[scope], name)
+	if len(s.secrets[scope]) == 0 {
+		delete(s.secrets, scope)
+	}
+	return true
+}
+
+// List returns the secret names stored per server, sorted
+func (s *SecretsStore) List() map[string][]string {
+	names := make(map[string][]string)
+	for scope, secrets := range s.secrets {
+		for name := range secrets {
+			names[scope] = append(names[scope], name)
+		}
+		sort.Strings(names[scope])
+	}
+	return names
+}
+
+// Save re-encrypts the store with a fresh salt and nonce and atomically replaces the file
+func (s *SecretsStore) Save() error {
+	plaintext, err := json.Marshal(s.secrets)
+	if err != nil {
+		return err
+	}
+
+	envelope := secretsFile{Version: secretsFileVersion, KDF: "keyfile-hkdf-sha256", Salt: make([]byte, 32)}
+	if len(s.key.KeyFile) == 0 {
+		envelope.KDF = "scrypt"
+		envelope.Cost = scryptCost
+	}
+	if _, err := rand.Read(envelope.Salt); err != nil {
+		return err
+	}
+
+	secretKey, err := deriveSecretsKey(s.key, envelope.KDF, envelope.Salt, envelope.Cost)
+	if err != nil {
+		return err
+	}
+	var nonce [24]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return err
+	}
+	envelope.Nonce = nonce[:]
+	envelope.Ciphertext = secretbox.Seal(nil, plaintext, &nonce, secretKey)
+
+	data, err := json.MarshalIndent(envelope, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return fmt.Errorf("failed to create secrets directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".secrets-*")
+	if err != nil {
+		return fmt.Errorf("failed to write secrets store: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write secrets store: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write secrets store: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to write secrets store: %w", err)
+	}
+	return nil
+}
+
+// deriveSecretsKey derives the secretbox key from the passphrase, with scrypt, or from the key
+// file, with HKDF-SHA256
+func deriveSecretsKey(key SecretsKey, kdf string, salt []byte, cost int) (*[32]byte, error) {
+	var derived [32]byte
+	switch kdf {
+	case "scrypt":
+		if len(key.Passphrase) == 0 {
+			return nil, fmt.Errorf("secrets store is protected by a passphrase")
+		}
+		out, err := scrypt.Key(key.Passphrase, salt, cost, 8, 1, len(derived))
+		if err != nil {
+			return nil, fmt.Errorf("secrets store is corrupted: %w", err)
+		}
+		copy(derived[:], out)
+	case "keyfile-hkdf-sha256":
+		if len(key.KeyFile) == 0 {
+			return nil, fmt.Errorf("secrets store is protected by a key file")
+		}
+		if _, err := io.ReadFull(hkdf.New(sha256.New, key.KeyFile, salt, []byte("mcphub-secrets")), derived[:]); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported key derivation %q", kdf)
+	}
+	return &derived, nil
+}
@@ -0,0 +1,61 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecretsStore(t *testing.T) {
+	t.Run("Round trip with passphrase", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "secrets.enc")
+		key := SecretsKey{Passphrase: []byte("correct horse")}
+
+		store, err := OpenSecretsStore(path, key)
+		require.NoError(t, err)
+		require.NoError(t, store.Set("Alice/Weather", "API_KEY", "s3cret"))
+		require.NoError(t, store.Save())
+
+		reopened, err := OpenSecretsStore(path, key)
+		require.NoError(t, err)
+		value, ok := reopened.Get("alice/weather", "API_KEY")
+		assert.True(t, ok)
+		assert.Equal(t, "s3cret", value)
+		assert.Equal(t, map[string][]string{"alice/weather": {"API_KEY"}}, reopened.List())
+
+		_, err = OpenSecretsStore(path, SecretsKey{Passphrase: []byte("wrong")})
+		assert.ErrorIs(t, err, ErrWrongSecretsKey)
+		_, err = OpenSecretsStore(path, SecretsKey{KeyFile: []byte("not the passphrase")})
+		assert.Error(t, err)
+
+		// Lowering the key derivation cost stored beside the ciphertext does not open it faster
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.Contains(t, string(data), `"cost": 131072`)
+		weakened := strings.Replace(string(data), `"cost": 131072`, `"cost": 2`, 1)
+		require.NoError(t, os.WriteFile(path, []byte(weakened), 0600))
+		_, err = OpenSecretsStore(path, key)
+		assert.ErrorIs(t, err, ErrWrongSecretsKey)
+	})
+
+	t.Run("Key file and removal", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "secrets.enc")
+		key := SecretsKey{KeyFile: []byte("0123456789abcdef0123456789abcdef")}
+
+		store, err := OpenSecretsStore(path, key)
+		require.NoError(t, err)
+		require.NoError(t, store.Set("alice/weather", "API_KEY", "v"))
+		assert.Error(t, store.Set("alice/weather", "bad-name", "v"))
+		assert.True(t, store.Remove("alice/weather", "API_KEY"))
+		assert.False(t, store.Remove("alice/weather", "API_KEY"))
+		require.NoError(t, store.Save())
+
+		reopened, err := OpenSecretsStore(path, key)
+		require.NoError(t, err)
+		assert.Empty(t, reopened.List())
+	})
+}
@@ -50,4 +50,37 @@ func TestDockerfileGenerator_Generate(t *testing.T) {
 		assert.Contains(t, output, `CMD ["node", "server.js"]`)
 		assert.Contains(t, output, "npm install")
 	})
+
+	t.Run("Embeds config label", func(t *testing.T) {
+		config := models.MCPConfig{
+			Name:    "labelled",
+			Version: "2.0.0",
+			Run:     models.RunConfig{Command: "node", Args: []string{"index.js"}},
+		}
+
+		output := generator.Generate(&config)
+		assert.Contains(t, output, "LABEL mcphub.config=\""+EncodeConfigLabel(&config)+"\"")
+
+		decoded, err := DecodeConfigLabel(EncodeConfigLabel(&config))
+		assert.NoError(t, err)
+		assert.Equal(t, config, *decoded)
+	})
+}
+
+func TestMetadataMatches(t *testing.T) {
+	metadata := &models.ServerMetadata{
+		Config: models.MCPConfig{
+			Name:        "weather",
+			Description: "Weather forecasts",
+			Keywords:    []string{"climate"},
+		},
+		Inspection: &models.ServerInspection{
+			Tools: []models.Tool{{Name: "get_forecast", Description: "Forecast for a city"}},
+		},
+	}
+
+	assert.True(t, metadataMatches(metadata, "weather"))
+	assert.True(t, metadataMatches(metadata, "climate"))
+	assert.True(t, metadataMatches(metadata, "get_forecast"))
+	assert.False(t, metadataMatches(metadata, "calendar"))
 }
@@ -0,0 +1,79 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ParseToolArguments merges a JSON object with key=value pairs into tool arguments.
+// Pair values are converted using the property types in the tool's input schema;
+// values of untyped properties are parsed as JSON when possible and kept as strings otherwise.
+func ParseToolArguments(inputSchema json.RawMessage, jsonArgs string, pairs []string) (map[string]any, error) {
+	arguments := make(map[string]any)
+	if jsonArgs != "" {
+		var parsed any
+		if err := json.Unmarshal([]byte(jsonArgs), &parsed); err != nil {
+			return nil, fmt.Errorf("invalid --json arguments: %w", err)
+		}
+		object, ok := parsed.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("invalid --json arguments: expected a JSON object")
+		}
+		arguments = object
+	}
+
+	var schema struct {
+		Properties map[string]struct {
+			Type any `json:"type"`
+		} `json:"properties"`
+	}
+	if len(inputSchema) > 0 {
+		json.Unmarshal(inputSchema, &schema)
+	}
+
+	for _, pair := range pairs {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid argument %q. Use: key=value", pair)
+		}
+
+		// A property may declare a single type or a list of types
+		var types []string
+		switch t := schema.Properties[key].Type.(type) {
+		case string:
+			types = []string{t}
+		case []any:
+			for _, item := range t {
+				if s, ok := item.(string); ok {
+					types = append(types, s)
+				}
+			}
+		}
+
+		converted, err := convertArgument(value, types)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for argument %q: %w", key, err)
+		}
+		arguments[key] = converted
+	}
+
+	return arguments, nil
+}
+
+func convertArgument(value string, types []string) (any, error) {
+	for _, t := range types {
+		if t == "string" {
+			return value, nil
+		}
+	}
+
+	var parsed any
+	if err := json.Unmarshal([]byte(value), &parsed); err != nil {
+		if len(types) > 0 {
+			return nil, fmt.Errorf("expected %s", strings.Join(types, " or "))
+		}
+		return value, nil
+	}
+	return parsed, nil
+}
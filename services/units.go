@@ -0,0 +1,44 @@
+package services
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseSize converts a size such as 512m or 2g into bytes
+func ParseSize(value string) (int64, error) {
+	if !memoryPattern.MatchString(value) {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	multiplier := int64(1)
+	switch strings.ToLower(value[len(value)-1:]) {
+	case "k":
+		multiplier = 1 << 10
+	case "m":
+		multiplier = 1 << 20
+	case "g":
+		multiplier = 1 << 30
+	}
+	number := strings.TrimRight(value, "bkmgBKMG")
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return n * multiplier, nil
+}
+
+// FormatBytes renders a byte count for people, e.g. 12.3 MB
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	value, suffix := float64(n)/unit, "KMGTPE"
+	i := 0
+	for value >= unit && i < len(suffix)-1 {
+		value /= unit
+		i++
+	}
+	return fmt.Sprintf("%.1f %cB", value, suffix[i])
+}
@@ -3,40 +3,96 @@ package services
 import (
 	"archive/zip"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"strings"
+	"time"
 
 	"mcphub/models"
 )
 
 type ZipProcessor struct {
 	dockerfileGenerator *DockerfileGenerator
+	engine              ContainerEngine
+
+	// SkipProbe disables starting the built image to record its MCP capabilities
+	SkipProbe bool
+
+	// RunConformance runs the MCP conformance suite against the built image and fails on any failed check
+	RunConformance bool
+
+	// OCIBuilder, when set, assembles the image in Go instead of with the container engine.
+	// Such images are not started, so they are neither probed nor conformance tested.
+	OCIBuilder *OCIBuilder
+
+	// WorkDir is the directory each zip gets its own work directory in, holding the extracted
+	// sources and the image archive; empty means the system temp directory
+	WorkDir string
+
+	// KeepWorkDir leaves work directories in place for debugging instead of removing them
+	KeepWorkDir bool
+
+	// BuildCache, when set, installs dependencies in an image of their own that later pushes with
+	// the same dependency manifests build on
+	BuildCache *BuildCache
+
+	// BuildLogs, when set, receives the output of image builds as they run
+	BuildLogs io.Writer
+
+	// Platforms, when set, replaces the platforms listed in mcp.json. Each platform gets an image of
+	// its own; with none, one image is built for the engine's own platform.
+	Platforms []string
 }
 
-func NewZipProcessor() *ZipProcessor {
+func NewZipProcessor(engine ContainerEngine) *ZipProcessor {
 	return &ZipProcessor{
 		dockerfileGenerator: NewDockerfileGenerator(),
+		engine:              engine,
 	}
 }
 
 // ProcessZip accepts zip data and filename, extracts contents, generates Dockerfile, builds and saves the image.
-func (zp *ZipProcessor) ProcessZip(zipData []byte, zipFileName string) (*models.DockerfileResponse, error) {
+// Each zip is processed in a work directory of its own, so zips with the same name can be processed
+// at once. The work directory is removed when processing fails; on success the caller removes it
+// with Cleanup once it is done with the image archive. When ctx is cancelled, the build, probe and
+// save stop and the half-built image is removed from the engine as well.
+func (zp *ZipProcessor) ProcessZip(ctx context.Context, zipData []byte, zipFileName string) (result *models.DockerfileResponse, err error) {
+	if zp.OCIBuilder != nil && zp.RunConformance {
+		return nil, fmt.Errorf("conformance tests need a container engine and cannot run with the daemonless builder")
+	}
+
+	timer := newStepTimer()
+
 	// Load zip archive from byte slice
 	reader, err := zip.NewReader(bytes.NewReader(zipData), int64(len(zipData)))
 	if err != nil {
 		return nil, fmt.Errorf("failed to read zip file: %w", err)
 	}
 
-	// Prepare extraction directory, cleaning if it exists
-	extractDir := filepath.Join("extracted", strings.TrimSuffix(zipFileName, ".zip"))
-	if err := os.RemoveAll(extractDir); err != nil {
-		return nil, fmt.Errorf("failed to clean extraction directory: %w", err)
+	// Prepare the work directory, removing it again if anything below fails
+	baseName := strings.TrimSuffix(zipFileName, ".zip")
+	workDir, err := zp.createWorkDir(baseName)
+	if err != nil {
+		return nil, err
 	}
+	defer func() {
+		if err == nil {
+			return
+		}
+		if zp.KeepWorkDir {
+			err = fmt.Errorf("%w (work directory kept at %s)", err, workDir)
+		} else {
+			os.RemoveAll(workDir)
+		}
+	}()
+
+	extractDir := filepath.Join(workDir, "src")
 	if err := os.MkdirAll(extractDir, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create extraction directory: %w", err)
 	}
@@ -45,6 +101,7 @@ func (zp *ZipProcessor) ProcessZip(zipData []byte, zipFileName string) (*models.
 	if err := zp.extractZip(reader, extractDir); err != nil {
 		return nil, fmt.Errorf("failed to extract zip contents: %w", err)
 	}
+	timer.done("extract")
 
 	// Locate and parse mcp.json file (configuration)
 	mcpConfig, mcpDir, err := zp.findAndParseMCPConfigFromDir(extractDir)
@@ -60,43 +117,157 @@ func (zp *ZipProcessor) ProcessZip(zipData []byte, zipFileName string) (*models.
 	if err := os.WriteFile(dockerfilePath, []byte(dockerfileContent), 0644); err != nil {
 		return nil, fmt.Errorf("failed to write Dockerfile: %w", err)
 	}
+	if err := ignoreGeneratedDockerfile(mcpDir); err != nil {
+		return nil, fmt.Errorf("failed to update .dockerignore: %w", err)
+	}
+	timer.done("generate")
 
-	// Build Docker image
+	// Build an image per platform (the daemonless builder assembles them when saving instead).
+	// The engine builds under tags of this push's own, so that pushes running at once never save
+	// each other's image, and they are removed once the images are saved. The image for the
+	// engine's platform is then tagged with the image name, for mcphub run.
 	imageName := strings.ToLower(mcpConfig.Name)
-	if err := zp.buildDockerImage(mcpDir, imageName); err != nil {
+	if len(zp.Platforms) > 0 {
+		mcpConfig.Platforms = zp.Platforms
+	}
+	targets, err := zp.imageTargets(ctx, mcpConfig.Platforms, workDir, baseName, imageName, buildTag(mcpConfig, workDir))
+	if err != nil {
 		return nil, err
 	}
+	var buildCache []models.BuildCacheResult
+	if zp.OCIBuilder == nil {
+		for _, target := range targets {
+			defer zp.removeImage(target.tag)
+			cached, err := zp.buildDockerImage(ctx, mcpConfig, mcpDir, workDir, target)
+			if err != nil {
+				return nil, err
+			}
+			if cached != nil {
+				buildCache = append(buildCache, *cached)
+			}
+		}
+		timer.done("build")
+	}
 
-	// Create temp directory for tar file
-	tempDir, err := os.MkdirTemp("", "mcphub-*")
-	if err != nil {
-		return nil, fmt.Errorf("failed to create temp directory: %w", err)
+	// Start the image built for the engine's platform and record its tools, resources and prompts
+	var warnings []string
+	native := slices.IndexFunc(targets, func(target imageTarget) bool { return target.name == imageName })
+	canRun := zp.OCIBuilder == nil && native >= 0
+	if zp.OCIBuilder == nil && native < 0 {
+		if zp.RunConformance {
+			return nil, fmt.Errorf("conformance tests need an image for the container engine's own platform; add it to the platforms")
+		}
+		if !zp.SkipProbe {
+			warnings = append(warnings, "no image was built for the container engine's own platform, so capabilities were not recorded")
+		}
+	}
+	var inspection *models.ServerInspection
+	if !zp.SkipProbe && canRun {
+		inspection, err = ProbeServer(ctx, zp.engine, mcpConfig, targets[native].tag)
+		if err != nil {
+			return nil, fmt.Errorf("MCP server failed capability probe: %w", err)
+		}
+		timer.done("probe")
 	}
-	defer os.RemoveAll(tempDir) // Clean up temp directory when done
 
-	// Save Docker image as tar archive in temp directory
-	tarFileName := strings.TrimSuffix(zipFileName, ".zip") + ".tar"
-	tarFilePath := filepath.Join(tempDir, tarFileName)
-	if err := zp.saveDockerImage(imageName, tarFilePath); err != nil {
-		return nil, err
+	// Gate publishing on protocol conformance
+	var conformance *models.ConformanceReport
+	if zp.RunConformance && canRun {
+		if conformance, err = zp.runConformance(ctx, mcpConfig, targets[native].tag); err != nil {
+			return nil, err
+		}
+		timer.done("conformance")
 	}
 
+	// Save each image as a tar archive in the work directory, which is where the daemonless
+	// builder builds them
+	for _, target := range targets {
+		if zp.OCIBuilder != nil {
+			if err := zp.buildOCIImage(ctx, mcpConfig, mcpDir, imageName, target.platform, target.tarPath); err != nil {
+				return nil, err
+			}
+		} else if err := zp.saveDockerImage(ctx, target.tag, target.tarPath); err != nil {
+			return nil, err
+		}
+	}
+	if zp.OCIBuilder != nil {
+		timer.done("build")
+	} else {
+		if native >= 0 {
+			if err := zp.engine.TagImage(ctx, targets[native].tag, imageName); err != nil {
+				return nil, fmt.Errorf("failed to tag image %s: %w", imageName, err)
+			}
+		}
+		timer.done("save")
+	}
+	if zp.OCIBuilder != nil {
+		for _, name := range UninstalledDependencies(mcpDir) {
+			warnings = append(warnings, fmt.Sprintf("%s was copied but its dependencies were not installed; vendor them or use a base image that has them", name))
+		}
+	}
+
+	// The image for the engine's platform is the one run locally, so it comes first
+	if native > 0 {
+		targets[0], targets[native] = targets[native], targets[0]
+	}
+	var images []models.PlatformImage
+	if len(mcpConfig.Platforms) > 0 {
+		for _, target := range targets {
+			tarFilePath, _ := filepath.Abs(target.tarPath)
+			images = append(images, models.PlatformImage{Platform: target.platform, ImageName: target.name, TarFilePath: tarFilePath})
+		}
+	}
+	tarFileName := filepath.Base(targets[0].tarPath)
+	tarFilePath := targets[0].tarPath
+
 	// Return absolute paths in response
+	absWorkDir, _ := filepath.Abs(workDir)
 	absExtractDir, _ := filepath.Abs(extractDir)
 	absDockerfilePath, _ := filepath.Abs(dockerfilePath)
 	absTarFilePath, _ := filepath.Abs(tarFilePath)
 
 	return &models.DockerfileResponse{
+		WorkDir:        absWorkDir,
 		ExtractedPath:  absExtractDir,
 		DockerfilePath: absDockerfilePath,
 		ImageName:      imageName,
 		TarFilePath:    absTarFilePath,
+		Images:         images,
+		BuildCache:     buildCache,
+		Timings:        timer.timings,
 		Config:         *mcpConfig,
+		Inspection:     inspection,
+		Conformance:    conformance,
+		Warnings:       warnings,
 		Success:        true,
 		Message:        fmt.Sprintf("Successfully processed %s. Docker image saved as %s", zipFileName, tarFileName),
 	}, nil
 }
 
+// createWorkDir creates a fresh work directory for a zip under WorkDir
+func (zp *ZipProcessor) createWorkDir(baseName string) (string, error) {
+	root := zp.WorkDir
+	if root == "" {
+		root = os.TempDir()
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return "", fmt.Errorf("failed to create work directory root: %w", err)
+	}
+	workDir, err := os.MkdirTemp(root, "mcphub-"+strings.ReplaceAll(baseName, string(filepath.Separator), "_")+"-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create work directory: %w", err)
+	}
+	return workDir, nil
+}
+
+// Cleanup removes the work directory of a processed zip, unless KeepWorkDir is set
+func (zp *ZipProcessor) Cleanup(result *models.DockerfileResponse) error {
+	if zp.KeepWorkDir || result == nil || result.WorkDir == "" {
+		return nil
+	}
+	return os.RemoveAll(result.WorkDir)
+}
+
 // extractZip extracts files from the zip archive, flattening single-folder archives
 func (zp *ZipProcessor) extractZip(reader *zip.Reader, extractDir string) error {
 	var commonPrefix string
@@ -129,6 +300,10 @@ func (zp *ZipProcessor) extractZip(reader *zip.Reader, extractDir string) error
 			targetPath = strings.TrimPrefix(file.Name, commonPrefix)
 		}
 		filePath := filepath.Join(extractDir, targetPath)
+		// Work directories share a root, so an entry escaping this one could overwrite another push's sources
+		if !pathWithin(filePath, extractDir) || filePath == extractDir {
+			return fmt.Errorf("zip entry %s escapes the destination", file.Name)
+		}
 
 		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
 			return err
@@ -187,40 +362,264 @@ func (zp *ZipProcessor) findAndParseMCPConfigFromDir(extractDir string) (*models
 		return nil, "", fmt.Errorf("mcp.json not found")
 	}
 
-	content, err := os.ReadFile(mcpFilePath)
+	mcpConfig, err := ReadMCPConfig(mcpFilePath)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to read mcp.json: %w", err)
+		return nil, "", err
+	}
+	return mcpConfig, filepath.Dir(mcpFilePath), nil
+}
+
+// ReadMCPConfig reads and validates an mcp.json file
+func ReadMCPConfig(path string) (*models.MCPConfig, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mcp.json: %w", err)
 	}
 
 	var mcpConfig models.MCPConfig
 	if err := json.Unmarshal(content, &mcpConfig); err != nil {
-		return nil, "", fmt.Errorf("failed to parse mcp.json: %w", err)
+		return nil, fmt.Errorf("failed to parse mcp.json: %w", err)
 	}
 
 	if mcpConfig.Name == "" || mcpConfig.Run.Command == "" {
-		return nil, "", fmt.Errorf("mcp.json missing required fields 'name' or 'run.command'")
+		return nil, fmt.Errorf("mcp.json missing required fields 'name' or 'run.command'")
 	}
 
-	return &mcpConfig, filepath.Dir(mcpFilePath), nil
+	switch mcpConfig.Run.Transport {
+	case "", "stdio":
+	case "http":
+		if mcpConfig.Run.Port == 0 {
+			return nil, fmt.Errorf("mcp.json run.transport is http but no run.port is declared")
+		}
+	default:
+		return nil, fmt.Errorf("mcp.json run.transport must be stdio or http, not %q", mcpConfig.Run.Transport)
+	}
+
+	if err := ValidateEnvDeclarations(mcpConfig.Env); err != nil {
+		return nil, fmt.Errorf("invalid mcp.json env section: %w", err)
+	}
+
+	if err := ValidateMountDeclarations(mcpConfig.Mounts); err != nil {
+		return nil, fmt.Errorf("invalid mcp.json mounts section: %w", err)
+	}
+
+	return &mcpConfig, nil
+}
+
+// ConformanceError is returned when the built image fails the conformance gate
+type ConformanceError struct {
+	Report *models.ConformanceReport
 }
 
-// buildDockerImage builds a Docker image from the given context directory with the specified image name
-func (zp *ZipProcessor) buildDockerImage(buildContext, imageName string) error {
-	cmd := exec.Command("docker", "build", "-t", imageName, ".")
-	cmd.Dir = buildContext
-	output, err := cmd.CombinedOutput()
+func (e *ConformanceError) Error() string {
+	var failures []string
+	for _, result := range e.Report.Results {
+		if result.Status == models.CheckFailed {
+			failures = append(failures, fmt.Sprintf("  %s: %s", result.Name, result.Message))
+		}
+	}
+	return fmt.Sprintf("MCP server failed %d conformance checks:\n%s", e.Report.Failed, strings.Join(failures, "\n"))
+}
+
+// runConformance runs the conformance suite against the built image
+func (zp *ZipProcessor) runConformance(ctx context.Context, mcpConfig *models.MCPConfig, imageName string) (*models.ConformanceReport, error) {
+	launchCtx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	launcher, err := LaunchServer(launchCtx, zp.engine, mcpConfig, imageName)
 	if err != nil {
-		return fmt.Errorf("docker build failed: %w\nOutput: %s", err, output)
+		return nil, fmt.Errorf("failed to start MCP server for conformance tests: %w", err)
 	}
-	return nil
+	defer launcher.Stop()
+
+	report := RunConformanceSuite(ctx, imageName, launcher.Connect)
+	if err := ctx.Err(); err != nil {
+		// Checks cut short by cancellation are not conformance failures
+		return nil, err
+	}
+	if report.Failed > 0 {
+		return report, &ConformanceError{Report: report}
+	}
+
+	return report, nil
+}
+
+// imageTarget is an image a build makes: for platform, or for the engine's own when it is empty.
+// It is built under tag and known to users as name.
+type imageTarget struct {
+	platform string
+	name     string
+	tag      string
+	tarPath  string
+}
+
+// imageTargets lists the images to build: one for the engine's own platform, or one per platform
+// given. The image for the engine's platform is named imageName and tagged tag, as it is the one
+// probed and run; the others get :<os>-<arch> appended to both.
+func (zp *ZipProcessor) imageTargets(ctx context.Context, platforms []string, workDir, baseName, imageName, tag string) ([]imageTarget, error) {
+	if len(platforms) == 0 {
+		return []imageTarget{{name: imageName, tag: tag, tarPath: filepath.Join(workDir, baseName+".tar")}}, nil
+	}
+	if err := ValidatePlatforms(platforms); err != nil {
+		return nil, err
+	}
+
+	native := hostPlatform()
+	if zp.OCIBuilder == nil {
+		value, err := zp.engine.Platform(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find the container engine's platform: %w", err)
+		}
+		if native, err = parsePlatform(value); err != nil {
+			return nil, err
+		}
+	}
+
+	var targets []imageTarget
+	for _, value := range platforms {
+		platform, _ := parsePlatform(value)
+		suffix := strings.ReplaceAll(platform.String(), "/", "-")
+		target := imageTarget{
+			platform: platform.String(),
+			name:     imageName + ":" + suffix,
+			tag:      tag + "-" + suffix,
+			tarPath:  filepath.Join(workDir, baseName+"-"+suffix+".tar"),
+		}
+		if platform.matches(native) && !slices.ContainsFunc(targets, func(t imageTarget) bool { return t.name == imageName }) {
+			target.name, target.tag = imageName, tag
+		}
+		targets = append(targets, target)
+	}
+	return targets, nil
+}
+
+// buildTag returns the tag a push builds its image under in the engine: the server's author and
+// name, and the random suffix of the push's work directory
+func buildTag(config *models.MCPConfig, workDir string) string {
+	base := filepath.Base(workDir)
+	return "mcphub-build/" + serverRepository(config) + ":" + base[strings.LastIndex(base, "-")+1:]
+}
+
+// serverRepository returns "author/name" for a server, lower-cased and with runs of characters
+// image names cannot hold replaced by dashes
+func serverRepository(config *models.MCPConfig) string {
+	var components []string
+	for _, value := range []string{config.Author, config.Name} {
+		component := strings.Trim(repositoryUnsafe.ReplaceAllString(strings.ToLower(value), "-"), "-._")
+		if component != "" {
+			components = append(components, component)
+		}
+	}
+	if len(components) == 0 {
+		return "unnamed"
+	}
+	return strings.Join(components, "/")
+}
+
+var repositoryUnsafe = regexp.MustCompile(`[^a-z0-9._-]+|[._-]{2,}`)
+
+// buildDockerImage builds the image for target from the given context directory. With a build cache,
+// the image is built on the server's dependency image, which is built first when the dependency
+// manifests changed, and the returned result says which it was.
+func (zp *ZipProcessor) buildDockerImage(ctx context.Context, mcpConfig *models.MCPConfig, buildContext, workDir string, target imageTarget) (*models.BuildCacheResult, error) {
+	var deps *dependencyBuild
+	server := serverRepository(mcpConfig)
+	if zp.BuildCache != nil {
+		var err error
+		if deps, err = zp.BuildCache.dependencies(mcpConfig, buildContext, server, target.platform); err != nil {
+			return nil, fmt.Errorf("failed to read dependency manifests: %w", err)
+		}
+	}
+
+	var cached *models.BuildCacheResult
+	if deps != nil {
+		buildDir := filepath.Join(workDir, "deps")
+		if target.platform != "" {
+			buildDir += "-" + strings.ReplaceAll(target.platform, "/", "-")
+		}
+		hit, err := zp.BuildCache.prepare(ctx, zp.engine, mcpConfig, deps, buildContext, buildDir, target.platform, zp.BuildLogs)
+		if err != nil {
+			return nil, err
+		}
+		dockerfile := zp.dockerfileGenerator.GenerateOnDependencies(mcpConfig, deps.tag)
+		if err := os.WriteFile(filepath.Join(buildContext, "Dockerfile"), []byte(dockerfile), 0644); err != nil {
+			return nil, fmt.Errorf("failed to write Dockerfile: %w", err)
+		}
+		cached = &models.BuildCacheResult{Platform: target.platform, Image: deps.tag, Key: deps.key, Hit: hit}
+	}
+
+	if err := zp.engine.BuildImage(ctx, buildContext, target.tag, target.platform, zp.BuildLogs); err != nil {
+		return nil, fmt.Errorf("image build failed: %w", err)
+	}
+	if deps != nil {
+		// The state only serves to remove outdated dependency images, so failing to save it is harmless
+		zp.BuildCache.record(ctx, zp.engine, server, target.platform, deps.tag)
+	}
+	return cached, nil
+}
+
+// buildOCIImage assembles the image with the daemonless builder straight into an OCI layout tarball
+func (zp *ZipProcessor) buildOCIImage(ctx context.Context, mcpConfig *models.MCPConfig, buildContext, imageName, platform, tarFilePath string) error {
+	file, err := os.Create(tarFilePath)
+	if err != nil {
+		return fmt.Errorf("failed to create image archive: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := zp.OCIBuilder.Build(mcpConfig, buildContext, imageName, platform, &contextWriter{ctx: ctx, w: file}); err != nil {
+		return fmt.Errorf("daemonless build failed: %w", err)
+	}
+	return file.Close()
+}
+
+// removeImage removes an image a push built once the push is done with it. It gets a context of its
+// own, as the push's may be cancelled by then.
+func (zp *ZipProcessor) removeImage(imageName string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	zp.engine.RemoveImage(ctx, imageName)
 }
 
 // saveDockerImage saves the specified Docker image to a tarball
-func (zp *ZipProcessor) saveDockerImage(imageName, tarFilePath string) error {
-	cmd := exec.Command("docker", "save", "-o", tarFilePath, imageName)
-	output, err := cmd.CombinedOutput()
+func (zp *ZipProcessor) saveDockerImage(ctx context.Context, imageName, tarFilePath string) error {
+	file, err := os.Create(tarFilePath)
 	if err != nil {
-		return fmt.Errorf("docker save failed: %w\nOutput: %s", err, output)
+		return fmt.Errorf("failed to create image archive: %w", err)
 	}
-	return nil
+	defer file.Close()
+
+	if err := zp.engine.SaveImage(ctx, imageName, file); err != nil {
+		return fmt.Errorf("image save failed: %w", err)
+	}
+	return file.Close()
+}
+
+// stepTimer records how long each step of processing a zip takes
+type stepTimer struct {
+	start   time.Time
+	timings []models.StepTiming
+}
+
+func newStepTimer() *stepTimer {
+	return &stepTimer{start: time.Now()}
+}
+
+// done records step as taking the time since the previous step was done
+func (t *stepTimer) done(step string) {
+	now := time.Now()
+	t.timings = append(t.timings, models.StepTiming{Step: step, DurationMs: now.Sub(t.start).Milliseconds()})
+	t.start = now
+}
+
+// contextWriter fails writes once ctx is done, stopping work that does not take a context
+type contextWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+func (c *contextWriter) Write(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.w.Write(p)
 }
@@ -0,0 +1,179 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"mcphub/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testZip(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := archive.Create(name)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, archive.Close())
+	return buf.Bytes()
+}
+
+func TestProcessZipWorkDir(t *testing.T) {
+	cacheDir := t.TempDir()
+	writeTestBaseLayout(t, BaseImageCachePath(cacheDir, "python:3.11-slim"))
+	root := t.TempDir()
+	processor := NewZipProcessor(nil)
+	processor.OCIBuilder = &OCIBuilder{BaseCacheDir: cacheDir}
+	processor.WorkDir = root
+
+	server := testZip(t, map[string]string{
+		"weather/mcp.json":  `{"name":"Weather","run":{"command":"python","args":["server.py"]}}`,
+		"weather/server.py": "print('weather')\n",
+	})
+
+	// Zips with the same name are processed at once in separate work directories
+	results := make([]*models.DockerfileResponse, 2)
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := processor.ProcessZip(context.Background(), server, "weather.zip")
+			assert.NoError(t, err)
+			results[i] = result
+		}()
+	}
+	wg.Wait()
+	require.NotNil(t, results[0])
+	require.NotNil(t, results[1])
+	assert.NotEqual(t, results[0].WorkDir, results[1].WorkDir)
+	for _, result := range results {
+		assert.True(t, strings.HasPrefix(result.WorkDir, root))
+		assert.FileExists(t, result.TarFilePath)
+		assert.FileExists(t, filepath.Join(result.ExtractedPath, "server.py"))
+		var steps []string
+		for _, timing := range result.Timings {
+			steps = append(steps, timing.Step)
+		}
+		assert.Equal(t, []string{"extract", "generate", "build"}, steps)
+		require.NoError(t, processor.Cleanup(result))
+		assert.NoDirExists(t, result.WorkDir)
+	}
+
+	// Failed zips leave nothing behind, unless asked to
+	broken := testZip(t, map[string]string{"server.py": "print('no config')\n"})
+	_, err := processor.ProcessZip(context.Background(), broken, "broken.zip")
+	assert.ErrorContains(t, err, "mcp.json not found")
+	entries, err := os.ReadDir(root)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	processor.KeepWorkDir = true
+	_, err = processor.ProcessZip(context.Background(), broken, "broken.zip")
+	assert.ErrorContains(t, err, "work directory kept at "+root)
+	entries, err = os.ReadDir(root)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestExtractZipContainment(t *testing.T) {
+	root := t.TempDir()
+	data := testZip(t, map[string]string{
+		"mcp.json":                          `{"name":"weather","run":{"command":"python"}}`,
+		"../mcphub-other-123/src/server.py": "print('hijacked')\n",
+	})
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+
+	extractDir := filepath.Join(root, "mcphub-weather-1", "src")
+	err = NewZipProcessor(nil).extractZip(reader, extractDir)
+	assert.ErrorContains(t, err, "escapes the destination")
+	assert.NoFileExists(t, filepath.Join(root, "mcphub-weather-1", "mcphub-other-123", "src", "server.py"))
+	assert.NoFileExists(t, filepath.Join(root, "mcphub-other-123", "src", "server.py"))
+}
+
+func TestProcessZipBuildTags(t *testing.T) {
+	var mu sync.Mutex
+	var built, removed, tagged []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/build", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		built = append(built, r.URL.Query().Get("t"))
+		mu.Unlock()
+		w.Write([]byte(`{"stream":"Successfully built"}` + "\n"))
+	})
+	mux.HandleFunc("/images/get", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("image " + r.URL.Query().Get("names")))
+	})
+	mux.HandleFunc("/images/", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Method == http.MethodPost {
+			assert.Equal(t, "weather", r.URL.Query().Get("repo"))
+			tagged = append(tagged, strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/images/"), "/tag"))
+			return
+		}
+		require.Equal(t, http.MethodDelete, r.Method)
+		removed = append(removed, strings.TrimPrefix(r.URL.Path, "/images/"))
+		w.Write([]byte("[]"))
+	})
+	processor := NewZipProcessor(newFakeEngineAPI(t, mux))
+	processor.SkipProbe = true
+	processor.WorkDir = t.TempDir()
+
+	// Servers of different authors may share a name, and the same server may be pushed twice at once
+	var zips [][]byte
+	for _, author := range []string{"acme", "Bob Smith", "acme"} {
+		zips = append(zips, testZip(t, map[string]string{
+			"mcp.json":  `{"name":"Weather","author":"` + author + `","run":{"command":"python","args":["server.py"]}}`,
+			"server.py": "print('weather')\n",
+		}))
+	}
+	results := make([]*models.DockerfileResponse, len(zips))
+	var wg sync.WaitGroup
+	for i := range zips {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := processor.ProcessZip(context.Background(), zips[i], "weather.zip")
+			assert.NoError(t, err)
+			results[i] = result
+		}()
+	}
+	wg.Wait()
+
+	// Each push saves the image it built itself, and removes its tag once saved, leaving the
+	// image under its own name for mcphub run
+	require.Len(t, built, 3)
+	assert.ElementsMatch(t, built, removed)
+	assert.ElementsMatch(t, built, tagged)
+	for i, result := range results {
+		require.NotNil(t, result)
+		assert.Equal(t, "weather", result.ImageName)
+		data, err := os.ReadFile(result.TarFilePath)
+		require.NoError(t, err)
+		tag := strings.TrimPrefix(string(data), "image ")
+		assert.Contains(t, built, tag)
+		for j, other := range results {
+			if j != i {
+				otherData, _ := os.ReadFile(other.TarFilePath)
+				assert.NotEqual(t, string(data), string(otherData))
+			}
+		}
+		require.NoError(t, processor.Cleanup(result))
+	}
+	assert.True(t, strings.HasPrefix(built[0], "mcphub-build/"))
+	assert.Equal(t, "bob-smith/weather", serverRepository(&results[1].Config))
+}